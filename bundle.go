@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jlegrone/tctx/internal/bundle"
+)
+
+// promptStrategy returns a function which asks how to handle each conflicting
+// context, reading answers from r.
+func promptStrategy(r io.Reader, w io.Writer) func(name string) (bundle.Strategy, error) {
+	scanner := bufio.NewScanner(r)
+	return func(name string) (bundle.Strategy, error) {
+		for {
+			_, _ = fmt.Fprintf(w, "Context %q already exists with different settings. [s]kip, [o]verwrite or [r]ename? ", name)
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", fmt.Errorf("no answer given for conflicting context %q", name)
+			}
+			switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+			case "s", "skip":
+				return bundle.Skip, nil
+			case "o", "overwrite":
+				return bundle.Overwrite, nil
+			case "r", "rename":
+				return bundle.Rename, nil
+			}
+		}
+	}
+}
+
+// printReport writes a summary of the changes made by an import or sync.
+func printReport(w io.Writer, report *bundle.Report) error {
+	for _, line := range []struct {
+		label string
+		names []string
+	}{
+		{"Added", report.Added},
+		{"Changed", report.Changed},
+		{"Removed", report.Removed},
+		{"Skipped", report.Skipped},
+	} {
+		if len(line.names) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", line.label, strings.Join(line.names, ", ")); err != nil {
+			return err
+		}
+	}
+	var renamed []string
+	for from := range report.Renamed {
+		renamed = append(renamed, from)
+	}
+	sort.Strings(renamed)
+	for _, from := range renamed {
+		if _, err := fmt.Fprintf(w, "Renamed %q to %q.\n", from, report.Renamed[from]); err != nil {
+			return err
+		}
+	}
+	for _, name := range report.NeedsSecret {
+		if _, err := fmt.Fprintf(w, "Context %q needs an auth secret reference: set it with `tctx update -c %s --%s <ref>`.\n", name, name, authSecretRefFlag); err != nil {
+			return err
+		}
+	}
+	if len(report.Added)+len(report.Changed)+len(report.Removed) == 0 {
+		_, err := fmt.Fprintln(w, "No contexts changed.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package client builds connection settings for Temporal SDK clients from
+// tctx contexts, so that services and test harnesses can connect to the same
+// clusters as the tctx command line.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/certprovider"
+	"github.com/jlegrone/tctx/internal/headersprovider"
+)
+
+// Options holds everything needed to open a connection to a Temporal cluster.
+type Options struct {
+	// host:port for Temporal frontend service
+	HostPort string
+	// Temporal workflow namespace
+	Namespace string
+	// TLS configuration for the connection, or nil when TLS is disabled
+	TLS *tls.Config
+	// Headers to be attached as gRPC metadata on every request
+	Headers map[string]string
+	// HeadersProvider supplies additional headers for every request, or nil
+	HeadersProvider HeadersProvider
+}
+
+// HeadersProvider supplies gRPC headers, for example short-lived credentials.
+// It is compatible with the HeadersProvider option of the Temporal Go SDK.
+type HeadersProvider interface {
+	GetHeaders(ctx context.Context) (map[string]string, error)
+}
+
+// Load returns connection options for the named context. When contextName is
+// empty, the active context is used.
+func Load(ctx context.Context, t *config.ConfigManager, contextName string) (*Options, error) {
+	var (
+		cfg *config.ClusterConfig
+		err error
+	)
+	if contextName == "" {
+		cfg, err = t.GetActiveContext()
+	} else {
+		cfg, err = t.GetContext(contextName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewOptions(ctx, cfg)
+}
+
+// NewOptions returns connection options for the given cluster configuration.
+// ctx bounds the certificate provider command, if the context has one.
+func NewOptions(ctx context.Context, cfg *config.ClusterConfig) (*Options, error) {
+	resolved, err := certprovider.Resolve(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := NewTLSConfig(resolved.GetTLS(), cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil && cfg.GetTLS().CertProvider != "" {
+		// Issued certificates are short-lived, so connections opened later,
+		// for example by a long-running proxy, ask the provider again
+		tlsConfig.Certificates = nil
+		tlsConfig.GetClientCertificate = providedCertificate(cfg)
+	}
+	result := &Options{
+		HostPort:  cfg.Address,
+		Namespace: cfg.Namespace,
+		TLS:       tlsConfig,
+	}
+	var providers headersProviders
+	if cfg.HeadersProvider != "" {
+		providers = append(providers, headersprovider.New(cfg.HeadersProvider))
+	}
+	if cfg.Auth != nil {
+		p, err := headersprovider.NewAuth(*cfg.Auth)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	switch len(providers) {
+	case 0:
+	case 1:
+		result.HeadersProvider = providers[0]
+	default:
+		result.HeadersProvider = providers
+	}
+	return result, nil
+}
+
+// providedCertificate returns a function loading the client certificate
+// issued by the certificate provider of cfg, which is only run again when the
+// cached certificate is about to expire.
+func providedCertificate(cfg *config.ClusterConfig) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		ctx := info.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		resolved, err := certprovider.Resolve(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(resolved.TLS.CertPath, resolved.TLS.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		return &cert, nil
+	}
+}
+
+// headersProviders merges the headers of multiple providers, with later
+// providers taking precedence.
+type headersProviders []HeadersProvider
+
+func (p headersProviders) GetHeaders(ctx context.Context) (map[string]string, error) {
+	result := map[string]string{}
+	for _, provider := range p {
+		headers, err := provider.GetHeaders(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+// NewTLSConfig builds a *tls.Config from a context's TLS settings. A nil config
+// is returned when no TLS settings are present.
+func NewTLSConfig(cfg config.TLSConfig, hostPort string) (*tls.Config, error) {
+	if cfg == (config.TLSConfig{}) {
+		return nil, nil
+	}
+
+	result := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.DisableHostVerification,
+	}
+	// Default to the host name of the frontend address, like tctl
+	if result.ServerName == "" {
+		if host, _, err := net.SplitHostPort(hostPort); err == nil {
+			result.ServerName = host
+		}
+	}
+
+	certPEM, err := cfg.CertPEM()
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate: %w", err)
+	}
+	keyPEM, err := cfg.KeyPEM()
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate: %w", err)
+	}
+	if certPEM != nil || keyPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	caPEM, err := cfg.CAPEM()
+	if err != nil {
+		return nil, fmt.Errorf("error reading server CA certificate: %w", err)
+	}
+	if caPEM != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			source := cfg.CACertPath
+			if cfg.CAData != "" {
+				source = "caData"
+			}
+			return nil, fmt.Errorf("no certificates found in %s", source)
+		}
+		result.RootCAs = pool
+	}
+	return result, nil
+}
+
+// DialOptions returns the gRPC dial options for connecting with these options.
+func (o *Options) DialOptions() []grpc.DialOption {
+	creds := insecure.NewCredentials()
+	if o.TLS != nil {
+		creds = credentials.NewTLS(o.TLS)
+	}
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(o.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(o.streamInterceptor),
+	}
+}
+
+// Dial opens a gRPC connection to the Temporal frontend service.
+func (o *Options) Dial(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return grpc.NewClient(o.HostPort, append(o.DialOptions(), opts...)...)
+}
+
+func (o *Options) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, err := o.withHeaders(ctx)
+	if err != nil {
+		return err
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (o *Options) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, err := o.withHeaders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+func (o *Options) withHeaders(ctx context.Context) (context.Context, error) {
+	headers := map[string]string{}
+	for k, v := range o.Headers {
+		headers[k] = v
+	}
+	if o.HeadersProvider != nil {
+		provided, err := o.HeadersProvider.GetHeaders(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range provided {
+			headers[k] = v
+		}
+	}
+	if len(headers) == 0 {
+		return ctx, nil
+	}
+
+	// Replace rather than append, so that headers already present on the
+	// request (for example when proxying) are overridden.
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, v := range headers {
+		md.Set(k, v)
+	}
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
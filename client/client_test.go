@@ -0,0 +1,455 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	namespacepb "go.temporal.io/api/namespace/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/certprovider"
+)
+
+func TestLoadMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certs := generateCerts(t, dir)
+
+	serverCert, err := tls.LoadX509KeyPair(certs.serverCert, certs.serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    certs.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+
+	m, err := config.NewConfigManager(config.WithConfigFile(filepath.Join(dir, "config.json")), config.WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("mtls", &config.ClusterConfig{
+		Address:   addr,
+		Namespace: "default",
+		TLS: &config.TLSConfig{
+			CertPath:   certs.clientCert,
+			KeyPath:    certs.clientKey,
+			CACertPath: certs.caCert,
+			ServerName: "temporal.test",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetActiveContext("mtls", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty context name should resolve to the active context
+	opts, err := Load(context.Background(), m, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.HostPort != addr {
+		t.Errorf("expected host:port %q, got %q", addr, opts.HostPort)
+	}
+	if opts.Namespace != "default" {
+		t.Errorf("expected namespace %q, got %q", "default", opts.Namespace)
+	}
+	checkHealth(t, opts)
+
+	// A client without a certificate should be rejected by the server
+	opts.TLS.Certificates = nil
+	conn, err := opts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := CheckHealth(ctx, conn); err == nil {
+		t.Error("expected health check without client certificate to fail")
+	}
+}
+
+func TestInlineTLSData(t *testing.T) {
+	dir := t.TempDir()
+	certs := generateCerts(t, dir)
+
+	serverCert, err := tls.LoadX509KeyPair(certs.serverCert, certs.serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    certs.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+
+	read := func(path string) string {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	t.Setenv("TCTX_TEST_CLIENT_KEY", read(certs.clientKey))
+
+	// Data is used as PEM, base64 encoded PEM, or a secret reference
+	opts, err := NewOptions(context.Background(), &config.ClusterConfig{
+		Address: addr,
+		TLS: &config.TLSConfig{
+			CertData:   read(certs.clientCert),
+			KeyData:    "env:TCTX_TEST_CLIENT_KEY",
+			CAData:     base64.StdEncoding.EncodeToString([]byte(read(certs.caCert))),
+			ServerName: "temporal.test",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHealth(t, opts)
+}
+
+func TestLoadDisableHostVerification(t *testing.T) {
+	dir := t.TempDir()
+	certs := generateCerts(t, dir)
+
+	serverCert, err := tls.LoadX509KeyPair(certs.serverCert, certs.serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+	})))
+
+	opts, err := NewOptions(context.Background(), &config.ClusterConfig{
+		Address: addr,
+		TLS:     &config.TLSConfig{DisableHostVerification: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHealth(t, opts)
+}
+
+func TestNewOptionsInsecure(t *testing.T) {
+	addr := startServer(t)
+
+	opts, err := NewOptions(context.Background(), &config.ClusterConfig{
+		Address:   addr,
+		Namespace: "default",
+		TLS:       &config.TLSConfig{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.TLS != nil {
+		t.Errorf("expected TLS to be disabled, got %+v", opts.TLS)
+	}
+	checkHealth(t, opts)
+}
+
+func TestHeaders(t *testing.T) {
+	addr := startServer(t)
+
+	opts, err := NewOptions(context.Background(), &config.ClusterConfig{Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Headers = map[string]string{"x-static": "foo"}
+	opts.HeadersProvider = staticHeadersProvider{"authorization": "Bearer bar"}
+
+	conn, err := opts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The stand-in server returns request headers as namespace names
+	namespaces, err := ListNamespaces(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"authorization=Bearer bar", "x-static=foo"}
+	if strings.Join(namespaces, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected namespaces %v, got %v", expected, namespaces)
+	}
+}
+
+func TestNewOptionsErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tlsConfig := range map[string]*config.TLSConfig{
+		"missing key pair": {CertPath: filepath.Join(dir, "missing.pem"), KeyPath: filepath.Join(dir, "missing.key")},
+		"missing ca":       {CACertPath: filepath.Join(dir, "missing.pem")},
+		"invalid ca":       {CACertPath: notPEM},
+		"invalid ca data":  {CAData: "not a certificate"},
+		"missing key data": {CertData: "-----BEGIN CERTIFICATE-----\n", KeyData: "env:TCTX_TEST_MISSING_KEY"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewOptions(context.Background(), &config.ClusterConfig{Address: "localhost:7233", TLS: tlsConfig}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestNewOptionsCancelsCertProvider(t *testing.T) {
+	// Keep the certificate cache out of the user's home directory
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewOptions(ctx, &config.ClusterConfig{
+		Address: "localhost:7233",
+		TLS:     &config.TLSConfig{CertProvider: "sleep 10"},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected certificate provider to be stopped with ctx, took %s", elapsed)
+	}
+}
+
+func TestCertProviderRefresh(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	certs := generateCerts(t, dir)
+
+	serverCert, err := tls.LoadX509KeyPair(certs.serverCert, certs.serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    certs.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+
+	// The credential expires within certprovider.RefreshBefore, so it is
+	// issued again whenever a certificate is needed
+	certPEM, err := os.ReadFile(certs.clientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := os.ReadFile(certs.clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.Now().Add(time.Minute)
+	b, err := json.Marshal(certprovider.Credential{
+		ClientCertificateData: string(certPEM),
+		ClientKeyData:         string(keyPEM),
+		ExpirationTimestamp:   &expiration,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credPath, countPath := filepath.Join(dir, "credential.json"), filepath.Join(dir, "runs")
+	if err := os.WriteFile(credPath, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	runs := func() int {
+		b, _ := os.ReadFile(countPath)
+		return strings.Count(string(b), "run")
+	}
+
+	opts, err := NewOptions(context.Background(), &config.ClusterConfig{
+		Address: addr,
+		TLS: &config.TLSConfig{
+			CertProvider: fmt.Sprintf("echo run >> %q && cat %q", countPath, credPath),
+			CACertPath:   certs.caCert,
+			ServerName:   "temporal.test",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs() != 1 {
+		t.Fatalf("expected provider to run once, ran %d times", runs())
+	}
+	checkHealth(t, opts)
+	if runs() != 2 {
+		t.Errorf("expected provider to run again for the connection, ran %d times", runs())
+	}
+}
+
+func checkHealth(t *testing.T, opts *Options) {
+	t.Helper()
+	conn, err := opts.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := CheckHealth(ctx, conn); err != nil {
+		t.Fatalf("health check failed: %s", err)
+	}
+}
+
+// startServer starts a gRPC server standing in for the Temporal frontend and
+// returns its address.
+func startServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(healthService, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+	workflowservice.RegisterWorkflowServiceServer(s, &workflowService{})
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+type staticHeadersProvider map[string]string
+
+func (p staticHeadersProvider) GetHeaders(context.Context) (map[string]string, error) {
+	return p, nil
+}
+
+// workflowService lists one namespace per request header beginning with "x-"
+// or "authorization", spread across pages.
+type workflowService struct {
+	workflowservice.UnimplementedWorkflowServiceServer
+}
+
+func (s *workflowService) ListNamespaces(ctx context.Context, req *workflowservice.ListNamespacesRequest) (*workflowservice.ListNamespacesResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var names []string
+	for k, v := range md {
+		if strings.HasPrefix(k, "x-") || k == "authorization" {
+			names = append(names, k+"="+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+
+	page, _ := strconv.Atoi(string(req.GetNextPageToken()))
+	resp := &workflowservice.ListNamespacesResponse{}
+	if page < len(names) {
+		resp.Namespaces = []*workflowservice.DescribeNamespaceResponse{{
+			NamespaceInfo: &namespacepb.NamespaceInfo{Name: names[page]},
+		}}
+		if page+1 < len(names) {
+			resp.NextPageToken = []byte(strconv.Itoa(page + 1))
+		}
+	}
+	return resp, nil
+}
+
+type testCerts struct {
+	pool                                                 *x509.CertPool
+	caCert, serverCert, serverKey, clientCert, clientKey string
+}
+
+// generateCerts writes a CA along with server and client certificates signed by
+// it to dir.
+func generateCerts(t *testing.T, dir string) *testCerts {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tctx test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &testCerts{
+		pool:   x509.NewCertPool(),
+		caCert: filepath.Join(dir, "ca.pem"),
+	}
+	result.pool.AddCert(ca)
+	writePEM(t, result.caCert, "CERTIFICATE", caDER)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) (certPath, keyPath string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{"temporal.test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPath, keyPath = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+		writePEM(t, certPath, "CERTIFICATE", der)
+		writePEM(t, keyPath, "PRIVATE KEY", keyDER)
+		return certPath, keyPath
+	}
+	result.serverCert, result.serverKey = issue(2, "server", x509.ExtKeyUsageServerAuth)
+	result.clientCert, result.clientKey = issue(3, "client", x509.ExtKeyUsageClientAuth)
+
+	return result
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.temporal.io/api/workflowservice/v1"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthService is the service name reported by the Temporal frontend health
+// check.
+const healthService = "temporal.api.workflowservice.v1.WorkflowService"
+
+// CheckHealth returns an error if the Temporal frontend service is not serving.
+func CheckHealth(ctx context.Context, conn grpc.ClientConnInterface) error {
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: healthService,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("frontend service is %s", resp.Status)
+	}
+	return nil
+}
+
+// ListNamespaces returns the sorted names of all namespaces in the cluster.
+func ListNamespaces(ctx context.Context, conn grpc.ClientConnInterface) ([]string, error) {
+	var (
+		names []string
+		token []byte
+	)
+	c := workflowservice.NewWorkflowServiceClient(conn)
+	for {
+		resp, err := c.ListNamespaces(ctx, &workflowservice.ListNamespacesRequest{
+			NextPageToken: token,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range resp.GetNamespaces() {
+			names = append(names, ns.GetNamespaceInfo().GetName())
+		}
+		if token = resp.GetNextPageToken(); len(token) == 0 {
+			break
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
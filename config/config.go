@@ -4,41 +4,131 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 )
 
 type TLSConfig struct {
 	// Path to x509 certificate
-	CertPath string `json:"certPath"`
+	CertPath string `json:"certPath" yaml:"certPath" toml:"certPath"`
 	// Path to private key
-	KeyPath string `json:"keyPath"`
+	KeyPath string `json:"keyPath" yaml:"keyPath" toml:"keyPath"`
 	// Path to server CA certificate
-	CACertPath string `json:"caPath"`
+	CACertPath string `json:"caPath" yaml:"caPath" toml:"caPath"`
 	// Disable tls host name verification (tls must be enabled)
-	DisableHostVerification bool `json:"disableHostVerification"`
+	DisableHostVerification bool `json:"disableHostVerification" yaml:"disableHostVerification" toml:"disableHostVerification"`
 	// Override for target server name
-	ServerName string `json:"serverName"`
+	ServerName string `json:"serverName" yaml:"serverName" toml:"serverName"`
+	// Inline x509 certificate, PEM or base64 encoded PEM, used in place of CertPath
+	CertData string `json:"certData,omitempty" yaml:"certData,omitempty" toml:"certData,omitempty"`
+	// Inline private key, PEM or base64 encoded PEM, or a reference such as
+	// "env:NAME" or "file:PATH"; used in place of KeyPath
+	KeyData string `json:"keyData,omitempty" yaml:"keyData,omitempty" toml:"keyData,omitempty"`
+	// Inline server CA certificate, PEM or base64 encoded PEM, used in place of CACertPath
+	CAData string `json:"caData,omitempty" yaml:"caData,omitempty" toml:"caData,omitempty"`
+	// Command issuing a short-lived client certificate, used in place of
+	// CertPath and KeyPath
+	CertProvider string `json:"certProvider,omitempty" yaml:"certProvider,omitempty" toml:"certProvider,omitempty"`
+}
+
+const (
+	// AuthTypeAPIKey authenticates with a Temporal Cloud style API key
+	AuthTypeAPIKey = "apikey"
+	// AuthTypeBearerStatic authenticates with a long-lived bearer token
+	AuthTypeBearerStatic = "bearer-static"
+	// AuthTypeBearerExec authenticates with a bearer token printed by a command
+	AuthTypeBearerExec = "bearer-exec"
+)
+
+type AuthConfig struct {
+	// One of "apikey", "bearer-static" or "bearer-exec"
+	Type string `json:"type" yaml:"type" toml:"type"`
+	// Header carrying the credential (default: "authorization")
+	Header string `json:"header,omitempty" yaml:"header,omitempty" toml:"header,omitempty"`
+	// Reference to the API key or token, such as "env:NAME" or "file:/path/to/token"
+	SecretRef string `json:"secretRef,omitempty" yaml:"secretRef,omitempty" toml:"secretRef,omitempty"`
+	// Command which prints a bearer token to stdout
+	Command string `json:"command,omitempty" yaml:"command,omitempty" toml:"command,omitempty"`
 }
 
 type ClusterConfig struct {
+	// Free-text description of the context
+	Description string `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+	// Labels used to select and group contexts, such as "env": "prod"
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+	// Color of the context in shell prompts, such as "red"
+	Color string `json:"color,omitempty" yaml:"color,omitempty" toml:"color,omitempty"`
 	// host:port for Temporal frontend service
-	Address string `json:"address"`
+	Address string `json:"address" yaml:"address" toml:"address"`
 	// Web UI Link
-	WebAddress string `json:"webAddress"`
+	WebAddress string `json:"webAddress" yaml:"webAddress" toml:"webAddress"`
 	// Temporal workflow namespace (default: "default")
-	Namespace string `json:"namespace"`
+	Namespace string `json:"namespace" yaml:"namespace" toml:"namespace"`
 	// Headers provider plugin executable name
-	HeadersProvider string `json:"headersProvider"`
+	HeadersProvider string `json:"headersProvider" yaml:"headersProvider" toml:"headersProvider"`
 	// Data converter plugin executable name
-	DataConverter string     `json:"dataConverter"`
-	TLS           *TLSConfig `json:"tls,omitempty"`
+	DataConverter string     `json:"dataConverter" yaml:"dataConverter" toml:"dataConverter"`
+	TLS           *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" toml:"tls,omitempty"`
+	// API key or bearer token authentication
+	Auth *AuthConfig `json:"auth,omitempty" yaml:"auth,omitempty" toml:"auth,omitempty"`
 	// Any additional environment variables that are needed
-	Environment map[string]string `json:"additional,omitempty"`
+	Environment map[string]string `json:"additional,omitempty" yaml:"additional,omitempty" toml:"additional,omitempty"`
+	// Commands run on lifecycle events of this context
+	Hooks *Hooks `json:"hooks,omitempty" yaml:"hooks,omitempty" toml:"hooks,omitempty"`
+}
+
+// Hooks are shell commands run on context lifecycle events, in order. Pre-use
+// and pre-exec hooks abort the action when they fail.
+type Hooks struct {
+	PreUse     []string `json:"preUse,omitempty" yaml:"preUse,omitempty" toml:"preUse,omitempty"`
+	PostUse    []string `json:"postUse,omitempty" yaml:"postUse,omitempty" toml:"postUse,omitempty"`
+	PreExec    []string `json:"preExec,omitempty" yaml:"preExec,omitempty" toml:"preExec,omitempty"`
+	PostExec   []string `json:"postExec,omitempty" yaml:"postExec,omitempty" toml:"postExec,omitempty"`
+	PostAdd    []string `json:"postAdd,omitempty" yaml:"postAdd,omitempty" toml:"postAdd,omitempty"`
+	PostDelete []string `json:"postDelete,omitempty" yaml:"postDelete,omitempty" toml:"postDelete,omitempty"`
+	// Maximum run time of each command, such as "10s" (default: 30s)
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+}
+
+// PromptConfig configures `tctx prompt`.
+type PromptConfig struct {
+	// Go template rendering the prompt segment (default: "{{.Context}}:{{.Namespace}}")
+	Format string `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty"`
+	// Colors of contexts without a color of their own, by name pattern. The
+	// first matching rule applies.
+	Colors []ColorRule `json:"colors,omitempty" yaml:"colors,omitempty" toml:"colors,omitempty"`
+}
+
+// ColorRule colors the contexts whose names match a pattern, such as "prod-*".
+type ColorRule struct {
+	Pattern string `json:"pattern" yaml:"pattern" toml:"pattern"`
+	Color   string `json:"color" yaml:"color" toml:"color"`
+}
+
+// NamespaceState holds the user's namespace choices for a context. It is kept
+// apart from the context definition, so that switching namespaces never
+// changes the context itself and works for contexts defined in read-only
+// config files.
+type NamespaceState struct {
+	// Namespace selected by `tctx use --ns`, in place of the context's namespace
+	Current string `json:"current,omitempty" yaml:"current,omitempty" toml:"current,omitempty"`
+	// Favorite namespaces, listed first in menus and completions
+	Favorites []string `json:"favorites,omitempty" yaml:"favorites,omitempty" toml:"favorites,omitempty"`
 }
 
 type Config struct {
-	ActiveContext string `json:"active"`
+	ActiveContext string `json:"active" yaml:"active" toml:"active"`
 	// Map of context names to cluster configuration
-	Contexts map[string]*ClusterConfig `json:"contexts"`
+	Contexts map[string]*ClusterConfig `json:"contexts" yaml:"contexts" toml:"contexts"`
+	// Map of context names to the user's namespace choices for each context
+	Namespaces map[string]*NamespaceState `json:"namespaces,omitempty" yaml:"namespaces,omitempty" toml:"namespaces,omitempty"`
+	// Commands run on lifecycle events of every context
+	Hooks *Hooks `json:"hooks,omitempty" yaml:"hooks,omitempty" toml:"hooks,omitempty"`
+	// Shell prompt settings
+	Prompt *PromptConfig `json:"prompt,omitempty" yaml:"prompt,omitempty" toml:"prompt,omitempty"`
+	// Map of sync source names to the names of contexts they manage
+	Managed map[string][]string `json:"managed,omitempty" yaml:"managed,omitempty" toml:"managed,omitempty"`
+	// Map of context names to the path of the config file defining them
+	Sources map[string]string `json:"-" yaml:"-" toml:"-"`
 }
 
 func (c ClusterConfig) GetTLS() TLSConfig {
@@ -48,8 +138,115 @@ func (c ClusterConfig) GetTLS() TLSConfig {
 	return *c.TLS
 }
 
+// Clone returns a deep copy of the cluster configuration.
+func (c *ClusterConfig) Clone() *ClusterConfig {
+	result := *c
+	if c.TLS != nil {
+		tls := *c.TLS
+		result.TLS = &tls
+	}
+	if c.Auth != nil {
+		auth := *c.Auth
+		result.Auth = &auth
+	}
+	if c.Labels != nil {
+		result.Labels = make(map[string]string, len(c.Labels))
+		for k, v := range c.Labels {
+			result.Labels[k] = v
+		}
+	}
+	if c.Environment != nil {
+		result.Environment = make(map[string]string, len(c.Environment))
+		for k, v := range c.Environment {
+			result.Environment[k] = v
+		}
+	}
+	if c.Hooks != nil {
+		hooks := *c.Hooks
+		result.Hooks = &hooks
+	}
+	return &result
+}
+
+// RemoveCommands clears the settings of c which run commands on this machine:
+// hooks, the TLS certificate provider, the headers provider and data converter
+// plugins, bearer-exec auth, and environment variables for which
+// IsUnsafeEnvVar is true. They are never accepted from bundles or shared
+// contexts.
+func (c *ClusterConfig) RemoveCommands() {
+	c.Hooks = nil
+	c.HeadersProvider = ""
+	c.DataConverter = ""
+	if c.TLS != nil {
+		c.TLS.CertProvider = ""
+	}
+	if c.Auth != nil && (c.Auth.Type == AuthTypeBearerExec || c.Auth.Command != "") {
+		c.Auth = nil
+	}
+	for k := range c.Environment {
+		if IsUnsafeEnvVar(k) {
+			delete(c.Environment, k)
+		}
+	}
+}
+
+// KeepCommands copies the settings of local which RemoveCommands clears into
+// c, so that updates from a bundle keep the user's own commands.
+func (c *ClusterConfig) KeepCommands(local *ClusterConfig) {
+	c.Hooks = local.Hooks
+	c.HeadersProvider = local.HeadersProvider
+	c.DataConverter = local.DataConverter
+	for k, v := range local.Environment {
+		if !IsUnsafeEnvVar(k) {
+			continue
+		}
+		if c.Environment == nil {
+			c.Environment = map[string]string{}
+		}
+		c.Environment[k] = v
+	}
+	if tls := local.GetTLS(); tls.CertProvider != "" {
+		if c.TLS == nil {
+			c.TLS = &TLSConfig{}
+		}
+		c.TLS.CertProvider = tls.CertProvider
+	}
+	if c.Auth == nil && local.Auth != nil && local.Auth.Type == AuthTypeBearerExec {
+		auth := *local.Auth
+		c.Auth = &auth
+	}
+}
+
+// GetHeader returns the name of the header carrying the credential.
+func (a AuthConfig) GetHeader() string {
+	if a.Header == "" {
+		return "authorization"
+	}
+	return a.Header
+}
+
+// Validate returns an error if the auth configuration is incomplete.
+func (a AuthConfig) Validate() error {
+	switch a.Type {
+	case AuthTypeAPIKey, AuthTypeBearerStatic:
+		if a.SecretRef == "" {
+			return fmt.Errorf("auth type %q requires a secret reference", a.Type)
+		}
+		return ValidateSecretRef(a.SecretRef)
+	case AuthTypeBearerExec:
+		if a.Command == "" {
+			return fmt.Errorf("auth type %q requires a command", a.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown auth type %q: must be one of %q, %q or %q",
+			a.Type, AuthTypeAPIKey, AuthTypeBearerStatic, AuthTypeBearerExec)
+	}
+}
+
 // GetDefaultConfigPath returns the path to the current user's default tctx config file.
-// On unix systems, this will be `$XDG_CONFIG_HOME/tctx/config.json`.
+// On unix systems, this will be `$XDG_CONFIG_HOME/tctx/config.json`, or the
+// equivalent `config.yaml` or `config.toml` file if one exists.
 func GetDefaultConfigPath() (string, error) {
 	userConfigDir, err := os.UserConfigDir()
 	if err != nil {
@@ -59,5 +256,39 @@ func GetDefaultConfigPath() (string, error) {
 }
 
 func getConfigPath(userConfigDir string) string {
+	// Prefer an existing config file in any supported format
+	for _, name := range []string{"config.json", "config.yaml", "config.yml", "config.toml"} {
+		path := filepath.Join(userConfigDir, "tctx", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
 	return filepath.Join(userConfigDir, "tctx", "config.json")
 }
+
+// GetDefaultLayerPaths returns the paths of read-only config files shared by
+// teams or the organization, in order of decreasing precedence: each path in
+// the `TCTX_CONFIG_PATHS` environment variable (separated like `PATH`),
+// followed by the system config file.
+func GetDefaultLayerPaths() []string {
+	var paths []string
+	for _, p := range filepath.SplitList(os.Getenv("TCTX_CONFIG_PATHS")) {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return append(paths, getSystemConfigPath())
+}
+
+// getSystemConfigPath returns the path of the organization-wide config file:
+// `$TCTX_SYSTEM_CONFIG_PATH` if set, or else `/etc/tctx/config.json` on unix
+// systems.
+func getSystemConfigPath() string {
+	if path := os.Getenv("TCTX_SYSTEM_CONFIG_PATH"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "tctx", "config.json")
+	}
+	return filepath.Join("/etc", "tctx", "config.json")
+}
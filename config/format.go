@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats lists the supported config file formats, by file extension.
+var Formats = []string{"json", "yaml", "toml"}
+
+// format encodes and decodes config files.
+type format interface {
+	unmarshal(b []byte, cfg *Config) error
+	// marshal encodes cfg, preserving comments from the previous contents of
+	// the file where the format allows it.
+	marshal(cfg *Config, previous []byte) ([]byte, error)
+}
+
+// formatOf detects the format of a config file from its extension. Files
+// without a known extension are assumed to be JSON.
+func formatOf(path string) format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yamlFormat{}
+	case ".toml":
+		return tomlFormat{}
+	default:
+		return jsonFormat{}
+	}
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) unmarshal(b []byte, cfg *Config) error {
+	return json.Unmarshal(b, cfg)
+}
+
+func (jsonFormat) marshal(cfg *Config, _ []byte) ([]byte, error) {
+	return json.MarshalIndent(cfg, "", "	")
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) unmarshal(b []byte, cfg *Config) error {
+	return yaml.Unmarshal(b, cfg)
+}
+
+func (yamlFormat) marshal(cfg *Config, previous []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		return nil, err
+	}
+
+	// Carry over comments from the previous file
+	var previousNode yaml.Node
+	if err := yaml.Unmarshal(previous, &previousNode); err == nil && len(previousNode.Content) > 0 {
+		copyComments(&node, previousNode.Content[0])
+		node.HeadComment = joinComments(previousNode.HeadComment, node.HeadComment)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyComments copies comments from src to the corresponding nodes of dst.
+// Mapping entries are matched by key, and sequence items by index.
+func copyComments(dst, src *yaml.Node) {
+	dst.HeadComment = joinComments(src.HeadComment, dst.HeadComment)
+	dst.LineComment = joinComments(src.LineComment, dst.LineComment)
+	dst.FootComment = joinComments(src.FootComment, dst.FootComment)
+
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			for j := 0; j+1 < len(src.Content); j += 2 {
+				if dst.Content[i].Value == src.Content[j].Value {
+					copyComments(dst.Content[i], src.Content[j])
+					copyComments(dst.Content[i+1], src.Content[j+1])
+					break
+				}
+			}
+		}
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode:
+		for i := 0; i < len(dst.Content) && i < len(src.Content); i++ {
+			copyComments(dst.Content[i], src.Content[i])
+		}
+	}
+}
+
+func joinComments(a, b string) string {
+	if a == "" || a == b {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "\n" + b
+}
+
+type tomlFormat struct{}
+
+func (tomlFormat) unmarshal(b []byte, cfg *Config) error {
+	_, err := toml.Decode(string(b), cfg)
+	return err
+}
+
+// marshal encodes cfg as TOML. The TOML encoder does not support comments, so
+// these are lost when the file is rewritten.
+func (tomlFormat) marshal(cfg *Config, _ []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// validateFormat returns an error if ext is not a supported format.
+func validateFormat(ext string) error {
+	for _, f := range Formats {
+		if ext == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported config format %q: must be one of %s", ext, strings.Join(Formats, ", "))
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	expected, err := read(filepath.Join("testdata", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.Contexts["production"].GetTLS().ServerName != "internal.example.com" {
+		t.Fatalf("unexpected fixture contents: %+v", expected.Contexts["production"])
+	}
+
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		t.Run(name, func(t *testing.T) {
+			actual, err := read(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %s to match config.json", name)
+			}
+
+			// Round trip through the same format
+			path := copyFixture(t, name)
+			if err := write(path, actual); err != nil {
+				t.Fatal(err)
+			}
+			roundTrip, err := read(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(roundTrip, expected) {
+				t.Errorf("expected %s to be unchanged after round trip", name)
+			}
+		})
+	}
+}
+
+func TestYAMLPreservesComments(t *testing.T) {
+	path := copyFixture(t, "config.yaml")
+	m, err := NewConfigManager(WithConfigFile(path), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("staging", &ClusterConfig{Address: "staging:7233", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("production", &ClusterConfig{Namespace: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{
+		"# Contexts for the orders team\nactive: production",
+		"      # The load balancer presents a certificate for the internal host name\n      disableHostVerification: true",
+		"serverName: internal.example.com # must match the load balancer",
+		"namespace: orders",
+		"staging:",
+	} {
+		if !strings.Contains(string(b), text) {
+			t.Errorf("expected YAML config to contain %q. Got:\n%s", text, b)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	path := copyFixture(t, "config.json")
+	m, err := NewConfigManager(WithConfigFile(path), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := m.GetAllContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Convert("ini"); err == nil {
+		t.Error("expected error converting to unsupported format")
+	}
+	if _, err := m.Convert("json"); err == nil {
+		t.Error("expected error converting to the same format")
+	}
+
+	newPath, err := m.Convert("toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newPath != strings.TrimSuffix(path, ".json")+".toml" {
+		t.Errorf("unexpected converted config path %s", newPath)
+	}
+	if _, err := os.Stat(path + ".bak"); err != nil {
+		t.Errorf("expected previous config file to be kept as a backup: %s", err)
+	}
+
+	// Config manager now reads from the new file
+	actual, err := m.GetAllContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual.Contexts, expected.Contexts) {
+		t.Error("expected contexts to be unchanged after conversion")
+	}
+	if m.GetLayers()[0] != newPath {
+		t.Errorf("expected config manager to use %s, got %s", newPath, m.GetLayers()[0])
+	}
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	if path := getConfigPath(dir); path != filepath.Join(dir, "tctx", "config.json") {
+		t.Errorf("unexpected default config path %s", path)
+	}
+
+	yamlPath := filepath.Join(dir, "tctx", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(yamlPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(yamlPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if path := getConfigPath(dir); path != yamlPath {
+		t.Errorf("expected existing YAML config to be used, got %s", path)
+	}
+}
+
+// copyFixture copies a file from testdata to a temporary directory.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
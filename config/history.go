@@ -0,0 +1,298 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultHistoryLimit is the number of changes kept in the history by default.
+const DefaultHistoryLimit = 100
+
+const (
+	historyDirName = "history"
+	lockFileName   = ".lock"
+)
+
+// HistoryEntry records one change to the user config file.
+type HistoryEntry struct {
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	// Path of the config file after the change
+	Path string `json:"path"`
+	// Path of the config file before the change, if it was moved
+	PreviousPath string `json:"previousPath,omitempty"`
+	// Contents of the config file before and after the change
+	Before string `json:"before"`
+	After  string `json:"after"`
+	// ID of the change reverted by `tctx undo`, if any
+	Reverts int `json:"reverts,omitempty"`
+}
+
+// Diff returns a line diff of the config file before and after the change.
+func (e *HistoryEntry) Diff() string {
+	return diffLines(e.Before, e.After)
+}
+
+// GetHistory returns all recorded changes, oldest first.
+func (t *ConfigManager) GetHistory() ([]*HistoryEntry, error) {
+	dir := filepath.Join(filepath.Dir(t.configFilePath), historyDirName)
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []*HistoryEntry
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return nil, fmt.Errorf("error parsing history entry %s: %w", f.Name(), err)
+		}
+		entries = append(entries, &entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+// GetHistoryEntry returns the recorded change with the given ID.
+func (t *ConfigManager) GetHistoryEntry(id int) (*HistoryEntry, error) {
+	entries, err := t.GetHistory()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("change %d does not exist in the history", id)
+}
+
+// Undo reverts the most recent change which has not already been undone, and
+// returns it. Each undo is itself recorded in the history, so that it can be
+// reverted with Restore.
+func (t *ConfigManager) Undo() (*HistoryEntry, error) {
+	var undone *HistoryEntry
+	err := t.withLock(func() error {
+		entries, err := t.GetHistory()
+		if err != nil {
+			return err
+		}
+		reverted := map[int]bool{}
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			if entry.Reverts != 0 {
+				reverted[entry.Reverts] = true
+				continue
+			}
+			if reverted[entry.ID] {
+				continue
+			}
+			undone = entry
+			break
+		}
+		if undone == nil {
+			return errors.New("there are no changes to undo")
+		}
+
+		previousPath := undone.PreviousPath
+		if previousPath == "" {
+			previousPath = undone.Path
+		}
+		return t.revert(undone.Before, previousPath, undone.ID)
+	})
+	return undone, err
+}
+
+// Restore reverts the user config file to its state after the change with the
+// given ID.
+func (t *ConfigManager) Restore(id int) error {
+	return t.withLock(func() error {
+		entry, err := t.GetHistoryEntry(id)
+		if err != nil {
+			return err
+		}
+		return t.revert(entry.After, entry.Path, 0)
+	})
+}
+
+// revert replaces the user config file with contents in the format of path,
+// converting them to the current format if needed. It must be called while
+// holding the config lock.
+func (t *ConfigManager) revert(contents, path string, reverts int) error {
+	previous, err := os.ReadFile(t.configFilePath)
+	if err != nil {
+		return err
+	}
+
+	after := []byte(contents)
+	if reflect.TypeOf(formatOf(path)) != reflect.TypeOf(formatOf(t.configFilePath)) {
+		config, err := parse(path, after)
+		if err != nil {
+			return err
+		}
+		if after, err = formatOf(t.configFilePath).marshal(config, previous); err != nil {
+			return err
+		}
+	}
+
+	return t.commit(&HistoryEntry{
+		Path:    t.configFilePath,
+		Before:  string(previous),
+		After:   string(after),
+		Reverts: reverts,
+	})
+}
+
+// record appends entry to the history, dropping the oldest entries beyond the
+// history limit. Consecutive changes made through the same ConfigManager are
+// recorded as one entry, so that commands which write the config several times
+// can be undone in one step. It must be called while holding the config lock.
+func (t *ConfigManager) record(entry *HistoryEntry) error {
+	entries, err := t.GetHistory()
+	if err != nil {
+		return err
+	}
+	entry.ID = 1
+	if len(entries) > 0 {
+		latest := entries[len(entries)-1]
+		entry.ID = latest.ID + 1
+		if latest.ID == t.lastRecorded && latest.Reverts == 0 && entry.Reverts == 0 {
+			entry.ID = latest.ID
+			entry.Before = latest.Before
+			if entry.PreviousPath == "" && latest.Path != entry.Path {
+				entry.PreviousPath = latest.Path
+			} else if latest.PreviousPath != "" {
+				entry.PreviousPath = latest.PreviousPath
+			}
+			entries = entries[:len(entries)-1]
+		}
+	}
+	entry.Time = time.Now()
+	entry.Command = t.command
+	t.lastRecorded = entry.ID
+
+	dir := filepath.Join(filepath.Dir(t.configFilePath), historyDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(entry, "", "	")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, historyFileName(entry.ID)), b, 0600); err != nil {
+		return err
+	}
+
+	for len(entries)+1 > t.historyLimit {
+		if err := os.Remove(filepath.Join(dir, historyFileName(entries[0].ID))); err != nil {
+			return err
+		}
+		entries = entries[1:]
+	}
+	return nil
+}
+
+func historyFileName(id int) string {
+	return fmt.Sprintf("%06d.json", id)
+}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 2
+
+// diffLines returns a line diff of a and b, in which removed lines are
+// prefixed with "-", added lines with "+", and unchanged lines with a space.
+// Unchanged lines far from any change are elided.
+func diffLines(a, b string) string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	if a == "" {
+		x = nil
+	}
+	if b == "" {
+		y = nil
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, " "+x[i])
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+x[i])
+			i++
+		default:
+			lines = append(lines, "+"+y[j])
+			j++
+		}
+	}
+
+	// Only keep unchanged lines close to a change
+	keep := make([]bool, len(lines))
+	for n, line := range lines {
+		if line[0] == ' ' {
+			continue
+		}
+		for k := n - diffContext; k <= n+diffContext; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+	var result strings.Builder
+	elided := false
+	for n, line := range lines {
+		if !keep[n] {
+			elided = true
+			continue
+		}
+		if elided {
+			result.WriteString("...\n")
+		}
+		elided = false
+		result.WriteString(line + "\n")
+	}
+	if elided {
+		result.WriteString("...\n")
+	}
+	return result.String()
+}
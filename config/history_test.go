@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	newManager := func(command string) *ConfigManager {
+		t.Helper()
+		m, err := NewConfigManager(WithConfigFile(filepath.Join(dir, "config.json")), WithLayers(), WithCommand(command))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	assertAddress := func(expected string) {
+		t.Helper()
+		cfg, err := newManager("").GetContext("prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Address != expected {
+			t.Errorf("expected address %q, got %q", expected, cfg.Address)
+		}
+	}
+
+	// Both writes made by one command are recorded as one change
+	add := newManager("tctx add")
+	if err := add.UpsertContext("prod", &ClusterConfig{Address: "v1:7233", TLS: &TLSConfig{ServerName: "prod"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := add.SetActiveContext("prod", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := newManager("tctx update").UpsertContext("prod", &ClusterConfig{Address: "v2:7233"}); err != nil {
+		t.Fatal(err)
+	}
+	// Changes which leave the config untouched are not recorded
+	if err := newManager("tctx use").SetActiveContext("prod", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := newManager("").GetHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Command != "tctx add" || history[1].Command != "tctx update" {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	expectedDiff := `...
+ 	"contexts": {
+ 		"prod": {
+-			"address": "v1:7233",
++			"address": "v2:7233",
+ 			"webAddress": "",
+ 			"namespace": "",
+...
+`
+	if diff := history[1].Diff(); diff != expectedDiff {
+		t.Errorf("expected diff:\n%s\ngot:\n%s", expectedDiff, diff)
+	}
+
+	// Undo steps back through changes
+	undone, err := newManager("tctx undo").Undo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undone.ID != 2 {
+		t.Errorf("expected change 2 to be undone, got %d", undone.ID)
+	}
+	assertAddress("v1:7233")
+	if _, err := newManager("tctx undo").Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newManager("").GetContext("prod"); err == nil {
+		t.Error("expected context to be removed after undoing its creation")
+	}
+	_, err = newManager("tctx undo").Undo()
+	assertError(t, err, "there are no changes to undo")
+
+	// Restore returns to the state after any change, including undone ones
+	if err := newManager("tctx config restore 2").Restore(2); err != nil {
+		t.Fatal(err)
+	}
+	assertAddress("v2:7233")
+	assertError(t, newManager("").Restore(42), "change 42 does not exist in the history")
+}
+
+func TestHistoryLimit(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		m, err := NewConfigManager(WithConfigFile(filepath.Join(dir, "config.json")), WithLayers(), WithHistoryLimit(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.UpsertContext(fmt.Sprintf("context-%d", i), &ClusterConfig{Address: "localhost:7233"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := NewConfigManager(WithConfigFile(filepath.Join(dir, "config.json")), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := m.GetHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].ID != 3 || history[2].ID != 5 {
+		t.Errorf("expected changes 3 to 5 to be kept, got %d changes starting at %d", len(history), history[0].ID)
+	}
+}
+
+func TestHistoryConvert(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewConfigManager(WithConfigFile(filepath.Join(dir, "config.json")), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("prod", &ClusterConfig{Address: "v1:7233"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Convert("yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	yaml, err := NewConfigManager(WithConfigFile(filepath.Join(dir, "config.yaml")), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.UpsertContext("prod", &ClusterConfig{Address: "v2:7233"}); err != nil {
+		t.Fatal(err)
+	}
+	// Restoring a change made in another format converts it
+	if err := yaml.Restore(1); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := yaml.GetContext("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "v1:7233" {
+		t.Errorf("expected address %q, got %q", "v1:7233", cfg.Address)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := NewConfigManager(WithConfigFile(path), WithLayers())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := m.UpsertContext(fmt.Sprintf("context-%d", i), &ClusterConfig{Address: "localhost:7233"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	m, err := NewConfigManager(WithConfigFile(path), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := m.GetContextNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 10 {
+		t.Errorf("expected all 10 contexts to be saved, got %d", len(names))
+	}
+	history, err := m.GetHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 10 {
+		t.Errorf("expected 10 changes to be recorded, got %d", len(history))
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelPattern matches valid label keys and values.
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// ValidateLabel returns an error if key or value may not be used as a label.
+func ValidateLabel(key, value string) error {
+	if !labelPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key %q: must consist of letters, digits, '.', '_', '-' or '/'", key)
+	}
+	if !labelPattern.MatchString(value) {
+		return fmt.Errorf("invalid value %q for label %q: must consist of letters, digits, '.', '_', '-' or '/'", value, key)
+	}
+	return nil
+}
+
+// FormatLabels returns labels as sorted, comma separated key=value pairs.
+func FormatLabels(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Selector matches contexts by their labels.
+type Selector []requirement
+
+type operator int
+
+const (
+	equals operator = iota
+	notEquals
+	exists
+	notExists
+)
+
+type requirement struct {
+	key   string
+	op    operator
+	value string
+}
+
+// ParseSelector parses a comma separated list of requirements, all of which
+// must be met for a context to match:
+//
+//	env=prod     label env has value prod ("==" may be used instead of "=")
+//	env!=prod    label env does not have value prod, or is not set
+//	env          label env is set
+//	!env         label env is not set
+//
+// An empty selector matches every context.
+func ParseSelector(s string) (Selector, error) {
+	var result Selector
+	if strings.TrimSpace(s) == "" {
+		return result, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var r requirement
+		switch {
+		case strings.Contains(part, "!="):
+			r.key, r.value, _ = strings.Cut(part, "!=")
+			r.op = notEquals
+		case strings.Contains(part, "=="):
+			r.key, r.value, _ = strings.Cut(part, "==")
+		case strings.Contains(part, "="):
+			r.key, r.value, _ = strings.Cut(part, "=")
+		case strings.HasPrefix(part, "!"):
+			r.key, r.op = strings.TrimPrefix(part, "!"), notExists
+		default:
+			r.key, r.op = part, exists
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+
+		value := r.value
+		if r.op == exists || r.op == notExists {
+			// Only the key needs to be validated
+			value = "x"
+		}
+		if err := ValidateLabel(r.key, value); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Matches reports whether labels meet every requirement of the selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+		switch r.op {
+		case equals:
+			if !ok || value != r.value {
+				return false
+			}
+		case notEquals:
+			if ok && value == r.value {
+				return false
+			}
+		case exists:
+			if !ok {
+				return false
+			}
+		case notExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
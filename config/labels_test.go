@@ -0,0 +1,58 @@
+package config
+
+import "testing"
+
+func TestSelector(t *testing.T) {
+	prodUS := map[string]string{"env": "prod", "region": "us"}
+	prodEU := map[string]string{"env": "prod", "region": "eu"}
+	dev := map[string]string{"env": "dev"}
+
+	for _, tc := range []struct {
+		selector string
+		expected []map[string]string
+	}{
+		{"", []map[string]string{prodUS, prodEU, dev, nil}},
+		{"env=prod", []map[string]string{prodUS, prodEU}},
+		{"env==prod", []map[string]string{prodUS, prodEU}},
+		{"env=prod,region!=eu", []map[string]string{prodUS}},
+		{"region!=eu", []map[string]string{prodUS, dev, nil}},
+		{"region", []map[string]string{prodUS, prodEU}},
+		{"!region", []map[string]string{dev, nil}},
+		{" env = dev , !region ", []map[string]string{dev}},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			selector, err := ParseSelector(tc.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var matched int
+			for _, labels := range []map[string]string{prodUS, prodEU, dev, nil} {
+				if selector.Matches(labels) {
+					matched++
+				}
+			}
+			if matched != len(tc.expected) {
+				t.Errorf("expected %d matches, got %d", len(tc.expected), matched)
+			}
+			for _, labels := range tc.expected {
+				if !selector.Matches(labels) {
+					t.Errorf("expected selector to match %v", labels)
+				}
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		selector string
+		expected string
+	}{
+		{"env=", `invalid selector "env=": invalid value "" for label "env": must consist of letters, digits, '.', '_', '-' or '/'`},
+		{"=prod", `invalid selector "=prod": invalid label key "": must consist of letters, digits, '.', '_', '-' or '/'`},
+		{"env=prod,", `invalid selector "env=prod,": invalid label key "": must consist of letters, digits, '.', '_', '-' or '/'`},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			_, err := ParseSelector(tc.selector)
+			assertError(t, err, tc.expected)
+		})
+	}
+}
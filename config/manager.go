@@ -1,15 +1,35 @@
 package config
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jlegrone/tctx/internal/filelock"
 )
 
 type ConfigManager struct {
 	configFilePath string
+	// Read-only config files, in order of decreasing precedence
+	layerPaths []string
+	layersSet  bool
+	// Description of the command making changes, recorded in the history
+	command      string
+	historyLimit int
+	// ID of the last history entry recorded by this ConfigManager
+	lastRecorded int
+	// Policy file enforced on contexts which are added or changed
+	policyPath string
+	// Skip validation and policy checks
+	force bool
+	// Called with problems which contexts already had before they were updated
+	warn func(string)
+	// Neither create nor lock the user config file, and refuse changes
+	readOnly bool
 }
 
 type Option func(t *ConfigManager)
@@ -21,6 +41,67 @@ func WithConfigFile(configFilePath string) Option {
 	}
 }
 
+// WithLayers returns the option to set the read-only config files merged
+// beneath the user's config file, in order of decreasing precedence. By
+// default, these are the files listed in $TCTX_CONFIG_PATHS followed by the
+// system config file.
+func WithLayers(paths ...string) Option {
+	return func(t *ConfigManager) {
+		t.layerPaths = paths
+		t.layersSet = true
+	}
+}
+
+// WithCommand returns the option to describe the command making changes to the
+// config, such as "tctx use -c prod", in the history.
+func WithCommand(command string) Option {
+	return func(t *ConfigManager) {
+		t.command = command
+	}
+}
+
+// WithHistoryLimit returns the option to set the number of changes kept in the
+// history. The default is DefaultHistoryLimit.
+func WithHistoryLimit(limit int) Option {
+	return func(t *ConfigManager) {
+		t.historyLimit = limit
+	}
+}
+
+// WithPolicyFile returns the option to set the path of the policy file. By
+// default, this is PolicyFileName in the directory of the config file.
+func WithPolicyFile(path string) Option {
+	return func(t *ConfigManager) {
+		t.policyPath = path
+	}
+}
+
+// WithForce returns the option to skip validation and policy checks of
+// contexts, if force is true.
+func WithForce(force bool) Option {
+	return func(t *ConfigManager) {
+		t.force = force
+	}
+}
+
+// WithWarningHandler returns the option to set the function called with
+// warnings, such as problems which a context already had before an update.
+// Warnings are discarded by default.
+func WithWarningHandler(warn func(string)) Option {
+	return func(t *ConfigManager) {
+		t.warn = warn
+	}
+}
+
+// WithReadOnly returns the option to only read the config files, e.g. for
+// commands which run on every shell prompt: the user config file is neither
+// created nor locked, a missing one is treated as empty, and changes fail.
+func WithReadOnly() Option {
+	return func(t *ConfigManager) {
+		t.readOnly = true
+	}
+}
+
 // NewConfigManager returns a new ConfigManager to interact with the tctx config
 func NewConfigManager(opts ...Option) (*ConfigManager, error) {
 	t := ConfigManager{}
@@ -38,6 +119,18 @@ func NewConfigManager(opts ...Option) (*ConfigManager, error) {
 		}
 		t.configFilePath = configFilePath
 	}
+	if !t.layersSet {
+		t.layerPaths = GetDefaultLayerPaths()
+	}
+	if t.historyLimit == 0 {
+		t.historyLimit = DefaultHistoryLimit
+	}
+	if t.policyPath == "" {
+		t.policyPath = filepath.Join(filepath.Dir(t.configFilePath), PolicyFileName)
+	}
+	if t.readOnly {
+		return &t, nil
+	}
 
 	// Attempt creating parent directory if it doesn't yet exist
 	if _, err := os.Stat(filepath.Dir(t.configFilePath)); os.IsNotExist(err) {
@@ -47,13 +140,16 @@ func NewConfigManager(opts ...Option) (*ConfigManager, error) {
 	}
 
 	// Create empty config t.configFilePath if none exists
-	if _, err := t.GetAllContexts(); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, err
-		}
-		if err := write(t.configFilePath, &Config{}); err != nil {
-			return nil, err
+	if err := t.withLock(func() error {
+		if _, err := read(t.configFilePath); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return write(t.configFilePath, &Config{})
 		}
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
 	return &t, nil
@@ -129,40 +225,195 @@ func (t *ConfigManager) GetActiveContextName() (string, error) {
 	return cfg.ActiveContext, nil
 }
 
-// GetAllContexts returns the ClusterConfig for all configured contexts
+// GetAllContexts returns the ClusterConfig for all configured contexts, merged
+// across all config layers. The namespace of each context is the one selected
+// by `tctx use --ns`, if any.
 func (t *ConfigManager) GetAllContexts() (*Config, error) {
-	file, err := os.Open(t.configFilePath)
-	defer file.Close()
+	result, err := t.GetDefinitions()
 	if err != nil {
 		return nil, err
 	}
+	for name, state := range result.Namespaces {
+		if cfg := result.Contexts[name]; cfg != nil && state.Current != "" {
+			cfg.Namespace = state.Current
+		}
+	}
+	return result, nil
+}
 
-	var result Config
-	if err := json.NewDecoder(file).Decode(&result); err != nil {
-		return nil, fmt.Errorf("error parsing config t.configFilePath: %w", err)
+// GetDefinitions returns all contexts as defined in the config layers, without
+// applying the user's namespace choices. Use it rather than GetAllContexts to
+// compare or copy context definitions.
+func (t *ConfigManager) GetDefinitions() (*Config, error) {
+	result := &Config{
+		Contexts:   map[string]*ClusterConfig{},
+		Namespaces: map[string]*NamespaceState{},
+		Sources:    map[string]string{},
 	}
-	if result.Contexts == nil {
-		result.Contexts = map[string]*ClusterConfig{}
+
+	// Apply layers in order of increasing precedence
+	for i := len(t.layerPaths) - 1; i >= 0; i-- {
+		layer, err := read(t.layerPaths[i])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		merge(result, layer, t.layerPaths[i])
 	}
 
-	return &result, nil
+	user, err := read(t.configFilePath)
+	if errors.Is(err, os.ErrNotExist) && t.readOnly {
+		user = &Config{Contexts: map[string]*ClusterConfig{}}
+	} else if err != nil {
+		return nil, err
+	}
+	merge(result, user, t.configFilePath)
+
+	return result, nil
+}
+
+// GetLayers returns the paths of all config files, in order of decreasing
+// precedence. Only the first (the user's config file) is writable.
+func (t *ConfigManager) GetLayers() []string {
+	return append([]string{t.configFilePath}, t.layerPaths...)
 }
 
-// UpsertContext upserts a context into the configuration file
+func merge(dst, src *Config, path string) {
+	if src.ActiveContext != "" {
+		dst.ActiveContext = src.ActiveContext
+	}
+	if src.Hooks != nil {
+		dst.Hooks = src.Hooks
+	}
+	if src.Prompt != nil {
+		dst.Prompt = src.Prompt
+	}
+	for k, v := range src.Contexts {
+		dst.Contexts[k] = v
+		dst.Sources[k] = path
+	}
+	for k, v := range src.Namespaces {
+		dst.Namespaces[k] = v
+	}
+}
+
+// checkWritable returns an error if the named context is defined in a
+// read-only layer. It is called with the config locked, so that the context
+// can't be added to the user config file in the meantime.
+func (t *ConfigManager) checkWritable(name string) error {
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
+	if source, ok := all.Sources[name]; ok && source != t.configFilePath {
+		return fmt.Errorf("context %q is defined in read-only config file %s", name, source)
+	}
+	return nil
+}
+
+// UpsertContext upserts a context into the configuration file. The resulting
+// context is checked with CheckContext, except for problems which it already
+// had: these are passed to the warning handler, so that e.g. a web address
+// saved with --force doesn't block unrelated edits.
 func (t *ConfigManager) UpsertContext(name string, new *ClusterConfig) error {
-	allContexts, err := t.GetAllContexts()
+	return t.Update(func(allContexts *Config) error {
+		if err := t.checkWritable(name); err != nil {
+			return err
+		}
+		var previous *ClusterConfig
+		if existing := allContexts.Contexts[name]; existing != nil {
+			previous = existing.Clone()
+		}
+		if err := upsert(allContexts, name, new); err != nil {
+			return err
+		}
+		return t.CheckUpdate(name, previous, allContexts.Contexts[name])
+	})
+}
+
+// CheckContext returns a *ValidationError if the settings of a context can't
+// work, or a *PolicyError if they break the policy, unless the ConfigManager
+// was created WithForce.
+func (t *ConfigManager) CheckContext(name string, cfg *ClusterConfig) error {
+	return t.CheckUpdate(name, nil, cfg)
+}
+
+// CheckUpdate checks cfg as CheckContext does, ignoring the problems which
+// the previous settings of the context, if any, already had.
+func (t *ConfigManager) CheckUpdate(name string, previous, cfg *ClusterConfig) error {
+	if t.force {
+		return nil
+	}
+	policy, err := LoadPolicy(t.policyPath)
 	if err != nil {
 		return err
 	}
+	var previousProblems, previousViolations []string
+	if previous != nil {
+		previousProblems, previousViolations = validationProblems(previous), policy.violations(previous)
+	}
+	if problems := t.newProblems(name, validationProblems(cfg), previousProblems); len(problems) > 0 {
+		return &ValidationError{Context: name, Problems: problems}
+	}
+	if violations := t.newProblems(name, policy.violations(cfg), previousViolations); len(violations) > 0 {
+		return &PolicyError{Context: name, Violations: violations}
+	}
+	return nil
+}
+
+// newProblems returns the problems which are not in previous, and warns about
+// the others.
+func (t *ConfigManager) newProblems(name string, problems, previous []string) []string {
+	var result []string
+	for _, problem := range problems {
+		if !contains(previous, problem) {
+			result = append(result, problem)
+		} else {
+			t.warnf("context %q: %s", name, problem)
+		}
+	}
+	return result
+}
 
+func (t *ConfigManager) warnf(format string, args ...interface{}) {
+	if t.warn != nil {
+		t.warn(fmt.Sprintf(format, args...))
+	}
+}
+
+func upsert(allContexts *Config, name string, new *ClusterConfig) error {
 	if existing := allContexts.Contexts[name]; existing != nil {
 		// Merge with existing values
 		if new.Address != "" {
 			existing.Address = new.Address
 		}
+		if new.WebAddress != "" {
+			existing.WebAddress = new.WebAddress
+		}
 		if new.Namespace != "" {
 			existing.Namespace = new.Namespace
 		}
+		if new.Description != "" {
+			existing.Description = new.Description
+		}
+		if new.Color != "" {
+			existing.Color = new.Color
+		}
+		for k, v := range new.Labels {
+			if existing.Labels == nil {
+				existing.Labels = make(map[string]string)
+			}
+			// Labels without a value are removed
+			if v == "" {
+				delete(existing.Labels, k)
+			} else {
+				existing.Labels[k] = v
+			}
+		}
+		if len(existing.Labels) == 0 {
+			existing.Labels = nil
+		}
 		if new.HeadersProvider != "" {
 			existing.HeadersProvider = new.HeadersProvider
 		}
@@ -170,6 +421,9 @@ func (t *ConfigManager) UpsertContext(name string, new *ClusterConfig) error {
 			existing.DataConverter = new.DataConverter
 		}
 		if new.TLS != nil {
+			if existing.TLS == nil {
+				existing.TLS = &TLSConfig{}
+			}
 
 			if new.TLS.CertPath != "" {
 				existing.TLS.CertPath = new.TLS.CertPath
@@ -187,6 +441,22 @@ func (t *ConfigManager) UpsertContext(name string, new *ClusterConfig) error {
 				existing.TLS.ServerName = new.TLS.ServerName
 			}
 
+			if new.TLS.CertProvider != "" {
+				existing.TLS.CertProvider = new.TLS.CertProvider
+			}
+
+			if new.TLS.CertData != "" {
+				existing.TLS.CertData = new.TLS.CertData
+			}
+
+			if new.TLS.KeyData != "" {
+				existing.TLS.KeyData = new.TLS.KeyData
+			}
+
+			if new.TLS.CAData != "" {
+				existing.TLS.CAData = new.TLS.CAData
+			}
+
 			// This one is tricky. It'll basically always be false in this operation unless the
 			// user explicitly sets it to true on the command line due to Go's "defaults".
 			// In order to properly track this, there likely needs to be a different type to
@@ -195,6 +465,30 @@ func (t *ConfigManager) UpsertContext(name string, new *ClusterConfig) error {
 			existing.TLS.DisableHostVerification = new.TLS.DisableHostVerification
 
 		}
+		if new.Auth != nil {
+			if existing.Auth == nil {
+				existing.Auth = &AuthConfig{}
+			}
+			if new.Auth.Type != "" {
+				existing.Auth.Type = new.Auth.Type
+			}
+			if new.Auth.Header != "" {
+				existing.Auth.Header = new.Auth.Header
+			}
+			if new.Auth.SecretRef != "" {
+				existing.Auth.SecretRef = new.Auth.SecretRef
+			}
+			if new.Auth.Command != "" {
+				existing.Auth.Command = new.Auth.Command
+			}
+			// Drop the settings of the previous auth type
+			switch existing.Auth.Type {
+			case AuthTypeBearerExec:
+				existing.Auth.SecretRef = ""
+			case AuthTypeAPIKey, AuthTypeBearerStatic:
+				existing.Auth.Command = ""
+			}
+		}
 		if new.Environment != nil {
 			if existing.Environment == nil {
 				existing.Environment = make(map[string]string)
@@ -205,58 +499,379 @@ func (t *ConfigManager) UpsertContext(name string, new *ClusterConfig) error {
 		}
 	} else {
 		// Add a new entry
+		for k, v := range new.Labels {
+			if v == "" {
+				delete(new.Labels, k)
+			}
+		}
+		if len(new.Labels) == 0 {
+			new.Labels = nil
+		}
 		allContexts.Contexts[name] = new
 	}
 
-	return write(t.configFilePath, allContexts)
+	if auth := allContexts.Contexts[name].Auth; auth != nil {
+		if err := auth.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update applies fn to the contents of the user config file and writes the
+// result, unless fn returns an error. The config is locked against concurrent
+// changes while fn runs, and the change is recorded in the history.
+func (t *ConfigManager) Update(fn func(config *Config) error) error {
+	return t.withLock(func() error {
+		previous, err := os.ReadFile(t.configFilePath)
+		if err != nil {
+			return err
+		}
+		config, err := parse(t.configFilePath, previous)
+		if err != nil {
+			return err
+		}
+		if err := fn(config); err != nil {
+			return err
+		}
+		b, err := formatOf(t.configFilePath).marshal(config, previous)
+		if err != nil {
+			return err
+		}
+		return t.commit(&HistoryEntry{Path: t.configFilePath, Before: string(previous), After: string(b)})
+	})
 }
 
-// SetActiveContext sets the active context
+// SetActiveContext sets the active context, and the namespace used with it if
+// namespace is not empty. The context definition itself is not changed.
 func (t *ConfigManager) SetActiveContext(name, namespace string) error {
-	config, err := t.GetAllContexts()
+	if name == "" {
+		activeContext, err := t.GetActiveContextName()
+		if err != nil {
+			return err
+		}
+		name = activeContext
+	}
+	// Check that context exists
+	definitions, err := t.GetDefinitions()
 	if err != nil {
-		return fmt.Errorf("could not get contexts: %w", err)
+		return err
+	}
+	definition, ok := definitions.Contexts[name]
+	if !ok {
+		return fmt.Errorf("error checking for active context: context %q does not exist", name)
 	}
 
-	if name != "" {
+	return t.Update(func(config *Config) error {
 		config.ActiveContext = name
+		if namespace != "" {
+			config.setCurrentNamespace(name, namespace, definition)
+		}
+		return nil
+	})
+}
+
+// SetCurrentNamespace sets the namespace used with a context, without changing
+// the active context or the context definition.
+func (t *ConfigManager) SetCurrentNamespace(name, namespace string) error {
+	definitions, err := t.GetDefinitions()
+	if err != nil {
+		return err
 	}
-	// Check that context exists
-	if _, err := t.GetContext(config.ActiveContext); err != nil {
-		return fmt.Errorf("error checking for active context: %w", err)
+	definition, ok := definitions.Contexts[name]
+	if !ok {
+		return fmt.Errorf("context %q does not exist", name)
 	}
 
-	if namespace != "" {
-		config.Contexts[config.ActiveContext].Namespace = namespace
+	return t.Update(func(config *Config) error {
+		config.setCurrentNamespace(name, namespace, definition)
+		return nil
+	})
+}
+
+// AddFavoriteNamespaces adds namespaces to the favorites of a context.
+func (t *ConfigManager) AddFavoriteNamespaces(name string, namespaces ...string) error {
+	if _, err := t.GetContext(name); err != nil {
+		return err
 	}
+	return t.Update(func(config *Config) error {
+		state := config.namespaceState(name)
+		for _, ns := range namespaces {
+			if !contains(state.Favorites, ns) {
+				state.Favorites = append(state.Favorites, ns)
+			}
+		}
+		return nil
+	})
+}
 
-	return write(t.configFilePath, config)
+// RemoveFavoriteNamespaces removes namespaces from the favorites of a context.
+func (t *ConfigManager) RemoveFavoriteNamespaces(name string, namespaces ...string) error {
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
+	if _, ok := all.Contexts[name]; !ok {
+		return fmt.Errorf("context %q does not exist", name)
+	}
+	for _, ns := range namespaces {
+		if !contains(all.GetFavoriteNamespaces(name), ns) {
+			return fmt.Errorf("namespace %q is not a favorite of context %q", ns, name)
+		}
+	}
+
+	return t.Update(func(config *Config) error {
+		state := config.namespaceState(name)
+		var favorites []string
+		for _, ns := range state.Favorites {
+			if !contains(namespaces, ns) {
+				favorites = append(favorites, ns)
+			}
+		}
+		state.Favorites = favorites
+		config.pruneNamespaceState(name)
+		return nil
+	})
+}
+
+func (c *Config) setCurrentNamespace(name, namespace string, definition *ClusterConfig) {
+	// Selecting the context's own namespace clears the choice
+	if namespace == definition.Namespace {
+		namespace = ""
+	}
+	c.namespaceState(name).Current = namespace
+	c.pruneNamespaceState(name)
+}
+
+// KnownNamespaces returns the namespaces of a context in the order they should
+// be offered to the user: favorites first, followed by the current namespace
+// and then any namespaces discovered in the cluster, sorted by name.
+func (c *Config) KnownNamespaces(name string, discovered ...string) []string {
+	var result []string
+	add := func(ns string) {
+		if ns != "" && !contains(result, ns) {
+			result = append(result, ns)
+		}
+	}
+	for _, ns := range c.GetFavoriteNamespaces(name) {
+		add(ns)
+	}
+	if cfg := c.Contexts[name]; cfg != nil {
+		add(cfg.Namespace)
+	}
+	sorted := append([]string(nil), discovered...)
+	sort.Strings(sorted)
+	for _, ns := range sorted {
+		add(ns)
+	}
+	return result
+}
+
+// GetFavoriteNamespaces returns the favorite namespaces of a context.
+func (c *Config) GetFavoriteNamespaces(name string) []string {
+	if state := c.Namespaces[name]; state != nil {
+		return state.Favorites
+	}
+	return nil
+}
+
+// namespaceState returns the namespace state of a context, creating it if
+// needed.
+func (c *Config) namespaceState(name string) *NamespaceState {
+	if c.Namespaces == nil {
+		c.Namespaces = map[string]*NamespaceState{}
+	}
+	if c.Namespaces[name] == nil {
+		c.Namespaces[name] = &NamespaceState{}
+	}
+	return c.Namespaces[name]
+}
+
+// pruneNamespaceState removes empty namespace state from the config file.
+func (c *Config) pruneNamespaceState(name string) {
+	if state := c.Namespaces[name]; state != nil && state.Current == "" && len(state.Favorites) == 0 {
+		delete(c.Namespaces, name)
+	}
+	if len(c.Namespaces) == 0 {
+		c.Namespaces = nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // DeleteContext deletes the context with given name from the config
 func (t *ConfigManager) DeleteContext(name string) error {
-	config, err := t.GetAllContexts()
+	// Return early if context does not exist
+	if _, err := t.GetContext(name); err != nil {
+		return err
+	}
+
+	return t.Update(func(config *Config) error {
+		if err := t.checkWritable(name); err != nil {
+			return err
+		}
+		if config.ActiveContext == name {
+			config.ActiveContext = ""
+		}
+		delete(config.Contexts, name)
+		delete(config.Namespaces, name)
+		config.pruneNamespaceState(name)
+		return nil
+	})
+}
+
+// Convert rewrites the user config file in another format ("json", "yaml" or
+// "toml"), keeping the previous file as a backup. It returns the path of the
+// new config file.
+func (t *ConfigManager) Convert(ext string) (string, error) {
+	if err := validateFormat(ext); err != nil {
+		return "", err
+	}
+	newPath := strings.TrimSuffix(t.configFilePath, filepath.Ext(t.configFilePath)) + "." + ext
+	if reflect.TypeOf(formatOf(newPath)) == reflect.TypeOf(formatOf(t.configFilePath)) {
+		return "", fmt.Errorf("config file %s is already in %s format", t.configFilePath, ext)
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return "", fmt.Errorf("config file %s already exists", newPath)
+	}
+
+	err := t.withLock(func() error {
+		previous, err := os.ReadFile(t.configFilePath)
+		if err != nil {
+			return err
+		}
+		config, err := parse(t.configFilePath, previous)
+		if err != nil {
+			return err
+		}
+		b, err := formatOf(newPath).marshal(config, nil)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(newPath, b); err != nil {
+			return err
+		}
+		if err := os.Rename(t.configFilePath, t.configFilePath+".bak"); err != nil {
+			return err
+		}
+		if err := t.record(&HistoryEntry{
+			Path:         newPath,
+			PreviousPath: t.configFilePath,
+			Before:       string(previous),
+			After:        string(b),
+		}); err != nil {
+			return err
+		}
+		t.configFilePath = newPath
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("could not get contexts: %w", err)
+		return "", err
 	}
 
-	// Return early if context does not exist
-	if _, err := t.GetContext(name); err != nil {
+	return newPath, nil
+}
+
+// withLock runs fn while holding an exclusive lock on the config directory.
+func (t *ConfigManager) withLock(fn func() error) error {
+	if t.readOnly {
+		return errors.New("config was opened read-only")
+	}
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(t.configFilePath), lockFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := filelock.Lock(f); err != nil {
+		return fmt.Errorf("error locking config: %w", err)
+	}
+	defer func() { _ = filelock.Unlock(f) }()
+
+	return fn()
+}
+
+// commit writes the new contents of the user config file and records the
+// change in the history. Changes which leave the file untouched are not
+// recorded.
+func (t *ConfigManager) commit(entry *HistoryEntry) error {
+	if entry.After == entry.Before {
+		return nil
+	}
+	if err := writeFile(t.configFilePath, []byte(entry.After)); err != nil {
 		return err
 	}
+	return t.record(entry)
+}
 
-	if config.ActiveContext == name {
-		config.ActiveContext = ""
+func read(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-	delete(config.Contexts, name)
+	return parse(path, b)
+}
+
+func parse(path string, b []byte) (*Config, error) {
+	var result Config
+	if err := formatOf(path).unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if result.Contexts == nil {
+		result.Contexts = map[string]*ClusterConfig{}
+	}
+
+	return &result, nil
+}
 
-	return write(t.configFilePath, config)
+func write(path string, config *Config) error {
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	b, err := formatOf(path).marshal(config, previous)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, b)
 }
 
-func write(filepath string, config *Config) error {
-	b, err := json.MarshalIndent(config, "", "	")
+// writeFile replaces the contents of path atomically, so that readers never
+// see a partially written config file.
+func writeFile(path string, b []byte) error {
+	// Replace the target of a symlinked config file, not the link itself
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath, b, os.ModePerm)
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
@@ -0,0 +1,351 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	dir := t.TempDir()
+	system := writeConfig(t, filepath.Join(dir, "system.json"), &Config{
+		ActiveContext: "shared",
+		Contexts: map[string]*ClusterConfig{
+			"shared":   {Address: "system:7233", Namespace: "default"},
+			"system":   {Address: "system:7233", Namespace: "default"},
+			"override": {Address: "system:7233", Namespace: "default"},
+		},
+	})
+	team := writeConfig(t, filepath.Join(dir, "team.json"), &Config{
+		Contexts: map[string]*ClusterConfig{
+			"shared":   {Address: "team:7233", Namespace: "team"},
+			"override": {Address: "team:7233", Namespace: "team"},
+		},
+	})
+	user := filepath.Join(dir, "tctx", "config.json")
+
+	m, err := NewConfigManager(
+		WithConfigFile(user),
+		WithLayers(team, system, filepath.Join(dir, "missing.json")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("user", &ClusterConfig{Address: "user:7233", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, user, &Config{
+		Contexts: map[string]*ClusterConfig{
+			"user":     {Address: "user:7233", Namespace: "default"},
+			"override": {Address: "user:7233", Namespace: "user"},
+		},
+	})
+
+	all, err := m.GetAllContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Active context may be provided by a read-only layer
+	if all.ActiveContext != "shared" {
+		t.Errorf("expected active context %q, got %q", "shared", all.ActiveContext)
+	}
+	for name, expected := range map[string]struct{ address, source string }{
+		"system":   {"system:7233", system},
+		"shared":   {"team:7233", team},
+		"override": {"user:7233", user},
+		"user":     {"user:7233", user},
+	} {
+		if actual := all.Contexts[name].Address; actual != expected.address {
+			t.Errorf("expected context %q to have address %q, got %q", name, expected.address, actual)
+		}
+		if actual := all.Sources[name]; actual != expected.source {
+			t.Errorf("expected context %q to come from %s, got %s", name, expected.source, actual)
+		}
+	}
+
+	// Contexts in read-only layers can't be modified
+	readOnlyErr := "context \"shared\" is defined in read-only config file " + team
+	assertError(t, m.UpsertContext("shared", &ClusterConfig{Namespace: "foo"}), readOnlyErr)
+	assertError(t, m.DeleteContext("shared"), readOnlyErr)
+
+	// ...but they can be selected, with the selection stored in the user layer
+	if err := m.SetActiveContext("system", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	assertLayer(t, user, func(cfg *Config) {
+		if cfg.ActiveContext != "system" {
+			t.Errorf("expected user config to have active context %q, got %q", "system", cfg.ActiveContext)
+		}
+		if _, ok := cfg.Contexts["system"]; ok {
+			t.Error("expected read-only context not to be copied to user config")
+		}
+		if ns := cfg.Namespaces["system"].Current; ns != "foo" {
+			t.Errorf("expected user config to select namespace %q, got %q", "foo", ns)
+		}
+	})
+	if cfg, err := m.GetContext("system"); err != nil {
+		t.Fatal(err)
+	} else if cfg.Namespace != "foo" {
+		t.Errorf("expected selected namespace %q, got %q", "foo", cfg.Namespace)
+	}
+
+	// User contexts shadowing read-only contexts can be modified
+	if err := m.UpsertContext("override", &ClusterConfig{Namespace: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	assertLayer(t, team, func(cfg *Config) {
+		if ns := cfg.Contexts["override"].Namespace; ns != "team" {
+			t.Errorf("expected read-only config not to be modified, got namespace %q", ns)
+		}
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "config.json")
+	m, err := NewConfigManager(WithConfigFile(user), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("prod", &ClusterConfig{Address: "prod:7233", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Switching namespaces does not change the context definition
+	if err := m.SetActiveContext("prod", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	assertLayer(t, user, func(cfg *Config) {
+		if ns := cfg.Contexts["prod"].Namespace; ns != "default" {
+			t.Errorf("expected context namespace to be unchanged, got %q", ns)
+		}
+	})
+	if cfg, _ := m.GetContext("prod"); cfg.Namespace != "orders" {
+		t.Errorf("expected current namespace %q, got %q", "orders", cfg.Namespace)
+	}
+	// Switching back to the context's namespace clears the selection
+	if err := m.SetActiveContext("prod", "default"); err != nil {
+		t.Fatal(err)
+	}
+	assertLayer(t, user, func(cfg *Config) {
+		if cfg.Namespaces != nil {
+			t.Errorf("expected namespace state to be removed, got %v", cfg.Namespaces)
+		}
+	})
+
+	if err := m.AddFavoriteNamespaces("prod", "orders", "payments", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveFavoriteNamespaces("prod", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	all, err := m.GetAllContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if favorites := all.GetFavoriteNamespaces("prod"); len(favorites) != 1 || favorites[0] != "payments" {
+		t.Errorf("expected favorites [payments], got %v", favorites)
+	}
+	assertError(t, m.RemoveFavoriteNamespaces("prod", "orders"), `namespace "orders" is not a favorite of context "prod"`)
+	assertError(t, m.AddFavoriteNamespaces("staging", "orders"), `context "staging" does not exist`)
+}
+
+func TestDefaultLayerPaths(t *testing.T) {
+	t.Setenv("TCTX_CONFIG_PATHS", strings.Join([]string{"/a.json", "", "/b.json"}, string(os.PathListSeparator)))
+	t.Setenv("TCTX_SYSTEM_CONFIG_PATH", "/system.json")
+	paths := GetDefaultLayerPaths()
+	expected := []string{"/a.json", "/b.json", "/system.json"}
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected layers %v, got %v", expected, paths)
+	}
+}
+
+func writeConfig(t *testing.T, path string, cfg *Config) string {
+	t.Helper()
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func assertLayer(t *testing.T, path string, check func(cfg *Config)) {
+	t.Helper()
+	cfg, err := read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(cfg)
+}
+
+func assertError(t *testing.T, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("expected error %q", expected)
+	} else if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err)
+	}
+}
+
+func TestUpsertChangesAuthType(t *testing.T) {
+	m, err := NewConfigManager(WithConfigFile(filepath.Join(t.TempDir(), "config.json")), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("cloud", &ClusterConfig{
+		Address: "cloud:7233",
+		Auth:    &AuthConfig{Type: AuthTypeAPIKey, Header: "x-api-key", SecretRef: "env:API_KEY"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings of the previous type are dropped; the header applies to all types
+	if err := m.UpsertContext("cloud", &ClusterConfig{Auth: &AuthConfig{Type: AuthTypeBearerExec, Command: "print-token"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := m.GetContext("cloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (AuthConfig{Type: AuthTypeBearerExec, Header: "x-api-key", Command: "print-token"}); *cfg.Auth != expected {
+		t.Errorf("expected auth %+v, got %+v", expected, *cfg.Auth)
+	}
+
+	if err := m.UpsertContext("cloud", &ClusterConfig{Auth: &AuthConfig{Type: AuthTypeBearerStatic, SecretRef: "file:/token"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = m.GetContext("cloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (AuthConfig{Type: AuthTypeBearerStatic, Header: "x-api-key", SecretRef: "file:/token"}); *cfg.Auth != expected {
+		t.Errorf("expected auth %+v, got %+v", expected, *cfg.Auth)
+	}
+}
+
+func TestWriteSymlinkedConfig(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "config.json")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte(`{"contexts": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "config.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	m, err := NewConfigManager(WithConfigFile(link), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("dev", &ClusterConfig{Address: "localhost:7233", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Lstat(link); err != nil {
+		t.Fatal(err)
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("expected config file to remain a symlink")
+	}
+	b, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "localhost:7233") {
+		t.Errorf("expected symlink target to be updated, got %s", b)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	layer := filepath.Join(dir, "system.json")
+	if err := os.WriteFile(layer, []byte(`{"contexts": {"shared": {"address": "shared:7233"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "tctx", "config.json")
+
+	m, err := NewConfigManager(WithConfigFile(configPath), WithLayers(layer), WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(configPath)); !os.IsNotExist(err) {
+		t.Errorf("expected the config directory not to be created, got %v", err)
+	}
+	all, err := m.GetAllContexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all.Contexts["shared"]; !ok || len(all.Contexts) != 1 {
+		t.Errorf("expected the contexts of the layers, got %v", all.Contexts)
+	}
+	if err := m.UpsertContext("dev", &ClusterConfig{Address: "localhost:7233"}); err == nil || err.Error() != "config was opened read-only" {
+		t.Errorf("expected changes to fail, got %v", err)
+	}
+
+	// Reading doesn't wait for changes in progress
+	writer, err := NewConfigManager(WithConfigFile(configPath), WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Update(func(*Config) error {
+		_, err := m.GetAllContexts()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpsertChecksChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	var warnings []string
+	m, err := NewConfigManager(WithConfigFile(path), WithLayers(), WithWarningHandler(func(warning string) {
+		warnings = append(warnings, warning)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forced, err := NewConfigManager(WithConfigFile(path), WithLayers(), WithForce(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing TLS files are errors, unless forced
+	prod := &ClusterConfig{Address: "prod:7233", TLS: &TLSConfig{CACertPath: "/missing/ca.pem"}}
+	err = m.UpsertContext("prod", prod)
+	if expected := `invalid context "prod": TLS CA certificate file /missing/ca.pem does not exist`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+	if err := forced.UpsertContext("prod", prod); err != nil {
+		t.Fatal(err)
+	}
+
+	// Problems the context already had don't block unrelated edits
+	if err := forced.UpsertContext("prod", &ClusterConfig{WebAddress: "temporal.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("prod", &ClusterConfig{Description: "Production"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`context "prod": invalid web address "temporal.example.com": must be an http or https URL`,
+		`context "prod": TLS CA certificate file /missing/ca.pem does not exist`,
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected warnings %q, got %q", expected, warnings)
+	}
+
+	// New problems are errors
+	err = m.UpsertContext("prod", &ClusterConfig{Address: "prod"})
+	if expected := `invalid context "prod": invalid address "prod": must be host:port`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
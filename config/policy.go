@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyFileName is the name of the policy file, which is read from the
+// directory of the user config file unless another path is given.
+const PolicyFileName = "policy.yaml"
+
+// Policy is a set of rules that contexts must follow when they are added,
+// updated or imported, such as requiring TLS for production clusters. It is
+// read from a YAML (or JSON) file:
+//
+//	rules:
+//	  - name: prod-tls
+//	    selector: env=prod
+//	    requireTLS: true
+//	    requireCA: true
+//	    requireHostVerification: true
+//	    forbiddenEnvironment: [FOO]
+type Policy struct {
+	Rules []PolicyRule `json:"rules" yaml:"rules"`
+}
+
+// PolicyRule is a set of requirements for the contexts matching a label
+// selector.
+type PolicyRule struct {
+	// Name of the rule, shown when it is violated
+	Name string `json:"name" yaml:"name"`
+	// Label selector of the contexts the rule applies to, such as "env=prod";
+	// empty matches every context
+	Selector string `json:"selector,omitempty" yaml:"selector,omitempty"`
+	// Contexts must connect with TLS
+	RequireTLS bool `json:"requireTLS,omitempty" yaml:"requireTLS,omitempty"`
+	// Contexts must set a server CA certificate
+	RequireCA bool `json:"requireCA,omitempty" yaml:"requireCA,omitempty"`
+	// Contexts must not disable TLS host verification
+	RequireHostVerification bool `json:"requireHostVerification,omitempty" yaml:"requireHostVerification,omitempty"`
+	// Additional environment variables contexts must not set
+	ForbiddenEnvironment []string `json:"forbiddenEnvironment,omitempty" yaml:"forbiddenEnvironment,omitempty"`
+
+	selector Selector
+}
+
+// PolicyError lists the policy rules broken by a context.
+type PolicyError struct {
+	Context    string
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("context %q violates policy: %s", e.Context, strings.Join(e.Violations, "; "))
+}
+
+// LoadPolicy reads the policy file at path. A nil policy is returned when the
+// file does not exist.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("invalid policy file %s: rule %d has no name", path, i+1)
+		}
+		if rule.selector, err = ParseSelector(rule.Selector); err != nil {
+			return nil, fmt.Errorf("invalid policy file %s: rule %q: %w", path, rule.Name, err)
+		}
+	}
+	return &p, nil
+}
+
+// Check returns a *PolicyError if the named context breaks any rule of the
+// policy which applies to it.
+func (p *Policy) Check(name string, cfg *ClusterConfig) error {
+	if violations := p.violations(cfg); len(violations) > 0 {
+		return &PolicyError{Context: name, Violations: violations}
+	}
+	return nil
+}
+
+func (p *Policy) violations(cfg *ClusterConfig) []string {
+	if p == nil {
+		return nil
+	}
+	var violations []string
+	for _, rule := range p.Rules {
+		if !rule.selector.Matches(cfg.Labels) {
+			continue
+		}
+		for _, v := range rule.check(cfg) {
+			violations = append(violations, fmt.Sprintf("rule %q: %s", rule.Name, v))
+		}
+	}
+	return violations
+}
+
+func (r PolicyRule) check(cfg *ClusterConfig) []string {
+	var violations []string
+	tls := cfg.GetTLS()
+	if r.RequireTLS && !tls.Enabled() {
+		violations = append(violations, "must use TLS")
+	}
+	if r.RequireCA && tls.CACertPath == "" && tls.CAData == "" {
+		violations = append(violations, "must set a CA certificate")
+	}
+	if r.RequireHostVerification && tls.DisableHostVerification {
+		violations = append(violations, "must not disable host verification")
+	}
+	for _, name := range r.ForbiddenEnvironment {
+		if _, ok := cfg.Environment[name]; ok {
+			violations = append(violations, fmt.Sprintf("must not set environment variable %s", name))
+		}
+	}
+	return violations
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePolicy(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), PolicyFileName)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, `
+rules:
+  - name: prod-tls
+    selector: env=prod
+    requireTLS: true
+    requireCA: true
+    requireHostVerification: true
+    forbiddenEnvironment: [FOO]
+  - name: no-debug
+    forbiddenEnvironment: [DEBUG]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod := map[string]string{"env": "prod"}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      ClusterConfig
+		expected string
+	}{
+		{
+			name: "rule does not apply",
+			cfg:  ClusterConfig{Labels: map[string]string{"env": "dev"}, Environment: map[string]string{"FOO": "bar"}},
+		},
+		{
+			name: "compliant",
+			cfg:  ClusterConfig{Labels: prod, TLS: &TLSConfig{CAData: "ca"}},
+		},
+		{
+			name:     "no TLS",
+			cfg:      ClusterConfig{Labels: prod},
+			expected: `context "test" violates policy: rule "prod-tls": must use TLS; rule "prod-tls": must set a CA certificate`,
+		},
+		{
+			name:     "no CA",
+			cfg:      ClusterConfig{Labels: prod, TLS: &TLSConfig{ServerName: "prod"}},
+			expected: `context "test" violates policy: rule "prod-tls": must set a CA certificate`,
+		},
+		{
+			name:     "host verification disabled",
+			cfg:      ClusterConfig{Labels: prod, TLS: &TLSConfig{CACertPath: "ca.pem", DisableHostVerification: true}},
+			expected: `context "test" violates policy: rule "prod-tls": must not disable host verification`,
+		},
+		{
+			name:     "forbidden environment",
+			cfg:      ClusterConfig{Labels: prod, TLS: &TLSConfig{CACertPath: "ca.pem"}, Environment: map[string]string{"FOO": "bar", "DEBUG": "1"}},
+			expected: `context "test" violates policy: rule "prod-tls": must not set environment variable FOO; rule "no-debug": must not set environment variable DEBUG`,
+		},
+		{
+			name:     "rule without selector",
+			cfg:      ClusterConfig{Environment: map[string]string{"DEBUG": "1"}},
+			expected: `context "test" violates policy: rule "no-debug": must not set environment variable DEBUG`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Check("test", &tc.cfg)
+			if tc.expected == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %q", err)
+				}
+			} else if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	policy, err := LoadPolicy(filepath.Join(t.TempDir(), PolicyFileName))
+	if err != nil || policy != nil {
+		t.Errorf("expected no policy for a missing file, got %v, %v", policy, err)
+	}
+	if err := policy.Check("test", &ClusterConfig{}); err != nil {
+		t.Errorf("expected a nil policy to allow every context, got %q", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+		expected string
+	}{
+		{"unnamed rule", "rules:\n  - requireTLS: true\n", "rule 1 has no name"},
+		{"invalid selector", "rules:\n  - name: bad\n    selector: env=pr od\n", `rule "bad": `},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writePolicy(t, tc.contents)
+			_, err := LoadPolicy(path)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if expected := "invalid policy file " + path + ": " + tc.expected; !strings.HasPrefix(err.Error(), expected) {
+				t.Errorf("expected error starting with %q, got %q", expected, err)
+			}
+		})
+	}
+}
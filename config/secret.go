@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	envSecretPrefix  = "env:"
+	fileSecretPrefix = "file:"
+)
+
+// secretEnvPattern matches names of environment variables likely to hold
+// secrets.
+var secretEnvPattern = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSWD|CREDENTIAL|PRIVATE|API_?KEY)`)
+
+// IsSecretEnvVar reports whether the named environment variable is likely to
+// hold a secret, such as GITHUB_TOKEN or DB_PASSWORD.
+func IsSecretEnvVar(name string) bool {
+	return secretEnvPattern.MatchString(name)
+}
+
+// IsUnsafeEnvVar reports whether the named environment variable can make
+// commands run code or connect elsewhere than the context says, such as
+// LD_PRELOAD, PATH or TEMPORAL_CLI_PLUGIN_HEADERS_PROVIDER. Such variables are
+// never accepted from bundles or shared contexts.
+func IsUnsafeEnvVar(name string) bool {
+	name = strings.ToUpper(name)
+	if name == "PATH" {
+		return true
+	}
+	for _, prefix := range []string{"TEMPORAL_CLI_", "TCTX_", "LD_", "DYLD_"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSecretRef returns an error if ref is not a supported secret
+// reference. Secrets are never stored in the config file directly; instead they
+// are referenced as "env:NAME" for an environment variable, or "file:PATH" for
+// the contents of a file.
+func ValidateSecretRef(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, envSecretPrefix) && len(ref) > len(envSecretPrefix):
+		return nil
+	case strings.HasPrefix(ref, fileSecretPrefix) && len(ref) > len(fileSecretPrefix):
+		return nil
+	default:
+		return fmt.Errorf("invalid secret reference %q: must be of the form env:NAME or file:PATH", ref)
+	}
+}
+
+// ResolveSecret returns the secret value referenced by ref, with surrounding
+// whitespace removed.
+func ResolveSecret(ref string) (string, error) {
+	if err := ValidateSecretRef(ref); err != nil {
+		return "", err
+	}
+
+	if name := strings.TrimPrefix(ref, envSecretPrefix); name != ref {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s referenced by %q is not set", name, ref)
+		}
+		return strings.TrimSpace(value), nil
+	}
+
+	b, err := os.ReadFile(strings.TrimPrefix(ref, fileSecretPrefix))
+	if err != nil {
+		return "", fmt.Errorf("error reading secret: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
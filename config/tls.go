@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const pemPrefix = "-----BEGIN "
+
+// CertPEM returns the PEM encoded client certificate, from CertData or the
+// file at CertPath. Nil is returned when neither is set.
+func (t TLSConfig) CertPEM() ([]byte, error) {
+	return pemData("certData", t.CertData, t.CertPath)
+}
+
+// KeyPEM returns the PEM encoded private key, from KeyData or the file at
+// KeyPath. Nil is returned when neither is set.
+func (t TLSConfig) KeyPEM() ([]byte, error) {
+	if t.KeyData != "" && ValidateSecretRef(t.KeyData) == nil {
+		key, err := ResolveSecret(t.KeyData)
+		if err != nil {
+			return nil, err
+		}
+		return decodePEM("keyData", key)
+	}
+	return pemData("keyData", t.KeyData, t.KeyPath)
+}
+
+// CAPEM returns the PEM encoded server CA certificate, from CAData or the
+// file at CACertPath. Nil is returned when neither is set.
+func (t TLSConfig) CAPEM() ([]byte, error) {
+	return pemData("caData", t.CAData, t.CACertPath)
+}
+
+// HasInlineData reports whether any certificate or key is given inline rather
+// than as a file path.
+func (t TLSConfig) HasInlineData() bool {
+	return t.CertData != "" || t.KeyData != "" || t.CAData != ""
+}
+
+// Enabled reports whether TLS is used to connect: any setting besides
+// DisableHostVerification enables it.
+func (t TLSConfig) Enabled() bool {
+	t.DisableHostVerification = false
+	return t != TLSConfig{}
+}
+
+func pemData(field, data, path string) ([]byte, error) {
+	if data != "" {
+		return decodePEM(field, data)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+// decodePEM accepts PEM data as-is, or encoded with base64 so that it fits on
+// a single line.
+func decodePEM(field, data string) ([]byte, error) {
+	data = strings.TrimSpace(data)
+	if strings.HasPrefix(data, pemPrefix) {
+		return []byte(data + "\n"), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil || !strings.Contains(string(b), pemPrefix) {
+		return nil, fmt.Errorf("invalid %s: must be PEM or base64 encoded PEM", field)
+	}
+	return b, nil
+}
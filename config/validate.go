@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ValidationError lists the problems with the settings of a context.
+type ValidationError struct {
+	Context  string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid context %q: %s", e.Context, strings.Join(e.Problems, "; "))
+}
+
+// ValidateContext returns a *ValidationError if the settings of the named
+// context can't work: an address which isn't host:port, a web address which
+// isn't an http(s) URL, TLS files which don't exist, or host verification
+// disabled without TLS.
+func ValidateContext(name string, cfg *ClusterConfig) error {
+	if problems := validationProblems(cfg); len(problems) > 0 {
+		return &ValidationError{Context: name, Problems: problems}
+	}
+	return nil
+}
+
+func validationProblems(cfg *ClusterConfig) []string {
+	var problems []string
+	if err := validateAddress(cfg.Address); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if cfg.WebAddress != "" {
+		if u, err := url.Parse(cfg.WebAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("invalid web address %q: must be an http or https URL", cfg.WebAddress))
+		}
+	}
+
+	tls := cfg.GetTLS()
+	for _, file := range []struct{ description, path string }{
+		{"certificate", tls.CertPath},
+		{"key", tls.KeyPath},
+		{"CA certificate", tls.CACertPath},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); errors.Is(err, os.ErrNotExist) {
+			problems = append(problems, fmt.Sprintf("TLS %s file %s does not exist", file.description, file.path))
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("TLS %s file: %s", file.description, err))
+		}
+	}
+	if tls.DisableHostVerification && !tls.Enabled() {
+		problems = append(problems, "host verification can only be disabled when TLS is enabled")
+	}
+	return problems
+}
+
+func validateAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid address %q: must be host:port", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port in address %q: must be a number from 1 to 65535", address)
+	}
+	return nil
+}
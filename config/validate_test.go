@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateContext(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      ClusterConfig
+		expected string
+	}{
+		{
+			name: "valid",
+			cfg: ClusterConfig{
+				Address:    "prod.example.com:7233",
+				WebAddress: "https://temporal.example.com",
+				TLS:        &TLSConfig{CACertPath: ca, DisableHostVerification: true},
+			},
+		},
+		{
+			name:     "address without port",
+			cfg:      ClusterConfig{Address: "prod.example.com"},
+			expected: `invalid context "test": invalid address "prod.example.com": must be host:port`,
+		},
+		{
+			name:     "address without host",
+			cfg:      ClusterConfig{Address: ":7233"},
+			expected: `invalid context "test": invalid address ":7233": must be host:port`,
+		},
+		{
+			name:     "invalid port",
+			cfg:      ClusterConfig{Address: "localhost:temporal"},
+			expected: `invalid context "test": invalid port in address "localhost:temporal": must be a number from 1 to 65535`,
+		},
+		{
+			name:     "port out of range",
+			cfg:      ClusterConfig{Address: "localhost:70000"},
+			expected: `invalid context "test": invalid port in address "localhost:70000": must be a number from 1 to 65535`,
+		},
+		{
+			name:     "web address without scheme",
+			cfg:      ClusterConfig{Address: "localhost:7233", WebAddress: "temporal.example.com"},
+			expected: `invalid context "test": invalid web address "temporal.example.com": must be an http or https URL`,
+		},
+		{
+			name:     "missing TLS files",
+			cfg:      ClusterConfig{Address: "localhost:7233", TLS: &TLSConfig{CertPath: "/missing/cert.pem", KeyPath: "/missing/key.pem", CACertPath: ca}},
+			expected: `invalid context "test": TLS certificate file /missing/cert.pem does not exist; TLS key file /missing/key.pem does not exist`,
+		},
+		{
+			name:     "host verification disabled without TLS",
+			cfg:      ClusterConfig{Address: "localhost:7233", TLS: &TLSConfig{DisableHostVerification: true}},
+			expected: `invalid context "test": host verification can only be disabled when TLS is enabled`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateContext("test", &tc.cfg)
+			if tc.expected == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %q", err)
+				}
+			} else if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/daemon"
+)
+
+// daemonSocketPath returns the path of the socket `tctx daemon` listens on.
+func daemonSocketPath(c *cli.Context) string {
+	return filepath.Join(filepath.Dir(c.String(configPathFlag)), "daemon.sock")
+}
+
+// daemonStatuses returns the statuses cached by `tctx daemon`, or nil when it
+// isn't running.
+func daemonStatuses(c *cli.Context) daemon.Statuses {
+	statuses, err := daemon.Query(c.Context, daemonSocketPath(c))
+	if err != nil {
+		return nil
+	}
+	return statuses
+}
+
+// contextStatuses returns the statuses of contexts, as cached by the daemon
+// when it is running, or else by checking each cluster.
+func contextStatuses(c *cli.Context) func(context.Context, map[string]*config.ClusterConfig) daemon.Statuses {
+	return func(ctx context.Context, contexts map[string]*config.ClusterConfig) daemon.Statuses {
+		if statuses := daemonStatuses(c); statuses != nil {
+			return statuses
+		}
+		return daemon.CheckAll(ctx, contexts)
+	}
+}
+
+// runDaemon polls all contexts until interrupted.
+func runDaemon(c *cli.Context) error {
+	t, err := getConfigManager(c)
+	if err != nil {
+		return err
+	}
+	path := daemonSocketPath(c)
+	lis, err := daemon.Listen(path)
+	if err != nil {
+		return err
+	}
+	s := daemon.New(func() (map[string]*config.ClusterConfig, error) {
+		all, err := t.GetAllContexts()
+		if err != nil {
+			return nil, err
+		}
+		return all.Contexts, nil
+	}, c.Duration(intervalFlag))
+
+	_, _ = fmt.Fprintf(c.App.Writer, "Daemon listening on %s, polling contexts every %s.\n", path, c.Duration(intervalFlag))
+
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.Serve(ctx, lis, func(err error) {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", err)
+	})
+}
+
+// daemonStatus prints the statuses cached by the running daemon.
+func daemonStatus(c *cli.Context) error {
+	format, err := parseOutputFormat(c.String(outputFlag), outputTable, outputJSON, outputYAML)
+	if err != nil {
+		return err
+	}
+	statuses, err := daemon.Query(c.Context, daemonSocketPath(c))
+	if errors.Is(err, daemon.ErrNotRunning) {
+		return fmt.Errorf("%w: start it with `tctx daemon`", err)
+	} else if err != nil {
+		return err
+	}
+	if format.kind != outputTable {
+		return format.write(c.App.Writer, statuses)
+	}
+
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(c.App.Writer, 1, 1, 4, ' ', 0)
+	if _, err := fmt.Fprintln(w, "CONTEXT\tADDRESS\tHEALTH\tNAMESPACES\tCHECKED\tERROR\t"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		s := statuses[name]
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			name, s.Address, healthLabel(s), strings.Join(s.Namespaces, ","), s.CheckedAt.Local().Format(historyTimeFormat), s.Error,
+		); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// healthLabel describes the health of a context in tables.
+func healthLabel(s *daemon.Status) string {
+	switch {
+	case s == nil:
+		return "unknown"
+	case s.Healthy:
+		return "healthy"
+	default:
+		return "unhealthy"
+	}
+}
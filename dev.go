@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/devserver"
+	"github.com/jlegrone/tctx/internal/hooks"
+)
+
+// devServerDir returns the directory holding the state and logs of dev servers.
+func devServerDir(c *cli.Context) string {
+	return filepath.Join(filepath.Dir(c.String(configPathFlag)), "dev")
+}
+
+// devUp starts a dev server and registers a context for it.
+func devUp(c *cli.Context) error {
+	port := c.Int(portFlag)
+	name := c.String(contextNameFlag)
+	if name == "" {
+		name = fmt.Sprintf("dev-%d", port)
+	}
+	if err := checkDirName(name); err != nil {
+		return err
+	}
+	t, err := getConfigManager(c)
+	if err != nil {
+		return err
+	}
+	dir := devServerDir(c)
+
+	// Only contexts of earlier dev servers are replaced
+	if existing, _ := t.GetContext(name); existing != nil {
+		if _, err := devserver.Load(dir, name); err != nil {
+			return fmt.Errorf("a context with name %q already exists", name)
+		}
+	}
+
+	inst, err := devserver.Start(c.Context, dir, devserver.Options{
+		Command:    c.String(serverFlag),
+		Context:    name,
+		Port:       port,
+		UIPort:     c.Int(uiPortFlag),
+		DBFilename: c.String(dbFlag),
+		Timeout:    c.Duration(timeoutFlag),
+	})
+	if err != nil {
+		return err
+	}
+	cfg := &config.ClusterConfig{
+		Description: "Dev server started by tctx dev up",
+		Address:     inst.Address(),
+		WebAddress:  inst.WebAddress(),
+		Namespace:   "default",
+	}
+	if err := t.UpsertContext(name, cfg); err != nil {
+		_ = devserver.Stop(dir, inst)
+		return err
+	}
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
+	runPostHooks(c, all, hooks.Invocation{Event: hooks.PostAdd, New: hookContext(name, all.Contexts[name])})
+
+	_, err = fmt.Fprintf(c.App.Writer, `Dev server running with pid %d.
+  Address: %s
+  Web UI:  %s
+  Logs:    %s
+Context %q added; switch to it with `+"`tctx use -c %s`"+`.
+`, inst.PID, inst.Address(), inst.WebAddress(), inst.LogPath, name, name)
+	return err
+}
+
+// devDown stops a dev server and deletes its context. The context flag may be
+// omitted when a single dev server is running.
+func devDown(c *cli.Context) error {
+	dir := devServerDir(c)
+	name := c.String(contextNameFlag)
+	if name == "" {
+		instances, err := devserver.List(dir)
+		if err != nil {
+			return err
+		}
+		switch len(instances) {
+		case 0:
+			return fmt.Errorf("no dev servers are running")
+		case 1:
+			name = instances[0].Context
+		default:
+			var names []string
+			for _, inst := range instances {
+				names = append(names, inst.Context)
+			}
+			return fmt.Errorf("several dev servers are running: use --%s to choose one of %s", contextNameFlag, strings.Join(names, ", "))
+		}
+	}
+	inst, err := devserver.Load(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := devserver.Stop(dir, inst); err != nil {
+		return err
+	}
+
+	t, err := getConfigManager(c)
+	if err != nil {
+		return err
+	}
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
+	if all.Contexts[name] == nil {
+		_, err = fmt.Fprintf(c.App.Writer, "Dev server for context %q stopped.\n", name)
+		return err
+	}
+	if err := t.DeleteContext(name); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Dev server for context %q stopped and context deleted.\n", name)
+
+	runPostHooks(c, all, hooks.Invocation{Event: hooks.PostDelete, Old: hookContext(name, all.Contexts[name])})
+	return nil
+}
+
+// devList prints the dev servers started by tctx, including those which have
+// exited since.
+func devList(c *cli.Context) error {
+	instances, err := devserver.List(devServerDir(c))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 1, 1, 4, ' ', 0)
+	if _, err := fmt.Fprintln(w, "CONTEXT\tPID\tADDRESS\tWEB\tSTATUS\t"); err != nil {
+		return err
+	}
+	for _, inst := range instances {
+		status := "running"
+		if !inst.Running() {
+			status = "exited"
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t\n", inst.Context, inst.PID, inst.Address(), inst.WebAddress(), status); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
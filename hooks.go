@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/hooks"
+)
+
+// runHooks runs the global hooks for an event, followed by those of the
+// context the event applies to: the new context, or the old one when a
+// context is deleted. Hook output is written to stderr.
+func runHooks(c *cli.Context, all *config.Config, inv hooks.Invocation) error {
+	name := inv.New.Name
+	if name == "" {
+		name = inv.Old.Name
+	}
+	var contextHooks *config.Hooks
+	if cfg := all.Contexts[name]; cfg != nil {
+		contextHooks = cfg.Hooks
+	}
+	return hooks.Run(c.Context, inv, c.App.ErrWriter, all.Hooks, contextHooks)
+}
+
+// runPostHooks runs hooks after an action has completed. Failures can no
+// longer abort the action, so they are reported as warnings.
+func runPostHooks(c *cli.Context, all *config.Config, inv hooks.Invocation) {
+	if err := runHooks(c, all, inv); err != nil {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", err)
+	}
+}
+
+// hookContext describes a context to hooks.
+func hookContext(name string, cfg *config.ClusterConfig) hooks.Context {
+	if cfg == nil {
+		return hooks.Context{Name: name}
+	}
+	return hooks.Context{Name: name, Namespace: cfg.Namespace, Address: cfg.Address}
+}
+
+// runWithExecHooks runs cmd against a context, wrapped in its pre-exec and
+// post-exec hooks. The environment of the context, followed by env, is only
+// built once the pre-exec hooks have run, so that they can log in or refresh
+// credentials first. Post-exec hooks receive the exit code of the command as
+// TCTX_EXIT_CODE.
+func runWithExecHooks(c *cli.Context, all *config.Config, resolved *resolvedContext, cmd *exec.Cmd, env ...string) error {
+	inv := hooks.Invocation{Event: hooks.PreExec, New: hookContext(resolved.Name, resolved.Config)}
+	if err := runHooks(c, all, inv); err != nil {
+		return err
+	}
+
+	err := runInEnvironment(c, resolved, cmd, env)
+
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	inv.Event = hooks.PostExec
+	inv.Env = []string{fmt.Sprintf("TCTX_EXIT_CODE=%d", exitCode)}
+	runPostHooks(c, all, inv)
+
+	return err
+}
+
+// runInEnvironment runs cmd with the environment of a context, followed by env.
+func runInEnvironment(c *cli.Context, resolved *resolvedContext, cmd *exec.Cmd, env []string) error {
+	contextEnv, cleanup, err := commandEnvironment(c.Context, resolved)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	cmd.Env = append(contextEnv, env...)
+
+	// Interrupts are left to the command, so that tctx outlives it to run
+	// post-exec hooks and remove temporary files. Interrupts are caught
+	// rather than ignored, since ignored signals are inherited by the command.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	return cmd.Run()
+}
@@ -0,0 +1,248 @@
+package bundle
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+// Strategy determines how imported contexts which conflict with existing
+// contexts are handled.
+type Strategy string
+
+const (
+	// Skip keeps the existing context
+	Skip Strategy = "skip"
+	// Overwrite replaces the existing context
+	Overwrite Strategy = "overwrite"
+	// Rename imports the context under a new name
+	Rename Strategy = "rename"
+	// Prompt asks the user how to handle each conflict
+	Prompt Strategy = "prompt"
+)
+
+// Strategies lists all conflict strategies.
+var Strategies = []Strategy{Skip, Overwrite, Rename, Prompt}
+
+// ParseStrategy returns the strategy with the given name.
+func ParseStrategy(name string) (Strategy, error) {
+	for _, s := range Strategies {
+		if string(s) == name {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q: must be one of %v", name, Strategies)
+}
+
+// Report describes the changes made by an import or sync.
+type Report struct {
+	Added     []string
+	Changed   []string
+	Removed   []string
+	Unchanged []string
+	// Contexts which were not imported due to conflicts
+	Skipped []string
+	// Map of imported context names to the names they were renamed to
+	Renamed map[string]string
+	// Added or changed contexts whose auth settings lack a secret reference,
+	// which bundles never carry
+	NeedsSecret []string
+}
+
+// Import adds the contexts of b to the user config dst. Contexts which already
+// exist in any config layer (all, as returned by GetDefinitions) with different
+// settings are handled
+// according to resolve, which is called for each conflicting context.
+// Conflicts with contexts defined in read-only layers are errors unless they
+// are skipped, since the user config can't change them.
+func Import(dst *config.Config, all *config.Config, b *Bundle, resolve func(name string) (Strategy, error)) (*Report, error) {
+	report := &Report{Renamed: map[string]string{}}
+
+	for _, name := range sortedNames(b.Contexts) {
+		cfg := b.Contexts[name]
+		if cfg.Auth != nil && !needsSecret(cfg) {
+			if err := cfg.Auth.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid context %q in bundle: %w", name, err)
+			}
+		}
+		// Overwriting keeps the settings of the user's own context which
+		// bundles never carry
+		merged := cfg
+		if local := dst.Contexts[name]; local != nil {
+			merged = cfg.Clone()
+			keepLocal(merged, local)
+		}
+		existing, ok := all.Contexts[name]
+		switch {
+		case !ok:
+			dst.Contexts[name] = cfg
+			report.Added = append(report.Added, name)
+			continue
+		case reflect.DeepEqual(existing, merged):
+			report.Unchanged = append(report.Unchanged, name)
+			continue
+		}
+
+		strategy, err := resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if strategy == Skip {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+		if dst.Contexts[name] == nil {
+			return nil, fmt.Errorf("context %q is defined in read-only config file %s", name, all.Sources[name])
+		}
+		switch strategy {
+		case Overwrite:
+			dst.Contexts[name] = merged
+			report.Changed = append(report.Changed, name)
+		case Rename:
+			newName := availableName(name, all.Contexts, dst.Contexts)
+			dst.Contexts[newName] = cfg
+			report.Added = append(report.Added, newName)
+			report.Renamed[name] = newName
+		default:
+			return nil, fmt.Errorf("unsupported conflict strategy %q", strategy)
+		}
+	}
+	report.NeedsSecret = missingSecrets(dst, report)
+
+	return report, nil
+}
+
+// Sync makes the contexts managed by the named source in the user config dst
+// match the contexts of b: new contexts are added, changed contexts are
+// replaced, and contexts no longer in b are removed. Contexts which already
+// exist in any config layer (all, as returned by GetDefinitions) but are not
+// managed by the source are skipped.
+func Sync(dst *config.Config, all map[string]*config.ClusterConfig, source string, b *Bundle) *Report {
+	report := &Report{Renamed: map[string]string{}}
+
+	managed := map[string]bool{}
+	for _, name := range dst.Managed[source] {
+		managed[name] = true
+	}
+
+	var nowManaged []string
+	for _, name := range sortedNames(b.Contexts) {
+		cfg := b.Contexts[name]
+		existing, ok := all[name]
+		if local := dst.Contexts[name]; local != nil && managed[name] {
+			keepLocal(cfg, local)
+		}
+		switch {
+		case ok && !managed[name]:
+			report.Skipped = append(report.Skipped, name)
+			continue
+		case !ok || dst.Contexts[name] == nil:
+			report.Added = append(report.Added, name)
+		case reflect.DeepEqual(existing, cfg):
+			report.Unchanged = append(report.Unchanged, name)
+		default:
+			report.Changed = append(report.Changed, name)
+		}
+		dst.Contexts[name] = cfg
+		nowManaged = append(nowManaged, name)
+	}
+
+	for _, name := range dst.Managed[source] {
+		if _, ok := b.Contexts[name]; ok {
+			continue
+		}
+		if _, ok := dst.Contexts[name]; ok {
+			delete(dst.Contexts, name)
+			delete(dst.Namespaces, name)
+			report.Removed = append(report.Removed, name)
+		}
+		if dst.ActiveContext == name {
+			dst.ActiveContext = ""
+		}
+	}
+	sort.Strings(report.Removed)
+
+	if dst.Managed == nil {
+		dst.Managed = map[string][]string{}
+	}
+	if len(nowManaged) > 0 {
+		dst.Managed[source] = nowManaged
+	} else {
+		delete(dst.Managed, source)
+	}
+	report.NeedsSecret = missingSecrets(dst, report)
+
+	return report
+}
+
+// needsSecret reports whether the auth settings of cfg require a secret
+// reference but have none.
+func needsSecret(cfg *config.ClusterConfig) bool {
+	if cfg.Auth == nil || cfg.Auth.SecretRef != "" {
+		return false
+	}
+	return cfg.Auth.Type == config.AuthTypeAPIKey || cfg.Auth.Type == config.AuthTypeBearerStatic
+}
+
+// missingSecrets returns the sorted names of the contexts added or changed by
+// report which need a secret reference.
+func missingSecrets(dst *config.Config, report *Report) []string {
+	var names []string
+	for _, name := range append(append([]string{}, report.Added...), report.Changed...) {
+		if needsSecret(dst.Contexts[name]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keepLocal copies the settings which bundles never carry from the user's own
+// definition of a context (local) into cfg: commands, and the credentials which
+// Export removes unless secrets are included.
+func keepLocal(cfg, local *config.ClusterConfig) {
+	cfg.KeepCommands(local)
+	if tls := local.GetTLS(); cfg.TLS != nil && cfg.TLS.KeyPath == "" && cfg.TLS.KeyData == "" {
+		cfg.TLS.KeyPath, cfg.TLS.KeyData = tls.KeyPath, tls.KeyData
+	}
+	if cfg.Auth != nil && cfg.Auth.SecretRef == "" && local.Auth != nil && local.Auth.Type == cfg.Auth.Type {
+		cfg.Auth.SecretRef = local.Auth.SecretRef
+	}
+	for k, v := range local.Environment {
+		if _, ok := cfg.Environment[k]; ok || !config.IsSecretEnvVar(k) {
+			continue
+		}
+		if cfg.Environment == nil {
+			cfg.Environment = map[string]string{}
+		}
+		cfg.Environment[k] = v
+	}
+}
+
+// availableName returns name with the lowest numeric suffix which is not used
+// by any context.
+func availableName(name string, contexts ...map[string]*config.ClusterConfig) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		var taken bool
+		for _, c := range contexts {
+			if _, ok := c[candidate]; ok {
+				taken = true
+			}
+		}
+		if !taken {
+			return candidate
+		}
+	}
+}
+
+func sortedNames(contexts map[string]*config.ClusterConfig) []string {
+	var names []string
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,217 @@
+// Package bundle reads and writes portable sets of contexts, which teams use to
+// share cluster configuration through files or git repositories.
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+// Version is the current bundle format version.
+const Version = 1
+
+// Bundle is a portable set of contexts.
+type Bundle struct {
+	Version  int                              `json:"version" yaml:"version"`
+	Contexts map[string]*config.ClusterConfig `json:"contexts" yaml:"contexts"`
+}
+
+// ExportOptions control how contexts are exported.
+type ExportOptions struct {
+	// Directory which TLS file paths are made relative to, or empty to keep
+	// paths unchanged
+	RelativeTo string
+	// Keep secrets in the exported contexts
+	IncludeSecrets bool
+}
+
+// Export returns a bundle of the given contexts. Unless opts.IncludeSecrets is
+// set, personal credentials are removed: TLS private keys, auth secret
+// references and environment variables which look like secrets. The names of
+// removed fields are returned, sorted. Settings which run commands, such as
+// hooks, are never exported, since they run on the user's own machine.
+func Export(contexts map[string]*config.ClusterConfig, opts ExportOptions) (*Bundle, []string, error) {
+	result := &Bundle{Version: Version, Contexts: map[string]*config.ClusterConfig{}}
+	var stripped []string
+
+	for name, cfg := range contexts {
+		exported := cfg.Clone()
+		exported.RemoveCommands()
+
+		if opts.RelativeTo != "" && exported.TLS != nil {
+			for _, p := range []*string{&exported.TLS.CertPath, &exported.TLS.KeyPath, &exported.TLS.CACertPath} {
+				if *p == "" {
+					continue
+				}
+				rel, err := relativePath(opts.RelativeTo, *p)
+				if err != nil {
+					return nil, nil, err
+				}
+				*p = rel
+			}
+		}
+
+		if !opts.IncludeSecrets {
+			if exported.TLS != nil && exported.TLS.KeyPath != "" {
+				exported.TLS.KeyPath = ""
+				stripped = append(stripped, name+".tls.keyPath")
+			}
+			if exported.TLS != nil && exported.TLS.KeyData != "" {
+				exported.TLS.KeyData = ""
+				stripped = append(stripped, name+".tls.keyData")
+			}
+			if exported.Auth != nil && exported.Auth.SecretRef != "" {
+				exported.Auth.SecretRef = ""
+				stripped = append(stripped, name+".auth.secretRef")
+			}
+			for k := range exported.Environment {
+				if config.IsSecretEnvVar(k) {
+					delete(exported.Environment, k)
+					stripped = append(stripped, name+".env."+k)
+				}
+			}
+		}
+
+		result.Contexts[name] = exported
+	}
+
+	sort.Strings(stripped)
+	return result, stripped, nil
+}
+
+// Read parses a bundle file in JSON or YAML format. Relative TLS file paths
+// are resolved against the directory containing the bundle.
+func Read(path string) (*Bundle, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Bundle
+	// JSON is a subset of YAML, so the YAML parser handles both formats
+	if err := yaml.Unmarshal(b, &result); err != nil {
+		return nil, fmt.Errorf("error parsing bundle %s: %w", path, err)
+	}
+	if result.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d in %s: expected version %d", result.Version, path, Version)
+	}
+	if result.Contexts == nil {
+		result.Contexts = map[string]*config.ClusterConfig{}
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range result.Contexts {
+		// Bundles come from others, so they must not run commands on this
+		// machine or send its files as credentials
+		cfg.RemoveCommands()
+		if cfg.Auth != nil {
+			cfg.Auth.SecretRef = ""
+		}
+		if cfg.TLS == nil {
+			continue
+		}
+		for _, p := range []*string{&cfg.TLS.CertPath, &cfg.TLS.KeyPath, &cfg.TLS.CACertPath} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+// ReadSource reads a bundle file, or merges all bundle files (*.json, *.yaml
+// and *.yml) in a directory such as a git checkout. Files without a version
+// key, such as a package.json, are not bundles and are skipped. Contexts may
+// only be defined once across all files in a directory.
+func ReadSource(path string) (*Bundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return Read(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &Bundle{Version: Version, Contexts: map[string]*config.ClusterConfig{}}
+	definedIn := map[string]string{}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		file := filepath.Join(path, entry.Name())
+		if ok, err := hasVersion(file); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		b, err := Read(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, cfg := range b.Contexts {
+			if other, ok := definedIn[name]; ok {
+				return nil, fmt.Errorf("context %q is defined in both %s and %s", name, other, file)
+			}
+			definedIn[name] = file
+			result.Contexts[name] = cfg
+		}
+	}
+	return result, nil
+}
+
+// hasVersion reports whether the JSON or YAML file at path is an object with a
+// version key, as bundles are.
+func hasVersion(path string) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return false, fmt.Errorf("error parsing bundle %s: %w", path, err)
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	_, ok = m["version"]
+	return ok, nil
+}
+
+// Marshal encodes a bundle as indented JSON.
+func (b *Bundle) Marshal() ([]byte, error) {
+	out, err := json.MarshalIndent(b, "", "	")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func relativePath(base, path string) (string, error) {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absBase, absPath)
+}
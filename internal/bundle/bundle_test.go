@@ -0,0 +1,279 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+func TestExport(t *testing.T) {
+	contexts := map[string]*config.ClusterConfig{
+		"prod": {
+			Address: "prod:7233",
+			TLS: &config.TLSConfig{
+				CertPath: "/team/certs/client.pem",
+				KeyPath:  "/team/certs/client.key",
+				KeyData:  "env:PROD_TLS_KEY",
+			},
+			Auth: &config.AuthConfig{
+				Type:      config.AuthTypeAPIKey,
+				SecretRef: "env:PROD_KEY",
+			},
+			Environment: map[string]string{
+				"TEAM":         "payments",
+				"GITHUB_TOKEN": "secret",
+			},
+			Hooks: &config.Hooks{PostUse: []string{"vault login"}},
+		},
+		"ops": {
+			Address:         "ops:7233",
+			HeadersProvider: "tctx-headers",
+			TLS:             &config.TLSConfig{CertPath: "/team/certs/client.pem", CertProvider: "issue-cert"},
+			Auth:            &config.AuthConfig{Type: config.AuthTypeBearerExec, Command: "print-token"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name             string
+		opts             ExportOptions
+		expectedCert     string
+		expectedKey      string
+		expectedStripped []string
+	}{
+		{
+			name:             "strip secrets",
+			expectedCert:     "/team/certs/client.pem",
+			expectedStripped: []string{"prod.auth.secretRef", "prod.env.GITHUB_TOKEN", "prod.tls.keyData", "prod.tls.keyPath"},
+		},
+		{
+			name:         "include secrets",
+			opts:         ExportOptions{IncludeSecrets: true},
+			expectedCert: "/team/certs/client.pem",
+			expectedKey:  "/team/certs/client.key",
+		},
+		{
+			name:         "relative paths",
+			opts:         ExportOptions{IncludeSecrets: true, RelativeTo: "/team"},
+			expectedCert: filepath.Join("certs", "client.pem"),
+			expectedKey:  filepath.Join("certs", "client.key"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, stripped, err := Export(contexts, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stripped, tc.expectedStripped) {
+				t.Errorf("expected stripped fields %v, got %v", tc.expectedStripped, stripped)
+			}
+			exported := b.Contexts["prod"]
+			if exported.TLS.CertPath != tc.expectedCert {
+				t.Errorf("expected cert path %q, got %q", tc.expectedCert, exported.TLS.CertPath)
+			}
+			if exported.TLS.KeyPath != tc.expectedKey {
+				t.Errorf("expected key path %q, got %q", tc.expectedKey, exported.TLS.KeyPath)
+			}
+			if exported.Hooks != nil {
+				t.Errorf("expected hooks to be removed, got %+v", exported.Hooks)
+			}
+			ops := b.Contexts["ops"]
+			if ops.HeadersProvider != "" || ops.TLS.CertProvider != "" || ops.Auth != nil {
+				t.Errorf("expected commands to be removed, got headers provider %q, cert provider %q, auth %+v", ops.HeadersProvider, ops.TLS.CertProvider, ops.Auth)
+			}
+		})
+	}
+
+	// The original contexts must not be modified
+	if contexts["prod"].TLS.KeyPath == "" || contexts["prod"].Environment["GITHUB_TOKEN"] == "" {
+		t.Error("export modified the original contexts")
+	}
+}
+
+func TestReadSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.yaml", "version: 1\ncontexts:\n  dev:\n    address: dev:7233\n    headersProvider: evil\n    auth:\n      type: bearer-exec\n      command: evil\n    tls:\n      caPath: ca.pem\n      certProvider: evil\n"+
+		"  cloud:\n    address: cloud:7233\n    dataConverter: evil\n    auth:\n      type: apikey\n      secretRef: file:/home/u/.ssh/id_ed25519\n"+
+		"    additional:\n      PATH: /tmp/evil\n      LD_PRELOAD: evil.so\n      temporal_cli_address: evil:7233\n      REGION: eu\n")
+	write("README.md", "not a bundle")
+	write("package.json", `{"name": "team-config"}`)
+	write("list.yaml", "- not a bundle\n")
+
+	b, err := ReadSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca := b.Contexts["dev"].TLS.CACertPath; ca != filepath.Join(dir, "ca.pem") {
+		t.Errorf("expected relative CA path to be resolved against bundle directory, got %q", ca)
+	}
+	if dev := b.Contexts["dev"]; dev.HeadersProvider != "" || dev.TLS.CertProvider != "" || dev.Auth != nil {
+		t.Errorf("expected commands to be removed, got %+v", dev)
+	}
+	cloud := b.Contexts["cloud"]
+	if cloud.DataConverter != "" || cloud.Auth.SecretRef != "" {
+		t.Errorf("expected data converter and secret reference to be removed, got %+v", cloud)
+	}
+	if !reflect.DeepEqual(cloud.Environment, map[string]string{"REGION": "eu"}) {
+		t.Errorf("expected unsafe environment variables to be removed, got %v", cloud.Environment)
+	}
+
+	write("b.json", `{"version": 1, "contexts": {"dev": {"address": "other:7233"}}}`)
+	if _, err := ReadSource(dir); err == nil || !strings.Contains(err.Error(), `context "dev" is defined in both`) {
+		t.Errorf("expected duplicate context error, got %v", err)
+	}
+
+	write("b.json", `{"version": 2, "contexts": {}}`)
+	if _, err := ReadSource(dir); err == nil || !strings.Contains(err.Error(), "unsupported bundle version 2") {
+		t.Errorf("expected version error, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	newDst := func() *config.Config {
+		return &config.Config{Contexts: map[string]*config.ClusterConfig{
+			"dev": {Address: "dev:7233"},
+		}}
+	}
+	all := &config.Config{
+		Contexts: map[string]*config.ClusterConfig{
+			"dev":    {Address: "dev:7233"},
+			"shared": {Address: "shared:7233"},
+		},
+		Sources: map[string]string{
+			"dev":    "/home/user/.config/tctx/config.json",
+			"shared": "/etc/tctx/config.json",
+		},
+	}
+	resolve := func(strategy Strategy) func(string) (Strategy, error) {
+		return func(string) (Strategy, error) { return strategy, nil }
+	}
+
+	b := &Bundle{Version: Version, Contexts: map[string]*config.ClusterConfig{
+		"dev":    {Address: "other:7233"},
+		"shared": {Address: "other:7233"},
+	}}
+	for _, strategy := range []Strategy{Overwrite, Rename} {
+		dst := newDst()
+		if _, err := Import(dst, all, b, resolve(strategy)); err == nil || !strings.Contains(err.Error(), `context "shared" is defined in read-only config file /etc/tctx/config.json`) {
+			t.Errorf("%s: expected read-only conflict error, got %v", strategy, err)
+		}
+	}
+	dst := newDst()
+	report, err := Import(dst, all, b, resolve(Skip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Skipped, []string{"dev", "shared"}) {
+		t.Errorf("expected conflicts to be skipped, got %v", report.Skipped)
+	}
+	if _, ok := dst.Contexts["shared"]; ok {
+		t.Error("expected read-only context not to be written to the user config")
+	}
+
+	b = &Bundle{Version: Version, Contexts: map[string]*config.ClusterConfig{
+		"cloud": {Address: "cloud:7233", Auth: &config.AuthConfig{Type: "password"}},
+	}}
+	if _, err := Import(newDst(), all, b, resolve(Skip)); err == nil || !strings.Contains(err.Error(), `invalid context "cloud" in bundle: unknown auth type "password"`) {
+		t.Errorf("expected auth validation error, got %v", err)
+	}
+
+	// Secret references are never imported, so the user must set them
+	b.Contexts["cloud"].Auth.Type = config.AuthTypeAPIKey
+	report, err = Import(newDst(), all, b, resolve(Skip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.NeedsSecret, []string{"cloud"}) {
+		t.Errorf("expected cloud to need a secret reference, got %v", report.NeedsSecret)
+	}
+
+	// Overwriting keeps the user's commands and the credentials bundles don't carry
+	dst = &config.Config{Contexts: map[string]*config.ClusterConfig{
+		"dev": {
+			Address:         "dev:7233",
+			HeadersProvider: "tctx-headers",
+			TLS:             &config.TLSConfig{CertPath: "/certs/dev.pem", KeyPath: "/certs/dev.key"},
+			Environment:     map[string]string{"GITHUB_TOKEN": "secret"},
+			Hooks:           &config.Hooks{PostUse: []string{"vault login"}},
+		},
+	}}
+	all = &config.Config{Contexts: map[string]*config.ClusterConfig{"dev": dst.Contexts["dev"].Clone()}}
+	b = &Bundle{Version: Version, Contexts: map[string]*config.ClusterConfig{
+		"dev": {Address: "other:7233", TLS: &config.TLSConfig{CertPath: "/certs/dev.pem"}},
+	}}
+	report, err = Import(dst, all, b, resolve(Overwrite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Changed, []string{"dev"}) {
+		t.Errorf("expected dev to be changed, got %+v", report)
+	}
+	expected := &config.ClusterConfig{
+		Address:         "other:7233",
+		HeadersProvider: "tctx-headers",
+		TLS:             &config.TLSConfig{CertPath: "/certs/dev.pem", KeyPath: "/certs/dev.key"},
+		Environment:     map[string]string{"GITHUB_TOKEN": "secret"},
+		Hooks:           &config.Hooks{PostUse: []string{"vault login"}},
+	}
+	if !reflect.DeepEqual(dst.Contexts["dev"], expected) {
+		t.Errorf("expected overwritten context %+v, got %+v", expected, dst.Contexts["dev"])
+	}
+}
+
+func TestSync(t *testing.T) {
+	dst := &config.Config{
+		ActiveContext: "old",
+		Contexts: map[string]*config.ClusterConfig{
+			"old":      {Address: "old:7233"},
+			"personal": {Address: "personal:7233"},
+			"shared": {
+				Address:         "shared:7233",
+				HeadersProvider: "tctx-headers",
+				Auth:            &config.AuthConfig{Type: config.AuthTypeAPIKey, SecretRef: "env:SHARED_KEY"},
+			},
+		},
+		Namespaces: map[string]*config.NamespaceState{"old": {Current: "payments"}},
+		Managed:    map[string][]string{"team": {"old", "shared"}},
+	}
+	b := &Bundle{Version: Version, Contexts: map[string]*config.ClusterConfig{
+		"new":      {Address: "new:7233"},
+		"personal": {Address: "team:7233"},
+		"shared":   {Address: "shared.example.com:7233", Auth: &config.AuthConfig{Type: config.AuthTypeAPIKey}},
+	}}
+
+	report := Sync(dst, dst.Contexts, "team", b)
+
+	if !reflect.DeepEqual(report.Added, []string{"new"}) {
+		t.Errorf("expected new context to be added, got %v", report.Added)
+	}
+	if !reflect.DeepEqual(report.Removed, []string{"old"}) {
+		t.Errorf("expected old context to be removed, got %v", report.Removed)
+	}
+	if !reflect.DeepEqual(report.Skipped, []string{"personal"}) {
+		t.Errorf("expected unmanaged context to be skipped, got %v", report.Skipped)
+	}
+	if shared := dst.Contexts["shared"]; shared.Address != "shared.example.com:7233" || shared.HeadersProvider != "tctx-headers" || shared.Auth.SecretRef != "env:SHARED_KEY" {
+		t.Errorf("expected managed context to be updated and keep its headers provider and secret, got %+v", shared)
+	}
+	if _, ok := dst.Namespaces["old"]; ok {
+		t.Error("expected namespace state of removed context to be deleted")
+	}
+	if dst.Contexts["personal"].Address != "personal:7233" {
+		t.Error("expected unmanaged context to be left unchanged")
+	}
+	if dst.ActiveContext != "" {
+		t.Errorf("expected removed active context to be unset, got %q", dst.ActiveContext)
+	}
+	if !reflect.DeepEqual(dst.Managed["team"], []string{"new", "shared"}) {
+		t.Errorf("expected managed contexts to be updated, got %v", dst.Managed["team"])
+	}
+}
@@ -0,0 +1,245 @@
+// Package certprovider issues short-lived client certificates by running the
+// certificate provider command of a context, and caches them until they are
+// about to expire.
+package certprovider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/filelock"
+	"github.com/jlegrone/tctx/internal/shell"
+)
+
+// RefreshBefore is how long before expiry a cached certificate is replaced.
+const RefreshBefore = 5 * time.Minute
+
+const (
+	certFile    = "cert.pem"
+	keyFile     = "key.pem"
+	expiryFile  = "expiry"
+	currentFile = "current"
+	lockFile    = ".lock"
+)
+
+// now is replaced in tests.
+var now = time.Now
+
+// Credential is printed as JSON by certificate provider commands. The fields
+// match the status of a Kubernetes ExecCredential.
+type Credential struct {
+	// PEM encoded client certificate, optionally followed by intermediates
+	ClientCertificateData string `json:"clientCertificateData"`
+	// PEM encoded private key
+	ClientKeyData string `json:"clientKeyData"`
+	// When the credential must be replaced (default: expiry of the certificate)
+	ExpirationTimestamp *time.Time `json:"expirationTimestamp,omitempty"`
+}
+
+// DefaultCacheDir returns the private directory where issued certificates are
+// cached.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tctx", "certs"), nil
+}
+
+// Resolve returns cfg with the paths of a certificate and key issued by its
+// certificate provider, if it has one. Otherwise cfg is returned unchanged.
+func Resolve(ctx context.Context, cfg *config.ClusterConfig) (*config.ClusterConfig, error) {
+	if cfg.TLS == nil || cfg.TLS.CertProvider == "" {
+		return cfg, nil
+	}
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	certPath, keyPath, err := Paths(ctx, dir, cfg.TLS.CertProvider)
+	if err != nil {
+		return nil, err
+	}
+	result := cfg.Clone()
+	result.TLS.CertPath, result.TLS.KeyPath = certPath, keyPath
+	result.TLS.CertData, result.TLS.KeyData = "", ""
+	return result, nil
+}
+
+// Paths returns the paths of a certificate and key issued by command. The
+// command is only run when no certificate is cached in dir, or the cached
+// one expires within RefreshBefore.
+//
+// Each certificate is written to a new directory, which a pointer file makes
+// current with a single rename, so that readers never see the certificate of
+// one refresh with the key of another. Refreshes are serialized by a lock
+// file, and directories are removed once their certificate has expired.
+func Paths(ctx context.Context, dir, command string) (certPath, keyPath string, err error) {
+	sum := sha256.Sum256([]byte(command))
+	dir = filepath.Join(dir, hex.EncodeToString(sum[:8]))
+
+	if version, ok := cached(dir); ok {
+		return versionPaths(dir, version)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return "", "", err
+	}
+	defer lock.Close()
+	if err := filelock.Lock(lock); err != nil {
+		return "", "", fmt.Errorf("error locking certificate cache: %w", err)
+	}
+	defer func() { _ = filelock.Unlock(lock) }()
+	// Another process may have refreshed the certificate while we waited
+	if version, ok := cached(dir); ok {
+		return versionPaths(dir, version)
+	}
+
+	cred, expiry, err := issue(ctx, command)
+	if err != nil {
+		return "", "", err
+	}
+	versionDir, err := os.MkdirTemp(dir, "v")
+	if err != nil {
+		return "", "", err
+	}
+	for _, f := range []struct {
+		name string
+		data string
+	}{
+		{keyFile, cred.ClientKeyData},
+		{certFile, cred.ClientCertificateData},
+		{expiryFile, expiry.UTC().Format(time.RFC3339)},
+	} {
+		if err := os.WriteFile(filepath.Join(versionDir, f.name), []byte(f.data), 0600); err != nil {
+			_ = os.RemoveAll(versionDir)
+			return "", "", err
+		}
+	}
+	version := filepath.Base(versionDir)
+	if err := writeFile(filepath.Join(dir, currentFile), []byte(version)); err != nil {
+		_ = os.RemoveAll(versionDir)
+		return "", "", err
+	}
+	removeExpired(dir, version)
+	return versionPaths(dir, version)
+}
+
+// cached returns the current version in dir, if its certificate doesn't
+// expire within RefreshBefore.
+func cached(dir string) (string, bool) {
+	b, err := os.ReadFile(filepath.Join(dir, currentFile))
+	if err != nil {
+		return "", false
+	}
+	version := strings.TrimSpace(string(b))
+	if version == "" || version != filepath.Base(version) {
+		return "", false
+	}
+	expiry, err := readExpiry(filepath.Join(dir, version))
+	if err != nil || !now().Add(RefreshBefore).Before(expiry) {
+		return "", false
+	}
+	return version, true
+}
+
+func versionPaths(dir, version string) (certPath, keyPath string, err error) {
+	return filepath.Join(dir, version, certFile), filepath.Join(dir, version, keyFile), nil
+}
+
+// removeExpired removes the versions in dir other than current whose
+// certificate has expired, or which were never completed. Commands started
+// with an older version keep it until then.
+func removeExpired(dir, current string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == current {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if expiry, err := readExpiry(path); err != nil || !now().Before(expiry) {
+			_ = os.RemoveAll(path)
+		}
+	}
+}
+
+// issue runs command and validates the credential it prints.
+func issue(ctx context.Context, command string) (*Credential, time.Time, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := shell.Command(ctx, command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children of the shell may keep its output open after it is killed
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, time.Time{}, fmt.Errorf("error running certificate provider: %w", err)
+	}
+
+	var cred Credential
+	if err := json.Unmarshal(stdout.Bytes(), &cred); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid certificate provider output: %w", err)
+	}
+	pair, err := tls.X509KeyPair([]byte(cred.ClientCertificateData), []byte(cred.ClientKeyData))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid certificate provider output: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid certificate provider output: %w", err)
+	}
+
+	expiry := leaf.NotAfter
+	if cred.ExpirationTimestamp != nil && cred.ExpirationTimestamp.Before(expiry) {
+		expiry = *cred.ExpirationTimestamp
+	}
+	if !now().Before(expiry) {
+		return nil, time.Time{}, errors.New("certificate provider issued an expired certificate")
+	}
+	return &cred, expiry, nil
+}
+
+func readExpiry(dir string) (time.Time, error) {
+	b, err := os.ReadFile(filepath.Join(dir, expiryFile))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+}
+
+// writeFile atomically replaces a file readable only by the user.
+func writeFile(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,196 @@
+package certprovider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer writes a credential valid for validity and returns a command
+// which prints it, counting its runs in a file.
+func fakeIssuer(t *testing.T, validity time.Duration, expiration *time.Time) (command string, runs func() int) {
+	t.Helper()
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(Credential{
+		ClientCertificateData: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		ClientKeyData:         string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		ExpirationTimestamp:   expiration,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credPath := filepath.Join(dir, "credential.json")
+	if err := os.WriteFile(credPath, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	countPath := filepath.Join(dir, "runs")
+	command = fmt.Sprintf("echo run >> %q && cat %q", countPath, credPath)
+	return command, func() int {
+		b, _ := os.ReadFile(countPath)
+		return strings.Count(string(b), "run")
+	}
+}
+
+func TestPaths(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("cached until near expiry", func(t *testing.T) {
+		dir := t.TempDir()
+		command, runs := fakeIssuer(t, 12*time.Hour, nil)
+
+		var certPath, keyPath string
+		for i := 0; i < 2; i++ {
+			var err error
+			certPath, keyPath, err = Paths(ctx, dir, command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm&0077 != 0 {
+				t.Errorf("expected key to be private, got mode %s", perm)
+			}
+		}
+		if runs() != 1 {
+			t.Errorf("expected provider to run once, ran %d times", runs())
+		}
+
+		// Close to expiry, a new certificate is issued
+		defer func() { now = time.Now }()
+		now = func() time.Time { return time.Now().Add(12*time.Hour - time.Minute) }
+		newCertPath, _, err := Paths(ctx, dir, command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs() != 2 {
+			t.Errorf("expected provider to run again near expiry, ran %d times", runs())
+		}
+		// Commands using the previous certificate keep it until it expires
+		if newCertPath == certPath {
+			t.Error("expected the new certificate to be written to a new directory")
+		}
+		if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			t.Errorf("expected the previous certificate to be kept: %v", err)
+		}
+	})
+
+	t.Run("concurrent refreshes", func(t *testing.T) {
+		dir := t.TempDir()
+		command, runs := fakeIssuer(t, 12*time.Hour, nil)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				certPath, keyPath, err := Paths(ctx, dir, command)
+				if err == nil {
+					_, err = tls.LoadX509KeyPair(certPath, keyPath)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		if runs() != 1 {
+			t.Errorf("expected provider to run once, ran %d times", runs())
+		}
+	})
+
+	t.Run("expiration timestamp", func(t *testing.T) {
+		dir := t.TempDir()
+		expiration := time.Now().Add(RefreshBefore / 2)
+		command, runs := fakeIssuer(t, 12*time.Hour, &expiration)
+
+		for i := 0; i < 2; i++ {
+			if _, _, err := Paths(ctx, dir, command); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if runs() != 2 {
+			t.Errorf("expected provider to run every time, ran %d times", runs())
+		}
+	})
+
+	for _, tc := range []struct {
+		name        string
+		command     string
+		expectedErr string
+	}{
+		{
+			name:        "command fails",
+			command:     "echo ca unavailable >&2; exit 1",
+			expectedErr: "error running certificate provider: exit status 1: ca unavailable",
+		},
+		{
+			name:        "not json",
+			command:     "echo hello",
+			expectedErr: "invalid certificate provider output: invalid character",
+		},
+		{
+			name:        "no certificate",
+			command:     `echo '{"clientCertificateData": "", "clientKeyData": ""}'`,
+			expectedErr: "invalid certificate provider output: tls: failed to find any PEM data in certificate input",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := Paths(ctx, t.TempDir(), tc.command)
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("expected error %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+
+	t.Run("expired certificate", func(t *testing.T) {
+		command, _ := fakeIssuer(t, -time.Second, nil)
+		_, _, err := Paths(ctx, t.TempDir(), command)
+		if err == nil || err.Error() != "certificate provider issued an expired certificate" {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+// Package daemon polls the health and namespaces of every context in the
+// background, and serves the results over a unix socket so that menus,
+// completions and listings don't wait on the network.
+//
+// The query API is HTTP over the socket:
+//
+//	GET /v1/contexts         all statuses, by context name
+//	GET /v1/contexts/{name}  the status of one context
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jlegrone/tctx/client"
+	"github.com/jlegrone/tctx/config"
+)
+
+const (
+	// DefaultInterval is how often contexts are polled, unless another
+	// interval is given.
+	DefaultInterval = 30 * time.Second
+	// checkTimeout bounds the checks of a single context.
+	checkTimeout = 10 * time.Second
+	// queryTimeout bounds how long clients wait for the daemon, which should
+	// answer from memory.
+	queryTimeout = 200 * time.Millisecond
+)
+
+// ErrNotRunning is returned by Query when no daemon is listening on the socket.
+var ErrNotRunning = errors.New("daemon is not running")
+
+// Status is the result of polling a context.
+type Status struct {
+	// Address polled, so that results for a context which has since been
+	// changed can be told apart
+	Address string `json:"address"`
+	// Whether the frontend service passed its health check
+	Healthy bool `json:"healthy"`
+	// Error from the health check or from listing namespaces, if any
+	Error string `json:"error,omitempty"`
+	// Namespaces registered in the cluster
+	Namespaces []string  `json:"namespaces,omitempty"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// Err returns the error recorded in the status, if any.
+func (s *Status) Err() error {
+	if s.Error == "" {
+		return nil
+	}
+	return errors.New(s.Error)
+}
+
+// Statuses maps context names to their latest status.
+type Statuses map[string]*Status
+
+// Get returns the status of a context, or nil when it hasn't been polled at
+// its current address yet.
+func (s Statuses) Get(name string, cfg *config.ClusterConfig) *Status {
+	status := s[name]
+	if status == nil || cfg == nil || status.Address != cfg.Address {
+		return nil
+	}
+	return status
+}
+
+// Server polls contexts and answers queries for their statuses.
+type Server struct {
+	// load returns the contexts to poll. It is called before every poll, so
+	// that config changes are picked up.
+	load     func() (map[string]*config.ClusterConfig, error)
+	interval time.Duration
+	// check polls a single context
+	check func(ctx context.Context, cfg *config.ClusterConfig) *Status
+
+	mu       sync.RWMutex
+	statuses Statuses
+}
+
+// New returns a server polling the contexts returned by load every interval.
+func New(load func() (map[string]*config.ClusterConfig, error), interval time.Duration) *Server {
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return &Server{load: load, interval: interval, check: Check, statuses: Statuses{}}
+}
+
+// Check connects to a cluster to check its health and list its namespaces.
+func Check(ctx context.Context, cfg *config.ClusterConfig) *Status {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	status := &Status{Address: cfg.Address, CheckedAt: time.Now()}
+	opts, err := client.NewOptions(ctx, cfg)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	conn, err := opts.Dial()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer conn.Close()
+
+	if err := client.CheckHealth(ctx, conn); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Healthy = true
+	if status.Namespaces, err = client.ListNamespaces(ctx, conn); err != nil {
+		status.Error = fmt.Sprintf("error listing namespaces: %s", err)
+	}
+	return status
+}
+
+// Poll checks every context once, concurrently, and replaces the statuses of
+// all contexts with the results.
+func (s *Server) Poll(ctx context.Context) error {
+	contexts, err := s.load()
+	if err != nil {
+		return err
+	}
+	results := checkAll(ctx, contexts, s.check)
+
+	s.mu.Lock()
+	s.statuses = results
+	s.mu.Unlock()
+	return nil
+}
+
+// CheckAll checks every context concurrently, for callers which cannot wait
+// for the daemon.
+func CheckAll(ctx context.Context, contexts map[string]*config.ClusterConfig) Statuses {
+	return checkAll(ctx, contexts, Check)
+}
+
+func checkAll(ctx context.Context, contexts map[string]*config.ClusterConfig, check func(context.Context, *config.ClusterConfig) *Status) Statuses {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(Statuses, len(contexts))
+	)
+	for name, cfg := range contexts {
+		wg.Add(1)
+		go func(name string, cfg *config.ClusterConfig) {
+			defer wg.Done()
+			status := check(ctx, cfg)
+			mu.Lock()
+			results[name] = status
+			mu.Unlock()
+		}(name, cfg)
+	}
+	wg.Wait()
+	return results
+}
+
+// Serve polls contexts every interval and answers queries on lis until ctx is
+// done. Polling errors, such as an invalid config file, are passed to onError
+// and polling continues.
+func (s *Server) Serve(ctx context.Context, lis net.Listener, onError func(error)) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/contexts", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		writeJSON(w, s.statuses)
+	})
+	mux.HandleFunc("GET /v1/contexts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		status := s.statuses[r.PathValue("name")]
+		if status == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, status)
+	})
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Poll(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Listen opens the daemon socket at path. A socket left behind by a daemon
+// which is no longer running is replaced.
+func Listen(path string) (net.Listener, error) {
+	if _, err := Query(context.Background(), path); err == nil {
+		return nil, fmt.Errorf("daemon is already running on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// unixTransport sends HTTP requests to the socket at path.
+func unixTransport(path string) *http.Transport {
+	return &http.Transport{
+		// Each query is a one-off request
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+}
+
+// Query returns the statuses of all contexts from the daemon listening on the
+// socket at path, or ErrNotRunning.
+func Query(ctx context.Context, path string) (Statuses, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	httpClient := &http.Client{Transport: unixTransport(path)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://tctx/v1/contexts", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, ErrNotRunning
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected daemon response: %s", resp.Status)
+	}
+	var result Statuses
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid daemon response: %w", err)
+	}
+	return result, nil
+}
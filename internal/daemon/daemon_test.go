@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+// startServer runs a daemon on a socket in a temporary directory, reporting
+// contexts at addresses starting with "up" as healthy.
+func startServer(t *testing.T, contexts map[string]*config.ClusterConfig) (*Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	s := New(func() (map[string]*config.ClusterConfig, error) { return contexts, nil }, time.Hour)
+	s.check = func(_ context.Context, cfg *config.ClusterConfig) *Status {
+		status := &Status{Address: cfg.Address, CheckedAt: time.Now()}
+		if cfg.Address[:2] == "up" {
+			status.Healthy, status.Namespaces = true, []string{"default", "orders"}
+		} else {
+			status.Error = "connection refused"
+		}
+		return status
+	}
+
+	lis, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, lis, func(err error) { t.Error(err) }) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return s, path
+}
+
+func TestQuery(t *testing.T) {
+	up := &config.ClusterConfig{Address: "up:7233"}
+	down := &config.ClusterConfig{Address: "down:7233"}
+	s, path := startServer(t, map[string]*config.ClusterConfig{"up": up, "down": down})
+	if err := s.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := Query(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := statuses.Get("up", up); status == nil || !status.Healthy || fmt.Sprint(status.Namespaces) != "[default orders]" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if status := statuses.Get("down", down); status == nil || status.Healthy || status.Err().Error() != "connection refused" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	// Results for another address are ignored
+	if status := statuses.Get("up", &config.ClusterConfig{Address: "up:7234"}); status != nil {
+		t.Errorf("expected no status for changed context, got %+v", status)
+	}
+	if status := statuses.Get("missing", up); status != nil {
+		t.Errorf("expected no status for unknown context, got %+v", status)
+	}
+
+	// A single context can be queried too
+	resp, err := (&http.Client{Transport: unixTransport(path)}).Get("http://tctx/v1/contexts/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found, got %s", resp.Status)
+	}
+
+	if _, err := Listen(path); err == nil || err.Error() != "daemon is already running on "+path {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNotRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	if _, err := Query(context.Background(), path); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("expected ErrNotRunning, got %v", err)
+	}
+
+	// Sockets left behind are replaced
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Query(context.Background(), path); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("expected ErrNotRunning, got %v", err)
+	}
+	lis, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+}
@@ -0,0 +1,194 @@
+// Package devca generates a certificate authority with server and client
+// certificates for local development clusters using mutual TLS.
+package devca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultValidity is how long generated certificates are valid for, unless
+// another duration is given.
+const DefaultValidity = 365 * 24 * time.Hour
+
+// ErrExists is returned when certificates already exist and are not rotated.
+var ErrExists = errors.New("certificates already exist")
+
+// Options configure certificate generation.
+type Options struct {
+	// DNS names and IP addresses the server certificate is valid for
+	Hosts []string
+	// How long the certificates are valid for (default: DefaultValidity)
+	Validity time.Duration
+	// Replace existing certificates, including the CA
+	Rotate bool
+}
+
+// Files holds the paths of generated certificates and keys.
+type Files struct {
+	CACert     string
+	CAKey      string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+	// When the certificates expire
+	NotAfter time.Time
+}
+
+// Paths returns the paths of the files generated in dir.
+func Paths(dir string) *Files {
+	return &Files{
+		CACert:     filepath.Join(dir, "ca.pem"),
+		CAKey:      filepath.Join(dir, "ca-key.pem"),
+		ServerCert: filepath.Join(dir, "server.pem"),
+		ServerKey:  filepath.Join(dir, "server-key.pem"),
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client-key.pem"),
+	}
+}
+
+// Generate creates a CA in dir, and issues a server certificate for
+// opts.Hosts and a client certificate signed by it. Existing certificates are
+// only replaced when opts.Rotate is set.
+func Generate(dir string, opts Options) (*Files, error) {
+	if len(opts.Hosts) == 0 {
+		return nil, errors.New("at least one host is required for the server certificate")
+	}
+	validity := opts.Validity
+	if validity == 0 {
+		validity = DefaultValidity
+	} else if validity < 0 {
+		return nil, fmt.Errorf("invalid validity %s: must be positive", validity)
+	}
+
+	files := Paths(dir)
+	if _, err := os.Stat(files.CACert); err == nil && !opts.Rotate {
+		return nil, fmt.Errorf("%w in %s", ErrExists, dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	files.NotAfter = now.Add(validity).Truncate(time.Second)
+	template := func(cn string) (*x509.Certificate, error) {
+		serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return nil, err
+		}
+		return &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{Organization: []string{"tctx development"}, CommonName: cn},
+			// Allow for clock skew between the server and clients
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  files.NotAfter,
+		}, nil
+	}
+
+	ca, err := template("tctx development CA")
+	if err != nil {
+		return nil, err
+	}
+	ca.IsCA = true
+	ca.BasicConstraintsValid = true
+	ca.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		return nil, err
+	}
+
+	server, err := template(opts.Hosts[0])
+	if err != nil {
+		return nil, err
+	}
+	server.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range opts.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, h)
+		}
+	}
+
+	client, err := template("tctx development client")
+	if err != nil {
+		return nil, err
+	}
+	client.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	if err := writePair(files.CACert, files.CAKey, caDER, caKey); err != nil {
+		return nil, err
+	}
+	for _, leaf := range []struct {
+		template          *x509.Certificate
+		certPath, keyPath string
+	}{
+		{server, files.ServerCert, files.ServerKey},
+		{client, files.ClientCert, files.ClientKey},
+	} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		leaf.template.KeyUsage = x509.KeyUsageDigitalSignature
+		der, err := x509.CreateCertificate(rand.Reader, leaf.template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := writePair(leaf.certPath, leaf.keyPath, der, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// Install moves the certificates generated in staging to dir, replacing the
+// certificates in dir, if any. The directories must be on the same file
+// system.
+func Install(staging, dir string) error {
+	backup := dir + ".old"
+	if err := os.RemoveAll(backup); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(staging, dir); err != nil {
+		_ = os.Rename(backup, dir)
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+// writePair writes a certificate, and its private key readable only by the
+// user.
+func writePair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
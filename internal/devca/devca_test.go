@@ -0,0 +1,115 @@
+package devca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	files, err := Generate(dir, Options{Hosts: []string{"localhost", "127.0.0.1"}, Validity: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPEM, err := os.ReadFile(files.CACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("no CA certificate found")
+	}
+	serverPair, err := tls.LoadX509KeyPair(files.ServerCert, files.ServerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPair, err := tls.LoadX509KeyPair(files.ClientCert, files.ClientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain verifies for each host and usage
+	server, err := x509.ParseCertificate(serverPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if _, err := server.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("server certificate not valid for %s: %s", host, err)
+		}
+	}
+	if _, err := server.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err == nil {
+		t.Error("expected server certificate not to be valid for other hosts")
+	}
+	client, err := x509.ParseCertificate(clientPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate not valid: %s", err)
+	}
+	if !client.NotAfter.Equal(files.NotAfter) || files.NotAfter.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("unexpected expiry %s", client.NotAfter)
+	}
+
+	for _, key := range []string{files.CAKey, files.ServerKey, files.ClientKey} {
+		info, err := os.Stat(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			t.Errorf("expected %s to be private, got mode %s", key, perm)
+		}
+	}
+
+	// A mutual TLS handshake succeeds
+	serverConn, clientConn := net.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{serverPair},
+			ClientCAs:    roots,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}).Handshake()
+	}()
+	if err := tls.Client(clientConn, &tls.Config{
+		Certificates: []tls.Certificate{clientPair},
+		RootCAs:      roots,
+		ServerName:   "localhost",
+	}).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificates are only replaced when rotated
+	if _, err := Generate(dir, Options{Hosts: []string{"localhost"}}); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if _, err := Generate(dir, Options{Hosts: []string{"localhost"}, Rotate: true}); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := os.ReadFile(files.CACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rotated) == string(caPEM) {
+		t.Error("expected CA to be rotated")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	if _, err := Generate(t.TempDir(), Options{}); err == nil {
+		t.Error("expected error without hosts")
+	}
+	if _, err := Generate(t.TempDir(), Options{Hosts: []string{"localhost"}, Validity: -time.Hour}); err == nil {
+		t.Error("expected error for negative validity")
+	}
+}
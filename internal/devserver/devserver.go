@@ -0,0 +1,290 @@
+// Package devserver runs local Temporal development servers in the background,
+// keeping track of them with a state file per instance.
+package devserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// DefaultPort is the frontend port of a dev server, unless another is given.
+	DefaultPort = 7233
+	// DefaultStartTimeout is how long to wait for a dev server to become
+	// healthy.
+	DefaultStartTimeout = 30 * time.Second
+	// healthService is the gRPC service checked by Temporal health checks.
+	healthService = "temporal.api.workflowservice.v1.WorkflowService"
+	// stopTimeout is how long a dev server has to exit before it is killed.
+	stopTimeout = 10 * time.Second
+)
+
+// Options configure a dev server.
+type Options struct {
+	// Executable of the Temporal CLI, run as `<command> server start-dev`
+	Command string
+	// Name of the context registered for the dev server
+	Context string
+	// Frontend port (default: DefaultPort)
+	Port int
+	// Web UI port (default: 1000 above the frontend port)
+	UIPort int
+	// File to persist data to, instead of keeping it in memory
+	DBFilename string
+	// How long to wait for the server to become healthy (default:
+	// DefaultStartTimeout)
+	Timeout time.Duration
+}
+
+// Instance is a dev server started in the background.
+type Instance struct {
+	// Name of the context registered for the dev server
+	Context string `json:"context"`
+	PID     int    `json:"pid"`
+	// Start time of the process, so that another process reusing its pid
+	// is not mistaken for the dev server
+	ProcessStart string    `json:"processStart"`
+	Port         int       `json:"port"`
+	UIPort       int       `json:"uiPort"`
+	DBFilename   string    `json:"dbFilename,omitempty"`
+	LogPath      string    `json:"logPath"`
+	Started      time.Time `json:"started"`
+}
+
+// Address returns the host:port of the frontend service.
+func (i *Instance) Address() string {
+	return net.JoinHostPort("localhost", strconv.Itoa(i.Port))
+}
+
+// WebAddress returns the URL of the web UI.
+func (i *Instance) WebAddress() string {
+	return "http://" + net.JoinHostPort("localhost", strconv.Itoa(i.UIPort))
+}
+
+// Running reports whether the dev server process is still alive.
+func (i *Instance) Running() bool {
+	if !processAlive(i.PID) {
+		return false
+	}
+	start, err := processStart(i.PID)
+	return err == nil && start == i.ProcessStart
+}
+
+// statePath returns the path of the state file of a context's dev server.
+func statePath(dir, context string) string {
+	return filepath.Join(dir, context+".json")
+}
+
+// Start runs a dev server in the background with its output written to a log
+// file in dir, and waits until it is healthy. The server keeps running after
+// the calling process exits, until it is stopped with Stop.
+func Start(ctx context.Context, dir string, opts Options) (*Instance, error) {
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+	if opts.UIPort == 0 {
+		opts.UIPort = opts.Port + 1000
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultStartTimeout
+	}
+	if existing, err := Load(dir, opts.Context); err == nil && existing.Running() {
+		return nil, fmt.Errorf("dev server for context %q is already running with pid %d", opts.Context, existing.PID)
+	}
+	for _, port := range []int{opts.Port, opts.UIPort} {
+		lis, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			return nil, fmt.Errorf("port %d is already in use", port)
+		}
+		_ = lis.Close()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	inst := &Instance{
+		Context:    opts.Context,
+		Port:       opts.Port,
+		UIPort:     opts.UIPort,
+		DBFilename: opts.DBFilename,
+		LogPath:    filepath.Join(dir, opts.Context+".log"),
+	}
+	logFile, err := os.Create(inst.LogPath)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	args := []string{"server", "start-dev", "--port", strconv.Itoa(inst.Port), "--ui-port", strconv.Itoa(inst.UIPort)}
+	if inst.DBFilename != "" {
+		args = append(args, "--db-filename", inst.DBFilename)
+	}
+	cmd := exec.Command(opts.Command, args...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = detached()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting dev server: %w", err)
+	}
+	inst.PID, inst.Started = cmd.Process.Pid, time.Now()
+	// Reap the server if it exits while this process is still running
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	if inst.ProcessStart, err = processStart(inst.PID); err != nil {
+		_ = terminate(inst.PID, true)
+		return nil, err
+	}
+	if err := save(dir, inst); err != nil {
+		_ = terminate(inst.PID, true)
+		return nil, err
+	}
+	if err := waitHealthy(ctx, inst, opts.Timeout, exited); err != nil {
+		_ = Stop(dir, inst)
+		return nil, err
+	}
+	return inst, nil
+}
+
+// waitHealthy polls the health service of a dev server until it is serving.
+func waitHealthy(ctx context.Context, inst *Instance, timeout time.Duration, exited <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := grpc.NewClient(inst.Address(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// Retry at the polling interval while the server starts listening
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.Config{BaseDelay: 100 * time.Millisecond, MaxDelay: 100 * time.Millisecond},
+			MinConnectTimeout: time.Second,
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	health := healthpb.NewHealthClient(conn)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancelCheck := context.WithTimeout(ctx, time.Second)
+		resp, err := health.Check(checkCtx, &healthpb.HealthCheckRequest{Service: healthService})
+		cancelCheck()
+		if err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING {
+			return nil
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("dev server exited before becoming healthy%s", logTail(inst.LogPath))
+		case <-ctx.Done():
+			return fmt.Errorf("dev server did not become healthy within %s%s", timeout, logTail(inst.LogPath))
+		case <-ticker.C:
+		}
+	}
+}
+
+// logTail returns the last lines of a log file, formatted to follow an error
+// message.
+func logTail(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(bytes.TrimSpace(b)), "\n")
+	if len(lines) > 10 {
+		lines = lines[len(lines)-10:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	return ":\n" + strings.Join(lines, "\n")
+}
+
+// Stop stops a dev server, killing it if it does not exit within 10 seconds,
+// and removes its state and log files. Processes which have since reused the
+// pid of the dev server are not signalled.
+func Stop(dir string, inst *Instance) error {
+	if inst.Running() {
+		if err := terminate(inst.PID, false); err != nil {
+			return fmt.Errorf("error stopping dev server: %w", err)
+		}
+		deadline := time.Now().Add(stopTimeout)
+		for inst.Running() {
+			if time.Now().After(deadline) {
+				if err := terminate(inst.PID, true); err != nil {
+					return fmt.Errorf("error killing dev server: %w", err)
+				}
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if err := os.Remove(statePath(dir, inst.Context)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(inst.LogPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Load returns the dev server registered for a context.
+func Load(dir, context string) (*Instance, error) {
+	b, err := os.ReadFile(statePath(dir, context))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no dev server for context %q", context)
+	} else if err != nil {
+		return nil, err
+	}
+	var inst Instance
+	if err := json.Unmarshal(b, &inst); err != nil {
+		return nil, fmt.Errorf("invalid dev server state %s: %w", statePath(dir, context), err)
+	}
+	return &inst, nil
+}
+
+// List returns the dev servers started in dir, including those which have
+// exited since, ordered by context name.
+func List(dir string) ([]*Instance, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var result []*Instance
+	for _, p := range paths {
+		inst, err := Load(dir, strings.TrimSuffix(filepath.Base(p), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, inst)
+	}
+	return result, nil
+}
+
+func save(dir string, inst *Instance) error {
+	b, err := json.MarshalIndent(inst, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath(dir, inst.Context), b, 0600)
+}
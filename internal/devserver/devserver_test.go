@@ -0,0 +1,159 @@
+package devserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fakeServer string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tctx_devserver")
+	if err != nil {
+		panic(err)
+	}
+	fakeServer = filepath.Join(dir, "fakeserver")
+	if out, err := exec.Command("go", "build", "-o", fakeServer, "./testdata/fakeserver").CombinedOutput(); err != nil {
+		panic(fmt.Sprintf("error building fake server: %s\n%s", err, out))
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// freePort returns a port which is not in use.
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestStartStop(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(t.TempDir(), "dev.db")
+	opts := Options{
+		Command:    fakeServer,
+		Context:    "dev",
+		Port:       freePort(t),
+		UIPort:     freePort(t),
+		DBFilename: db,
+		Timeout:    10 * time.Second,
+	}
+
+	inst, err := Start(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inst.Running() {
+		t.Fatal("expected dev server to be running")
+	}
+	if inst.Address() != fmt.Sprintf("localhost:%d", opts.Port) {
+		t.Errorf("unexpected address %q", inst.Address())
+	}
+	if _, err := os.Stat(db); err != nil {
+		t.Errorf("expected --db-filename to be passed to the server: %v", err)
+	}
+
+	if _, err := Start(context.Background(), dir, opts); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("expected already running error, got %v", err)
+	}
+
+	instances, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].PID != inst.PID || instances[0].Context != "dev" {
+		t.Fatalf("unexpected instances: %+v", instances)
+	}
+
+	if err := Stop(dir, instances[0]); err != nil {
+		t.Fatal(err)
+	}
+	if inst.Running() {
+		t.Error("expected dev server to be stopped")
+	}
+	if instances, err := List(dir); err != nil || len(instances) != 0 {
+		t.Errorf("expected no instances after stop, got %v, %v", instances, err)
+	}
+	if _, err := Load(dir, "dev"); err == nil || err.Error() != `no dev server for context "dev"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStopStale(t *testing.T) {
+	// Another process has reused the pid of a dev server which has exited
+	other := exec.Command("sleep", "30")
+	other.SysProcAttr = detached()
+	if err := other.Start(); err != nil {
+		t.Skip("sleep is not available:", err)
+	}
+	defer func() {
+		_ = other.Process.Kill()
+		_ = other.Wait()
+	}()
+
+	dir := t.TempDir()
+	inst := &Instance{Context: "dev", PID: other.Process.Pid, ProcessStart: "stale", LogPath: filepath.Join(dir, "dev.log")}
+	if err := save(dir, inst); err != nil {
+		t.Fatal(err)
+	}
+	if inst.Running() {
+		t.Error("expected a process with another start time not to be the dev server")
+	}
+	if err := Stop(dir, inst); err != nil {
+		t.Fatal(err)
+	}
+	if !processAlive(other.Process.Pid) {
+		t.Error("expected the other process not to be signalled")
+	}
+	if instances, err := List(dir); err != nil || len(instances) != 0 {
+		t.Errorf("expected the stale state to be removed, got %v, %v", instances, err)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	t.Run("server exits", func(t *testing.T) {
+		t.Setenv("FAKE_SERVER_FAIL", "unable to open database")
+		dir := t.TempDir()
+		_, err := Start(context.Background(), dir, Options{Command: fakeServer, Context: "dev", Port: freePort(t), UIPort: freePort(t)})
+		expected := "dev server exited before becoming healthy:\nunable to open database"
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q, got %v", expected, err)
+		}
+		if instances, _ := List(dir); len(instances) != 0 {
+			t.Errorf("expected failed server to be cleaned up, got %v", instances)
+		}
+	})
+
+	t.Run("port in use", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lis.Close()
+		port := lis.Addr().(*net.TCPAddr).Port
+		_, err = Start(context.Background(), t.TempDir(), Options{Command: fakeServer, Context: "dev", Port: port, UIPort: freePort(t)})
+		if err == nil || err.Error() != fmt.Sprintf("port %d is already in use", port) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("missing executable", func(t *testing.T) {
+		_, err := Start(context.Background(), t.TempDir(), Options{Command: "tctx-no-such-server", Context: "dev", Port: freePort(t), UIPort: freePort(t)})
+		if err == nil || !strings.HasPrefix(err.Error(), "error starting dev server:") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package devserver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// detached starts the dev server in its own session, so that it outlives the
+// terminal and can be stopped along with its children.
+func detached() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive reports whether a process of the current user has the given
+// pid. Processes of other users can't be dev servers started by tctx.
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// processStart returns the start time of a process, which tells it apart
+// from later processes reusing its pid.
+func processStart(pid int) (string, error) {
+	if b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		// Fields follow the command name, which may contain spaces; the
+		// start time is the 22nd field
+		if fields := strings.Fields(string(b[bytes.LastIndexByte(b, ')')+1:])); len(fields) > 19 {
+			return fields[19], nil
+		}
+	}
+	out, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", fmt.Errorf("error getting start time of process %d: %w", pid, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// terminate signals the process group of the dev server.
+func terminate(pid int, kill bool) error {
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	return syscall.Kill(-pid, sig)
+}
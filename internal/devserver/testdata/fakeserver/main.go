@@ -0,0 +1,69 @@
+// Command fakeserver stands in for `temporal server start-dev` in tests. It
+// serves gRPC health checks on --port and the web UI on --ui-port. When
+// FAKE_SERVER_FAIL is set, it prints the value and exits with an error
+// instead.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "server" || os.Args[2] != "start-dev" {
+		fmt.Fprintln(os.Stderr, "usage: fakeserver server start-dev [flags]")
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("start-dev", flag.ExitOnError)
+	port := flags.Int("port", 7233, "frontend port")
+	uiPort := flags.Int("ui-port", 8233, "web UI port")
+	dbFilename := flags.String("db-filename", "", "database file")
+	_ = flags.Parse(os.Args[3:])
+
+	if msg := os.Getenv("FAKE_SERVER_FAIL"); msg != "" {
+		fmt.Fprintln(os.Stderr, msg)
+		os.Exit(1)
+	}
+	if *dbFilename != "" {
+		if err := os.WriteFile(*dbFilename, []byte("fake"), 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	lis, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(*port)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	uiLis, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(*uiPort)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go func() { _ = http.Serve(uiLis, http.NotFoundHandler()) }()
+
+	srv := grpc.NewServer()
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("temporal.api.workflowservice.v1.WorkflowService", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, healthSrv)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		srv.Stop()
+	}()
+	fmt.Printf("fake dev server listening on %s\n", lis.Addr())
+	_ = srv.Serve(lis)
+}
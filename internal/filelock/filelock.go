@@ -0,0 +1,4 @@
+// Package filelock takes exclusive advisory locks on files, so that tctx
+// processes don't change the same files at once. Lock blocks until the lock is
+// acquired.
+package filelock
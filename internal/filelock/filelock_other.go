@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris || windows)
+
+package filelock
+
+import "os"
+
+// Platforms without flock, such as aix and plan9, don't lock files, so
+// concurrent tctx commands may overwrite each other's changes.
+
+func Lock(*os.File) error {
+	return nil
+}
+
+func Unlock(*os.File) error {
+	return nil
+}
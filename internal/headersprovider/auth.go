@@ -0,0 +1,77 @@
+package headersprovider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/shell"
+)
+
+// NewAuth returns a Provider for API key or bearer token authentication.
+func NewAuth(cfg config.AuthConfig) (*Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newProvider(func(ctx context.Context) (map[string]string, error) {
+		token, err := AuthToken(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{cfg.GetHeader(): headerValue(cfg, token)}, nil
+	}), nil
+}
+
+// AuthToken returns the API key or bearer token for an auth configuration.
+func AuthToken(ctx context.Context, cfg config.AuthConfig) (string, error) {
+	if cfg.Type != config.AuthTypeBearerExec {
+		return config.ResolveSecret(cfg.SecretRef)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := shell.Command(ctx, cfg.Command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error running auth command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", fmt.Errorf("auth command did not print a token")
+	}
+	return token, nil
+}
+
+// AuthEnvironment returns the environment variables used by the Temporal CLI
+// (`temporal`) to authenticate with the given auth configuration. tctl reads
+// neither variable; it only authenticates through headers provider plugins.
+func AuthEnvironment(ctx context.Context, cfg config.AuthConfig) (map[string]string, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	token, err := AuthToken(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Temporal CLI sends API keys as bearer tokens in the authorization
+	// header; any other combination is passed as arbitrary gRPC metadata.
+	if cfg.Type == config.AuthTypeAPIKey && cfg.Header == "" {
+		return map[string]string{"TEMPORAL_API_KEY": token}, nil
+	}
+	return map[string]string{
+		"TEMPORAL_GRPC_META": fmt.Sprintf("%s=%s", cfg.GetHeader(), headerValue(cfg, token)),
+	}, nil
+}
+
+// headerValue returns the value of the credential header. Tokens sent in the
+// standard authorization header use the bearer scheme, while custom headers
+// carry the token as-is.
+func headerValue(cfg config.AuthConfig, token string) string {
+	if strings.EqualFold(cfg.GetHeader(), "authorization") {
+		return "Bearer " + token
+	}
+	return token
+}
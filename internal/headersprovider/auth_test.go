@@ -0,0 +1,105 @@
+package headersprovider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+func TestAuth(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TCTX_TEST_API_KEY", "env-key")
+
+	for _, tc := range []struct {
+		name            string
+		cfg             config.AuthConfig
+		expectedHeaders map[string]string
+		expectedEnv     map[string]string
+		expectedErr     bool
+	}{
+		{
+			name:            "api key",
+			cfg:             config.AuthConfig{Type: config.AuthTypeAPIKey, SecretRef: "env:TCTX_TEST_API_KEY"},
+			expectedHeaders: map[string]string{"authorization": "Bearer env-key"},
+			expectedEnv:     map[string]string{"TEMPORAL_API_KEY": "env-key"},
+		},
+		{
+			name:            "api key with custom header",
+			cfg:             config.AuthConfig{Type: config.AuthTypeAPIKey, Header: "x-api-key", SecretRef: "env:TCTX_TEST_API_KEY"},
+			expectedHeaders: map[string]string{"x-api-key": "env-key"},
+			expectedEnv:     map[string]string{"TEMPORAL_GRPC_META": "x-api-key=env-key"},
+		},
+		{
+			name:            "static bearer token",
+			cfg:             config.AuthConfig{Type: config.AuthTypeBearerStatic, SecretRef: "file:" + tokenFile},
+			expectedHeaders: map[string]string{"authorization": "Bearer file-token"},
+			expectedEnv:     map[string]string{"TEMPORAL_GRPC_META": "authorization=Bearer file-token"},
+		},
+		{
+			name:            "exec bearer token",
+			cfg:             config.AuthConfig{Type: config.AuthTypeBearerExec, Command: "echo exec-token"},
+			expectedHeaders: map[string]string{"authorization": "Bearer exec-token"},
+			expectedEnv:     map[string]string{"TEMPORAL_GRPC_META": "authorization=Bearer exec-token"},
+		},
+		{
+			name:        "failing command",
+			cfg:         config.AuthConfig{Type: config.AuthTypeBearerExec, Command: "exit 1"},
+			expectedErr: true,
+		},
+		{
+			name:        "empty command output",
+			cfg:         config.AuthConfig{Type: config.AuthTypeBearerExec, Command: "true"},
+			expectedErr: true,
+		},
+		{
+			name:        "unset environment variable",
+			cfg:         config.AuthConfig{Type: config.AuthTypeAPIKey, SecretRef: "env:TCTX_TEST_UNSET"},
+			expectedErr: true,
+		},
+		{
+			name:        "inline secret",
+			cfg:         config.AuthConfig{Type: config.AuthTypeBearerStatic, SecretRef: "my-token"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown type",
+			cfg:         config.AuthConfig{Type: "basic", SecretRef: "env:TCTX_TEST_API_KEY"},
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := AuthEnvironment(context.Background(), tc.cfg)
+			if tc.expectedErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(env, tc.expectedEnv) {
+				t.Errorf("expected environment %v, got %v", tc.expectedEnv, env)
+			}
+
+			p, err := NewAuth(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers, err := p.GetHeaders(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(headers, tc.expectedHeaders) {
+				t.Errorf("expected headers %v, got %v", tc.expectedHeaders, headers)
+			}
+		})
+	}
+}
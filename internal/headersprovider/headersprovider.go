@@ -0,0 +1,139 @@
+// Package headersprovider supplies authentication headers from tctl headers
+// provider plugins and API key or bearer token settings, caching them until
+// they expire so that tctx can authenticate its own gRPC calls.
+package headersprovider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+)
+
+const (
+	// DefaultTTL is how long headers are cached when their expiry can't be
+	// determined.
+	DefaultTTL = 5 * time.Minute
+	// expirySkew is subtracted from token expiry times so that headers are
+	// refreshed before the server starts rejecting them.
+	expirySkew = 30 * time.Second
+)
+
+// Provider fetches headers on demand and caches the result until the headers
+// expire.
+type Provider struct {
+	fetch func(ctx context.Context) (map[string]string, error)
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	headers map[string]string
+	expires time.Time
+}
+
+// New returns a Provider for the plugin executable with the given name or path.
+func New(executable string) *Provider {
+	return newProvider(func(ctx context.Context) (map[string]string, error) {
+		return runPlugin(ctx, executable)
+	})
+}
+
+func newProvider(fetch func(ctx context.Context) (map[string]string, error)) *Provider {
+	return &Provider{
+		fetch: fetch,
+		ttl:   DefaultTTL,
+		now:   time.Now,
+	}
+}
+
+// GetHeaders returns cached headers, fetching new ones if they are missing or
+// have expired.
+func (p *Provider) GetHeaders(ctx context.Context) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.headers != nil && p.now().Before(p.expires) {
+		return p.headers, nil
+	}
+
+	headers, err := p.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.headers = headers
+	p.expires = p.expiry(headers)
+
+	return headers, nil
+}
+
+func runPlugin(ctx context.Context, executable string) (map[string]string, error) {
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return nil, fmt.Errorf("headers provider plugin %q not found: %w", executable, err)
+	}
+
+	c := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  handshake,
+		Plugins:          plugin.PluginSet{pluginName: &rpcPlugin{}},
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolNetRPC},
+		Logger:           hclog.NewNullLogger(),
+	})
+	// Plugins are short-lived: kill the process once headers are retrieved
+	defer c.Kill()
+
+	rpcClient, err := c.Client()
+	if err != nil {
+		return nil, fmt.Errorf("error starting headers provider plugin %q: %w", executable, err)
+	}
+	raw, err := rpcClient.Dispense(pluginName)
+	if err != nil {
+		return nil, fmt.Errorf("error starting headers provider plugin %q: %w", executable, err)
+	}
+
+	headers, err := raw.(HeadersProvider).GetHeaders(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting headers from plugin %q: %w", executable, err)
+	}
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	return headers, nil
+}
+
+// expiry returns the time at which headers should be refreshed. Tokens in JWT
+// format carry their own expiry time; all other headers are cached for the
+// default TTL.
+func (p *Provider) expiry(headers map[string]string) time.Time {
+	for _, v := range headers {
+		if exp, ok := jwtExpiry(strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))); ok {
+			return exp.Add(-expirySkew)
+		}
+	}
+	return p.now().Add(p.ttl)
+}
+
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,122 @@
+package headersprovider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fakePlugin, tctlPlugin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tctx_headersprovider")
+	if err != nil {
+		panic(err)
+	}
+	fakePlugin = filepath.Join(dir, "fakeplugin")
+	if out, err := exec.Command("go", "build", "-o", fakePlugin, "./testdata/fakeplugin").CombinedOutput(); err != nil {
+		panic(fmt.Sprintf("error building fake plugin: %s\n%s", err, out))
+	}
+	tctlPlugin = filepath.Join(dir, "tctlplugin")
+	if out, err := exec.Command("go", "build", "-o", tctlPlugin, "./testdata/tctlplugin").CombinedOutput(); err != nil {
+		panic(fmt.Sprintf("error building tctl plugin: %s\n%s", err, out))
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestProviderCachesUntilTTL(t *testing.T) {
+	calls := setup(t, "")
+	now := time.Now()
+
+	p := New(fakePlugin)
+	p.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		headers, err := p.GetHeaders(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if headers["authorization"] != "Bearer static-token" || headers["x-fake-plugin"] != "true" {
+			t.Errorf("unexpected headers: %v", headers)
+		}
+	}
+	assertCalls(t, calls, 1)
+
+	now = now.Add(DefaultTTL)
+	if _, err := p.GetHeaders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, calls, 2)
+}
+
+func TestProviderCachesUntilTokenExpiry(t *testing.T) {
+	now := time.Now()
+	exp := now.Add(time.Hour)
+	calls := setup(t, fmt.Sprintf("%d", exp.Unix()))
+
+	p := New(fakePlugin)
+	p.now = func() time.Time { return now }
+
+	if _, err := p.GetHeaders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Token expiry takes precedence over the default TTL
+	now = now.Add(DefaultTTL)
+	if _, err := p.GetHeaders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, calls, 1)
+
+	// Headers are refreshed shortly before the token expires
+	now = exp.Add(-expirySkew)
+	if _, err := p.GetHeaders(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, calls, 2)
+}
+
+func TestTCTLPlugin(t *testing.T) {
+	headers, err := New(tctlPlugin).GetHeaders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["authorization"] != "Bearer tctl-token" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+}
+
+func TestProviderNotFound(t *testing.T) {
+	p := New("tctx-plugin-does-not-exist")
+	if _, err := p.GetHeaders(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+}
+
+// setup configures the fake plugin and returns the path of the file recording
+// its invocations.
+func setup(t *testing.T, expiry string) string {
+	t.Helper()
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv("FAKE_PLUGIN_CALLS", calls)
+	t.Setenv("FAKE_PLUGIN_EXPIRY", expiry)
+	return calls
+}
+
+func assertCalls(t *testing.T, path string, expected int) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.Count(string(b), "\n"); actual != expected {
+		t.Errorf("expected plugin to be called %d times, got %d", expected, actual)
+	}
+}
@@ -0,0 +1,75 @@
+package headersprovider
+
+import (
+	"context"
+	"net/rpc"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// pluginName is the name under which tctl headers provider plugins serve their
+// implementation.
+const pluginName = "HeadersProvider"
+
+// handshake must match the configuration used by tctl so that existing plugin
+// executables can be used unmodified.
+var handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "TEMPORAL_CLI_PLUGIN_HEADERS_PROVIDER",
+	MagicCookieValue: "abb3e448baf947eba1847b10a38554db",
+}
+
+// HeadersProvider is the interface implemented by headers provider plugins.
+type HeadersProvider interface {
+	GetHeaders(ctx context.Context, currentHeaders map[string][]string) (map[string]string, error)
+}
+
+// Serve runs a headers provider plugin. It should be called from the main
+// function of a plugin executable.
+func Serve(impl HeadersProvider) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: handshake,
+		Plugins: plugin.PluginSet{
+			pluginName: &rpcPlugin{impl: impl},
+		},
+	})
+}
+
+type rpcPlugin struct {
+	impl HeadersProvider
+}
+
+func (p *rpcPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &rpcServer{impl: p.impl}, nil
+}
+
+func (p *rpcPlugin) Client(_ *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &rpcClient{client: c}, nil
+}
+
+type rpcServer struct {
+	impl HeadersProvider
+}
+
+// GetHeaders takes the current headers as its only argument, as tctl's plugins
+// do.
+func (s *rpcServer) GetHeaders(currentHeaders map[string][]string, resp *map[string]string) error {
+	headers, err := s.impl.GetHeaders(context.Background(), currentHeaders)
+	if err != nil {
+		return err
+	}
+	*resp = headers
+	return nil
+}
+
+type rpcClient struct {
+	client *rpc.Client
+}
+
+func (c *rpcClient) GetHeaders(_ context.Context, currentHeaders map[string][]string) (map[string]string, error) {
+	var result map[string]string
+	if err := c.client.Call("Plugin.GetHeaders", currentHeaders, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
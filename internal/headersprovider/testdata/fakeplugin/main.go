@@ -0,0 +1,45 @@
+// Command fakeplugin is a headers provider plugin used in tests. Each
+// invocation appends a line to the file named by FAKE_PLUGIN_CALLS, and
+// returns an authorization header expiring at the unix time in
+// FAKE_PLUGIN_EXPIRY (if set).
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/jlegrone/tctx/internal/headersprovider"
+)
+
+type provider struct{}
+
+func (provider) GetHeaders(context.Context, map[string][]string) (map[string]string, error) {
+	f, err := os.OpenFile(os.Getenv("FAKE_PLUGIN_CALLS"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, "called"); err != nil {
+		return nil, err
+	}
+
+	token := "static-token"
+	if exp := os.Getenv("FAKE_PLUGIN_EXPIRY"); exp != "" {
+		enc := base64.RawURLEncoding
+		token = fmt.Sprintf("%s.%s.%s",
+			enc.EncodeToString([]byte(`{"alg":"none"}`)),
+			enc.EncodeToString([]byte(fmt.Sprintf(`{"exp":%s}`, exp))),
+			enc.EncodeToString([]byte("signature")),
+		)
+	}
+	return map[string]string{
+		"authorization": "Bearer " + token,
+		"x-fake-plugin": "true",
+	}, nil
+}
+
+func main() {
+	headersprovider.Serve(provider{})
+}
@@ -0,0 +1,70 @@
+// Command tctlplugin is a headers provider plugin written against tctl's
+// plugin package (github.com/temporalio/tctl/cli/plugin), rather than this
+// repository's Serve, to check that plugins built for tctl work unmodified.
+// The handshake, plugin name and RPC types below are copied from tctl, since
+// depending on tctl would pull in the Temporal server.
+package main
+
+import (
+	"context"
+	"net/rpc"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// From tctl's cli/plugin package
+
+const HeadersProviderPluginType = "HeadersProvider"
+
+var HeadersProviderHandshakeConfig = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "TEMPORAL_CLI_PLUGIN_HEADERS_PROVIDER",
+	MagicCookieValue: "abb3e448baf947eba1847b10a38554db",
+}
+
+type HeadersProvider interface {
+	GetHeaders(ctx context.Context, currentHeaders map[string][]string) (map[string]string, error)
+}
+
+type HeadersProviderPlugin struct {
+	Impl HeadersProvider
+}
+
+func (p *HeadersProviderPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &HeadersProviderRPCServer{Impl: p.Impl}, nil
+}
+
+func (HeadersProviderPlugin) Client(_ *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return nil, nil
+}
+
+type HeadersProviderRPCServer struct {
+	Impl HeadersProvider
+}
+
+func (s *HeadersProviderRPCServer) GetHeaders(currentHeaders map[string][]string, resp *map[string]string) error {
+	var err error
+	*resp, err = s.Impl.GetHeaders(context.Background(), currentHeaders)
+	return err
+}
+
+func ServeHeadersProviderPlugin(impl HeadersProvider) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: HeadersProviderHandshakeConfig,
+		Plugins: map[string]plugin.Plugin{
+			HeadersProviderPluginType: &HeadersProviderPlugin{Impl: impl},
+		},
+	})
+}
+
+// End of code from tctl
+
+type provider struct{}
+
+func (provider) GetHeaders(context.Context, map[string][]string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer tctl-token"}, nil
+}
+
+func main() {
+	ServeHeadersProviderPlugin(provider{})
+}
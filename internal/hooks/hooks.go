@@ -0,0 +1,128 @@
+// Package hooks runs the commands configured for context lifecycle events.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/shell"
+)
+
+// DefaultTimeout is the maximum run time of each hook command, unless the
+// hooks set another.
+const DefaultTimeout = 30 * time.Second
+
+// Event is a context lifecycle event.
+type Event string
+
+const (
+	PreUse     Event = "pre-use"
+	PostUse    Event = "post-use"
+	PreExec    Event = "pre-exec"
+	PostExec   Event = "post-exec"
+	PostAdd    Event = "post-add"
+	PostDelete Event = "post-delete"
+)
+
+// Context identifies a context taking part in an event. The zero value stands
+// for no context, such as the old context when none was active.
+type Context struct {
+	Name      string
+	Namespace string
+	Address   string
+}
+
+// Invocation describes an event to run hooks for.
+type Invocation struct {
+	Event Event
+	// Context that was selected before the event
+	Old Context
+	// Context selected by the event
+	New Context
+	// Additional environment variables of the form KEY=value
+	Env []string
+}
+
+// Environment returns the variables describing the invocation to hook
+// commands.
+func (i Invocation) Environment() []string {
+	return append([]string{
+		"TCTX_HOOK_EVENT=" + string(i.Event),
+		"TCTX_OLD_CONTEXT=" + i.Old.Name,
+		"TCTX_OLD_NAMESPACE=" + i.Old.Namespace,
+		"TCTX_OLD_ADDRESS=" + i.Old.Address,
+		"TCTX_NEW_CONTEXT=" + i.New.Name,
+		"TCTX_NEW_NAMESPACE=" + i.New.Namespace,
+		"TCTX_NEW_ADDRESS=" + i.New.Address,
+	}, i.Env...)
+}
+
+// Run runs the commands of each set of hooks for the event, in order,
+// stopping at the first failure. Nil sets are skipped. Command output is
+// written to output.
+func Run(ctx context.Context, inv Invocation, output io.Writer, sets ...*config.Hooks) error {
+	for _, hooks := range sets {
+		if hooks == nil {
+			continue
+		}
+		timeout := DefaultTimeout
+		if hooks.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(hooks.Timeout); err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid hook timeout %q: use a duration such as \"10s\"", hooks.Timeout)
+			}
+		}
+		for _, script := range Commands(hooks, inv.Event) {
+			if err := run(ctx, inv, script, timeout, output); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, inv Invocation, script string, timeout time.Duration, output io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := shell.Command(ctx, script)
+	cmd.Env = append(os.Environ(), inv.Environment()...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Don't wait on background processes holding the output open once the
+	// hook has been killed
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s hook %q timed out after %s", inv.Event, script, timeout)
+	} else if err != nil {
+		return fmt.Errorf("%s hook %q failed: %w", inv.Event, script, err)
+	}
+	return nil
+}
+
+// Commands returns the commands of hooks for an event.
+func Commands(hooks *config.Hooks, event Event) []string {
+	switch event {
+	case PreUse:
+		return hooks.PreUse
+	case PostUse:
+		return hooks.PostUse
+	case PreExec:
+		return hooks.PreExec
+	case PostExec:
+		return hooks.PostExec
+	case PostAdd:
+		return hooks.PostAdd
+	case PostDelete:
+		return hooks.PostDelete
+	default:
+		return nil
+	}
+}
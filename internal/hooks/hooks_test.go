@@ -0,0 +1,71 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+func TestRun(t *testing.T) {
+	inv := Invocation{
+		Event: PreUse,
+		Old:   Context{Name: "dev", Namespace: "default", Address: "localhost:7233"},
+		New:   Context{Name: "prod", Namespace: "orders", Address: "prod:7233"},
+		Env:   []string{"TCTX_EXIT_CODE=0"},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		sets           []*config.Hooks
+		expectedOutput string
+		expectedErr    string
+	}{
+		{
+			name: "global then context hooks",
+			sets: []*config.Hooks{
+				{PreUse: []string{"echo global $TCTX_HOOK_EVENT"}},
+				nil,
+				{PreUse: []string{"echo $TCTX_OLD_CONTEXT/$TCTX_OLD_NAMESPACE $TCTX_OLD_ADDRESS", "echo $TCTX_NEW_CONTEXT/$TCTX_NEW_NAMESPACE $TCTX_NEW_ADDRESS $TCTX_EXIT_CODE"}},
+			},
+			expectedOutput: "global pre-use\ndev/default localhost:7233\nprod/orders prod:7233 0\n",
+		},
+		{
+			name:           "other events are ignored",
+			sets:           []*config.Hooks{{PostUse: []string{"echo post-use"}, PreExec: []string{"echo pre-exec"}}},
+			expectedOutput: "",
+		},
+		{
+			name:           "failure stops later hooks",
+			sets:           []*config.Hooks{{PreUse: []string{"echo first; exit 3", "echo second"}}},
+			expectedOutput: "first\n",
+			expectedErr:    `pre-use hook "echo first; exit 3" failed: exit status 3`,
+		},
+		{
+			name:        "timeout",
+			sets:        []*config.Hooks{{PreUse: []string{"sleep 5"}, Timeout: "100ms"}},
+			expectedErr: `pre-use hook "sleep 5" timed out after 100ms`,
+		},
+		{
+			name:        "invalid timeout",
+			sets:        []*config.Hooks{{PreUse: []string{"true"}, Timeout: "soon"}},
+			expectedErr: `invalid hook timeout "soon": use a duration such as "10s"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var output bytes.Buffer
+			err := Run(context.Background(), inv, &output, tc.sets...)
+			if tc.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+			}
+			if output.String() != tc.expectedOutput {
+				t.Errorf("expected output %q, got %q", tc.expectedOutput, output.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package plugin finds external tctx subcommands: executables on the PATH
+// named tctx-<name>, which run as `tctx <name>`.
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Prefix of plugin executable names
+const Prefix = "tctx-"
+
+// Plugin is an executable providing a subcommand.
+type Plugin struct {
+	// Subcommand name, without Prefix
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Executables with the same name later on the PATH, which are never run
+	Shadows []string `json:"shadows,omitempty"`
+}
+
+// List returns the plugins in the directories of pathList, a PATH value,
+// sorted by name. When several directories contain the same plugin, the first
+// one wins, as when it is run.
+func List(pathList string) []Plugin {
+	var (
+		plugins []Plugin
+		index   = map[string]int{}
+	)
+	for _, dir := range filepath.SplitList(pathList) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			// PATH commonly lists directories which don't exist
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := pluginName(entry.Name())
+			if !ok {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if !isExecutable(path) {
+				continue
+			}
+			if i, seen := index[name]; seen {
+				plugins[i].Shadows = append(plugins[i].Shadows, path)
+				continue
+			}
+			index[name] = len(plugins)
+			plugins = append(plugins, Plugin{Name: name, Path: path})
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins
+}
+
+// Find returns the path of the executable providing the subcommand name, or an
+// error wrapping exec.ErrNotFound.
+func Find(name string) (string, error) {
+	if !ValidName(name) {
+		return "", &exec.Error{Name: Prefix + name, Err: exec.ErrNotFound}
+	}
+	return exec.LookPath(Prefix + name)
+}
+
+// ValidName reports whether name can be run as a plugin. Names containing
+// path separators are rejected, so that `tctx ../x` can't run arbitrary files.
+func ValidName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "-") && !strings.ContainsAny(name, `/\`)
+}
+
+// pluginName returns the subcommand provided by the file with the given name.
+func pluginName(file string) (string, bool) {
+	if !strings.HasPrefix(file, Prefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(file, Prefix)
+	if runtime.GOOS == "windows" {
+		ext := filepath.Ext(name)
+		if !executableExtensions()[strings.ToLower(ext)] {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name, ValidName(name)
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// Checked by extension in pluginName
+		return true
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+// executableExtensions returns the extensions of executables on Windows, from
+// PATHEXT, lowercased.
+func executableExtensions() map[string]bool {
+	pathExt := os.Getenv("PATHEXT")
+	if pathExt == "" {
+		pathExt = ".com;.exe;.bat;.cmd"
+	}
+	exts := map[string]bool{}
+	for _, ext := range strings.Split(pathExt, ";") {
+		if ext != "" {
+			exts[strings.ToLower(ext)] = true
+		}
+	}
+	return exts
+}
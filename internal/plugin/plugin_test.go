@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestList(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are found by extension on windows")
+	}
+	first, second := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(first, "tctx-replay"), 0755)
+	writeFile(t, filepath.Join(first, "tctx-notes"), 0644)
+	writeFile(t, filepath.Join(first, "temporal"), 0755)
+	writeFile(t, filepath.Join(second, "tctx-replay"), 0755)
+	writeFile(t, filepath.Join(second, "tctx-reset-batch"), 0755)
+	if err := os.Mkdir(filepath.Join(second, "tctx-dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pathList := first + string(os.PathListSeparator) + filepath.Join(first, "missing") + string(os.PathListSeparator) + second
+	want := []Plugin{
+		{Name: "replay", Path: filepath.Join(first, "tctx-replay"), Shadows: []string{filepath.Join(second, "tctx-replay")}},
+		{Name: "reset-batch", Path: filepath.Join(second, "tctx-reset-batch")},
+	}
+	if got := List(pathList); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"replay":      true,
+		"reset-batch": true,
+		"":            false,
+		"-x":          false,
+		"../replay":   false,
+		`..\replay`:   false,
+	} {
+		if got := ValidName(name); got != want {
+			t.Errorf("ValidName(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package project discovers project-local tctx files, which pin a context and
+// namespace for all commands run within a directory tree.
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileNames are the names of project files, in order of precedence.
+var FileNames = []string{".tctx.json", ".tctx.yaml", ".tctx.yml"}
+
+// File is a project-local tctx file.
+type File struct {
+	// Path to the project file
+	Path string `json:"-" yaml:"-"`
+	// Name of the context to use
+	Context string `json:"context" yaml:"context"`
+	// Temporal workflow namespace (default: the context's namespace)
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Additional environment variables, only applied once the file is trusted
+	Environment map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+
+	digest string
+}
+
+// Find looks for a project file in dir and each of its parent directories,
+// returning the closest one. A nil file is returned if none exists.
+func Find(dir string) (*File, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		for _, name := range FileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return Load(path)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Load parses the project file at path.
+func Load(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(b, &f)
+	} else {
+		err = yaml.Unmarshal(b, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing project file %s: %w", path, err)
+	}
+	if f.Context == "" {
+		return nil, fmt.Errorf("project file %s does not name a context", path)
+	}
+
+	sum := sha256.Sum256(b)
+	f.Path = path
+	f.digest = hex.EncodeToString(sum[:])
+
+	return &f, nil
+}
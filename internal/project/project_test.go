@@ -0,0 +1,101 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(nested, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// No project file
+	f, err := Find(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != nil {
+		t.Fatalf("expected no project file, got %s", f.Path)
+	}
+
+	writeFile(t, filepath.Join(root, ".tctx.yaml"), "context: root\nnamespace: foo\nenv:\n  FOO: bar\n")
+	f, err = Find(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path != filepath.Join(root, ".tctx.yaml") || f.Context != "root" || f.Namespace != "foo" || f.Environment["FOO"] != "bar" {
+		t.Errorf("unexpected project file: %+v", f)
+	}
+
+	// The closest project file wins, and JSON takes precedence over YAML
+	writeFile(t, filepath.Join(root, "a", ".tctx.yml"), "context: yaml\n")
+	writeFile(t, filepath.Join(root, "a", ".tctx.json"), `{"context": "json"}`)
+	f, err = Find(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Context != "json" {
+		t.Errorf("expected context %q, got %q", "json", f.Context)
+	}
+
+	// A project file must name a context
+	writeFile(t, filepath.Join(nested, ".tctx.json"), `{"namespace": "foo"}`)
+	if _, err := Find(nested); err == nil {
+		t.Error("expected error for project file without context")
+	}
+}
+
+func TestTrustStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".tctx.json")
+	writeFile(t, path, `{"context": "foo", "env": {"FOO": "bar"}}`)
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewTrustStore(filepath.Join(dir, "trusted.json"))
+	assertTrusted(t, s, f, false)
+	if err := s.Trust(f); err != nil {
+		t.Fatal(err)
+	}
+	assertTrusted(t, s, f, true)
+
+	// Modifying the file revokes trust
+	writeFile(t, path, `{"context": "foo", "env": {"FOO": "baz"}}`)
+	modified, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTrusted(t, s, modified, false)
+
+	if err := s.Trust(modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(modified); err != nil {
+		t.Fatal(err)
+	}
+	assertTrusted(t, s, modified, false)
+}
+
+func assertTrusted(t *testing.T, s *TrustStore, f *File, expected bool) {
+	t.Helper()
+	trusted, err := s.IsTrusted(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted != expected {
+		t.Errorf("expected trusted to be %t, got %t", expected, trusted)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
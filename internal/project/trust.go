@@ -0,0 +1,72 @@
+package project
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TrustStore records which project files may set environment variables. Each
+// file is trusted by content, so any change to a trusted file must be approved
+// again.
+type TrustStore struct {
+	path string
+}
+
+// NewTrustStore returns a TrustStore persisted at path.
+func NewTrustStore(path string) *TrustStore {
+	return &TrustStore{path: path}
+}
+
+// IsTrusted reports whether f has been trusted in its current form.
+func (s *TrustStore) IsTrusted(f *File) (bool, error) {
+	trusted, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	return trusted[f.Path] == f.digest, nil
+}
+
+// Trust marks the current contents of f as trusted.
+func (s *TrustStore) Trust(f *File) error {
+	trusted, err := s.read()
+	if err != nil {
+		return err
+	}
+	trusted[f.Path] = f.digest
+	return s.write(trusted)
+}
+
+// Revoke removes trust for f.
+func (s *TrustStore) Revoke(f *File) error {
+	trusted, err := s.read()
+	if err != nil {
+		return err
+	}
+	delete(trusted, f.Path)
+	return s.write(trusted)
+}
+
+// read returns a map of project file paths to trusted content digests.
+func (s *TrustStore) read() (map[string]string, error) {
+	trusted := map[string]string{}
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return trusted, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &trusted); err != nil {
+		return nil, fmt.Errorf("error parsing trusted project files %s: %w", s.path, err)
+	}
+	return trusted, nil
+}
+
+func (s *TrustStore) write(trusted map[string]string) error {
+	b, err := json.MarshalIndent(trusted, "", "	")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0600)
+}
@@ -0,0 +1,132 @@
+// Package prompt renders the selected context as a segment of a shell prompt.
+// It only reads configuration, so that it is fast enough to run on every
+// prompt.
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+// DefaultFormat is the template used when none is configured.
+const DefaultFormat = "{{.Context}}:{{.Namespace}}"
+
+// ANSI foreground color codes, by name
+var colors = map[string]int{
+	"black":   30,
+	"red":     31,
+	"green":   32,
+	"yellow":  33,
+	"blue":    34,
+	"magenta": 35,
+	"cyan":    36,
+	"white":   37,
+}
+
+// Values of the shell argument of Render, escaping colors for each shell
+const (
+	// ANSI escape codes, as understood by fish and most terminals
+	ShellANSI = "ansi"
+	// ANSI escape codes marked as zero width for readline
+	ShellBash = "bash"
+	// zsh prompt sequences
+	ShellZsh = "zsh"
+	// tmux status line styles
+	ShellTmux = "tmux"
+	// No colors
+	ShellNone = "none"
+)
+
+// Shells lists the supported values of the shell argument of Render.
+var Shells = []string{ShellANSI, ShellBash, ShellZsh, ShellTmux, ShellNone}
+
+// Data is available to prompt templates.
+type Data struct {
+	Context   string
+	Namespace string
+	Address   string
+	Labels    map[string]string
+}
+
+// CheckColor returns an error if color is not a supported color name.
+func CheckColor(color string) error {
+	if _, ok := colors[color]; !ok {
+		names := make([]string, 0, len(colors))
+		for name := range colors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unsupported color %q: must be one of %s", color, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Color returns the color of a context: its own color, or that of the first
+// rule matching its name. It is empty when neither applies.
+func Color(name string, cfg *config.ClusterConfig, rules []config.ColorRule) (string, error) {
+	if cfg.Color != "" {
+		return cfg.Color, CheckColor(cfg.Color)
+	}
+	for _, rule := range rules {
+		matched, err := path.Match(rule.Pattern, name)
+		if err != nil {
+			return "", fmt.Errorf("invalid prompt color pattern %q: %w", rule.Pattern, err)
+		}
+		if matched {
+			return rule.Color, CheckColor(rule.Color)
+		}
+	}
+	return "", nil
+}
+
+// Render writes the prompt segment for data, in color unless color is empty.
+// Text and color codes are escaped for the given shell.
+func Render(out io.Writer, format string, data Data, color, shell string) error {
+	if format == "" {
+		format = DefaultFormat
+	}
+	tmpl, err := template.New("prompt").Option("missingkey=zero").Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid prompt format: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("invalid prompt format: %w", err)
+	}
+	text := buf.String()
+
+	var start, end string
+	switch shell {
+	case ShellANSI, "":
+		if color != "" {
+			start, end = fmt.Sprintf("\x1b[%dm", colors[color]), "\x1b[0m"
+		}
+	case ShellBash:
+		// Readline counts the characters between \001 and \002 as zero width
+		if color != "" {
+			start, end = fmt.Sprintf("\x01\x1b[%dm\x02", colors[color]), "\x01\x1b[0m\x02"
+		}
+	case ShellZsh:
+		text = strings.ReplaceAll(text, "%", "%%")
+		if color != "" {
+			start, end = "%F{"+color+"}", "%f"
+		}
+	case ShellTmux:
+		text = strings.ReplaceAll(text, "#", "##")
+		if color != "" {
+			start, end = "#[fg="+color+"]", "#[default]"
+		}
+	case ShellNone:
+	default:
+		return fmt.Errorf("unsupported shell %q: must be one of %s", shell, strings.Join(Shells, ", "))
+	}
+	_, err = io.WriteString(out, start+text+end)
+	return err
+}
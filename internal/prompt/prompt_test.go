@@ -0,0 +1,81 @@
+package prompt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+func TestColor(t *testing.T) {
+	rules := []config.ColorRule{
+		{Pattern: "prod-*", Color: "red"},
+		{Pattern: "*", Color: "green"},
+	}
+	for _, tc := range []struct {
+		name     string
+		cfg      *config.ClusterConfig
+		rules    []config.ColorRule
+		expected string
+	}{
+		{"prod-us", &config.ClusterConfig{}, rules, "red"},
+		{"staging", &config.ClusterConfig{}, rules, "green"},
+		{"prod-eu", &config.ClusterConfig{Color: "yellow"}, rules, "yellow"},
+		{"staging", &config.ClusterConfig{}, nil, ""},
+	} {
+		color, err := Color(tc.name, tc.cfg, tc.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if color != tc.expected {
+			t.Errorf("expected color of %s to be %q, got %q", tc.name, tc.expected, color)
+		}
+	}
+
+	_, err := Color("prod", &config.ClusterConfig{}, []config.ColorRule{{Pattern: "prod", Color: "crimson"}})
+	if err == nil || err.Error() != `unsupported color "crimson": must be one of black, blue, cyan, green, magenta, red, white, yellow` {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = Color("prod", &config.ClusterConfig{}, []config.ColorRule{{Pattern: "[prod", Color: "red"}})
+	if err == nil || err.Error() != `invalid prompt color pattern "[prod": syntax error in pattern` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := Data{Context: "prod#1", Namespace: "100%", Address: "prod:7233"}
+	for _, tc := range []struct {
+		shell    string
+		color    string
+		expected string
+	}{
+		{ShellANSI, "red", "\x1b[31mprod#1:100%\x1b[0m"},
+		{ShellANSI, "", "prod#1:100%"},
+		{ShellBash, "red", "\x01\x1b[31m\x02prod#1:100%\x01\x1b[0m\x02"},
+		{ShellZsh, "red", "%F{red}prod#1:100%%%f"},
+		{ShellTmux, "red", "#[fg=red]prod##1:100%#[default]"},
+		{ShellNone, "red", "prod#1:100%"},
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, "", data, tc.color, tc.shell); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.shell, tc.expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, "{{.Address}} {{.Labels.env}}", data, "", ShellNone); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "prod:7233 " {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+	if err := Render(&buf, "{{.Context", data, "", ShellNone); err == nil {
+		t.Error("expected invalid format to be rejected")
+	}
+	if err := Render(&buf, "", data, "", "powershell"); err == nil || err.Error() != `unsupported shell "powershell": must be one of ansi, bash, zsh, tmux, none` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import "fmt"
+
+// frame is an opaque gRPC message which is forwarded without decoding.
+type frame struct {
+	payload []byte
+}
+
+// codec passes message payloads through unchanged.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, fmt.Errorf("proxy codec: unexpected message type %T", v)
+	}
+	return f.payload, nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return fmt.Errorf("proxy codec: unexpected message type %T", v)
+	}
+	f.payload = append([]byte(nil), data...)
+	return nil
+}
+
+// Name must match the content subtype of the messages being proxied.
+func (codec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	// Register Temporal service descriptors for namespace rewriting
+	_ "go.temporal.io/api/operatorservice/v1"
+	_ "go.temporal.io/api/workflowservice/v1"
+)
+
+const namespaceField = "namespace"
+
+// rewriteNamespace replaces the namespace field of a request message for the
+// given full method name ("/package.Service/Method"). Messages of unknown
+// methods, or without a namespace field, are returned unchanged.
+func rewriteNamespace(method string, payload []byte, namespace string) ([]byte, error) {
+	msgType, ok := requestType(method)
+	if !ok {
+		return payload, nil
+	}
+	field := msgType.Descriptor().Fields().ByName(namespaceField)
+	if field == nil || field.Kind() != protoreflect.StringKind || field.IsList() {
+		return payload, nil
+	}
+
+	msg := msgType.New()
+	if err := proto.Unmarshal(payload, msg.Interface()); err != nil {
+		return nil, fmt.Errorf("error decoding %s request: %w", method, err)
+	}
+	msg.Set(field, protoreflect.ValueOfString(namespace))
+	return proto.Marshal(msg.Interface())
+}
+
+func requestType(method string) (protoreflect.MessageType, bool) {
+	serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return nil, false
+	}
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, false
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, false
+	}
+	m := service.Methods().ByName(protoreflect.Name(methodName))
+	if m == nil {
+		return nil, false
+	}
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(m.Input().FullName())
+	if err != nil {
+		return nil, false
+	}
+	return msgType, true
+}
@@ -0,0 +1,255 @@
+// Package proxy implements a local plaintext gRPC reverse proxy which forwards
+// requests to the cluster of the active tctx context.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/jlegrone/tctx/client"
+	"github.com/jlegrone/tctx/config"
+)
+
+// Proxy forwards gRPC requests to the active context. The config file is read
+// on every request, so switching contexts with `tctx use` takes effect
+// immediately.
+type Proxy struct {
+	config           *config.ConfigManager
+	rewriteNamespace bool
+	// OnSwitch is called whenever requests start being forwarded to a different
+	// context.
+	OnSwitch func(contextName string, cfg *config.ClusterConfig)
+
+	mu       sync.Mutex
+	upstream *upstream
+}
+
+// Option configures a Proxy.
+type Option func(p *Proxy)
+
+// WithNamespaceRewrite returns the option to replace the namespace of every
+// request with the active context's namespace.
+func WithNamespaceRewrite() Option {
+	return func(p *Proxy) {
+		p.rewriteNamespace = true
+	}
+}
+
+// New returns a Proxy following the active context of t.
+func New(t *config.ConfigManager, opts ...Option) *Proxy {
+	p := &Proxy{config: t}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Serve accepts connections on lis until it is closed or ctx is done.
+func (p *Proxy) Serve(ctx context.Context, lis net.Listener) error {
+	s := grpc.NewServer(
+		grpc.ForceServerCodec(codec{}),
+		grpc.UnknownServiceHandler(p.handle),
+	)
+	go func() {
+		<-ctx.Done()
+		s.GracefulStop()
+	}()
+	defer p.closeUpstream()
+
+	return s.Serve(lis)
+}
+
+// upstream is a connection to the cluster of a single context. Connections
+// are reference counted so that in-flight requests complete after switching
+// contexts.
+type upstream struct {
+	name string
+	cfg  config.ClusterConfig
+	conn *grpc.ClientConn
+
+	refs    int
+	retired bool
+}
+
+func (p *Proxy) acquire(ctx context.Context) (*upstream, error) {
+	name, err := p.config.GetActiveContextName()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := p.config.GetContext(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if u := p.upstream; u == nil || u.name != name || !reflect.DeepEqual(u.cfg, *cfg) {
+		opts, err := client.NewOptions(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := opts.Dial()
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			u.retired = true
+			p.closeIfUnused(u)
+		}
+		p.upstream = &upstream{name: name, cfg: *cfg, conn: conn}
+		if p.OnSwitch != nil {
+			p.OnSwitch(name, cfg)
+		}
+	}
+
+	p.upstream.refs++
+	return p.upstream, nil
+}
+
+func (p *Proxy) release(u *upstream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u.refs--
+	p.closeIfUnused(u)
+}
+
+func (p *Proxy) closeIfUnused(u *upstream) {
+	if u.retired && u.refs == 0 {
+		_ = u.conn.Close()
+	}
+}
+
+func (p *Proxy) closeUpstream() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.upstream != nil {
+		p.upstream.retired = true
+		p.closeIfUnused(p.upstream)
+		p.upstream = nil
+	}
+}
+
+func (p *Proxy) handle(_ interface{}, serverStream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "could not determine method name")
+	}
+
+	u, err := p.acquire(serverStream.Context())
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "tctx proxy: %s", err)
+	}
+	defer p.release(u)
+
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+
+	clientStream, err := u.conn.NewStream(
+		metadata.NewOutgoingContext(ctx, forwardedMetadata(ctx)),
+		&grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		method,
+		grpc.ForceCodec(codec{}),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Forward requests from the downstream client, cancelling the upstream
+	// request if that fails
+	sendErr := make(chan error, 1)
+	go func() {
+		if err := p.forwardRequests(method, u.cfg.Namespace, serverStream, clientStream); err != nil {
+			sendErr <- err
+			cancel()
+		}
+	}()
+
+	// Forward responses from the upstream server
+	for i := 0; ; i++ {
+		f := &frame{}
+		if err := clientStream.RecvMsg(f); err != nil {
+			select {
+			case err := <-sendErr:
+				return err
+			default:
+			}
+			serverStream.SetTrailer(clientStream.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if i == 0 {
+			header, err := clientStream.Header()
+			if err != nil {
+				return err
+			}
+			if err := serverStream.SendHeader(header); err != nil {
+				return err
+			}
+		}
+		if err := serverStream.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Proxy) forwardRequests(method, namespace string, src grpc.ServerStream, dst grpc.ClientStream) error {
+	for {
+		f := &frame{}
+		if err := src.RecvMsg(f); err != nil {
+			if err == io.EOF {
+				return dst.CloseSend()
+			}
+			return err
+		}
+		if p.rewriteNamespace {
+			payload, err := rewriteNamespace(method, f.payload, namespace)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "tctx proxy: %s", err)
+			}
+			f.payload = payload
+		}
+		if err := dst.SendMsg(f); err != nil {
+			if err == io.EOF {
+				// The upstream error is returned by RecvMsg
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// forwardedMetadata returns the incoming request metadata, excluding headers
+// which are set by the gRPC transport.
+func forwardedMetadata(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromIncomingContext(ctx)
+	result := metadata.MD{}
+	for k, v := range md {
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") || k == "content-type" || k == "user-agent" {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// Describe returns a short description of the upstream for a context.
+func Describe(name string, cfg *config.ClusterConfig) string {
+	mode := "plaintext"
+	if cfg.GetTLS() != (config.TLSConfig{}) {
+		mode = "tls"
+	}
+	return fmt.Sprintf("context %q (%s, %s, namespace %q)", name, cfg.Address, mode, cfg.Namespace)
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	namespacepb "go.temporal.io/api/namespace/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+func TestProxy(t *testing.T) {
+	t.Setenv("TCTX_TEST_TOKEN", "secret")
+	upstreamA := startUpstream(t, "a")
+	upstreamB := startUpstream(t, "b")
+
+	m := newConfigManager(t, map[string]*config.ClusterConfig{
+		"a": {Address: upstreamA, Namespace: "ns-a"},
+		"b": {
+			Address:   upstreamB,
+			Namespace: "ns-b",
+			Auth:      &config.AuthConfig{Type: config.AuthTypeBearerStatic, SecretRef: "env:TCTX_TEST_TOKEN"},
+		},
+	})
+	if err := m.SetActiveContext("a", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var switches []string
+	p := New(m)
+	p.OnSwitch = func(name string, _ *config.ClusterConfig) {
+		switches = append(switches, name)
+	}
+	conn := startProxy(t, p)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer downstream", "x-custom", "foo")
+
+	// Requests are forwarded as-is, including headers
+	info := describeNamespace(ctx, t, conn, "requested")
+	assertInfo(t, info, "a", "requested", map[string]string{"authorization": "Bearer downstream", "x-custom": "foo"})
+
+	// Switching contexts takes effect on the next request, with credentials of
+	// the new context replacing downstream credentials
+	if err := m.SetActiveContext("b", ""); err != nil {
+		t.Fatal(err)
+	}
+	info = describeNamespace(ctx, t, conn, "requested")
+	assertInfo(t, info, "b", "requested", map[string]string{"authorization": "Bearer secret", "x-custom": "foo"})
+
+	// Streaming methods are forwarded
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected status SERVING, got %s", resp.Status)
+	}
+
+	// Errors from the upstream server are passed through
+	_, err = workflowservice.NewWorkflowServiceClient(conn).GetSystemInfo(ctx, &workflowservice.GetSystemInfoRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented error, got: %v", err)
+	}
+
+	if strings.Join(switches, ",") != "a,b" {
+		t.Errorf("expected switches to contexts a and b, got %v", switches)
+	}
+}
+
+func TestProxyNamespaceRewrite(t *testing.T) {
+	m := newConfigManager(t, map[string]*config.ClusterConfig{
+		"a": {Address: startUpstream(t, "a"), Namespace: "ns-a"},
+	})
+	if err := m.SetActiveContext("a", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := startProxy(t, New(m, WithNamespaceRewrite()))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info := describeNamespace(ctx, t, conn, "requested")
+	assertInfo(t, info, "a", "ns-a", nil)
+
+	// Requests without a namespace field are unaffected
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyNoActiveContext(t *testing.T) {
+	conn := startProxy(t, New(newConfigManager(t, nil)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable error, got: %v", err)
+	}
+}
+
+func newConfigManager(t *testing.T, contexts map[string]*config.ClusterConfig) *config.ConfigManager {
+	t.Helper()
+	m, err := config.NewConfigManager(config.WithConfigFile(filepath.Join(t.TempDir(), "config.json")), config.WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, cfg := range contexts {
+		if err := m.UpsertContext(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// startProxy serves p on a local port and returns a connection to it.
+func startProxy(t *testing.T, p *Proxy) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := p.Serve(ctx, lis); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// startUpstream starts a gRPC server standing in for a Temporal frontend and
+// returns its address.
+func startUpstream(t *testing.T, id string) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	workflowservice.RegisterWorkflowServiceServer(s, &workflowService{id: id})
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// workflowService describes namespaces by echoing the requested namespace,
+// the server's id and selected request headers.
+type workflowService struct {
+	workflowservice.UnimplementedWorkflowServiceServer
+	id string
+}
+
+func (s *workflowService) DescribeNamespace(ctx context.Context, req *workflowservice.DescribeNamespaceRequest) (*workflowservice.DescribeNamespaceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	data := map[string]string{}
+	for k, v := range md {
+		if k == "authorization" || strings.HasPrefix(k, "x-") {
+			data[k] = strings.Join(v, ",")
+		}
+	}
+	return &workflowservice.DescribeNamespaceResponse{
+		NamespaceInfo: &namespacepb.NamespaceInfo{
+			Name:        req.GetNamespace(),
+			Description: s.id,
+			Data:        data,
+		},
+	}, nil
+}
+
+func describeNamespace(ctx context.Context, t *testing.T, conn *grpc.ClientConn, namespace string) *namespacepb.NamespaceInfo {
+	t.Helper()
+	resp, err := workflowservice.NewWorkflowServiceClient(conn).DescribeNamespace(ctx, &workflowservice.DescribeNamespaceRequest{
+		Namespace: namespace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.GetNamespaceInfo()
+}
+
+func assertInfo(t *testing.T, info *namespacepb.NamespaceInfo, upstream, namespace string, headers map[string]string) {
+	t.Helper()
+	if info.GetDescription() != upstream {
+		t.Errorf("expected request to be forwarded to upstream %q, got %q", upstream, info.GetDescription())
+	}
+	if info.GetName() != namespace {
+		t.Errorf("expected namespace %q, got %q", namespace, info.GetName())
+	}
+	for k, v := range headers {
+		if actual := info.GetData()[k]; actual != v {
+			t.Errorf("expected header %s=%q, got %q", k, v, actual)
+		}
+	}
+}
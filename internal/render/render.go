@@ -0,0 +1,311 @@
+// Package render generates deployment configuration for workers connecting to
+// the cluster of a context: Kubernetes manifests, Helm values and Docker
+// Compose environments.
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+// TLSMountPath is the directory where TLS files are mounted in containers.
+const TLSMountPath = "/etc/temporal/tls"
+
+// Names of TLS files in secrets and mounted directories
+const (
+	certFile = "tls.crt"
+	keyFile  = "tls.key"
+	caFile   = "ca.crt"
+)
+
+// Workload holds the connection settings of a worker deployment.
+type Workload struct {
+	// Name of the deployment, used to name generated resources
+	Name       string
+	Address    string
+	Namespace  string
+	ServerName string
+	// Disable TLS host name verification
+	DisableHostVerification bool
+	// PEM encoded TLS files, if any
+	Cert, Key, CA []byte
+	// Host paths of TLS files, when read from files
+	CertPath, KeyPath, CAPath string
+	// Additional environment variables, split by whether they hold secrets
+	Env, SecretEnv map[string]string
+}
+
+// NewWorkload returns the workload for a context, reading its TLS files.
+func NewWorkload(name string, cfg *config.ClusterConfig) (*Workload, error) {
+	tls := cfg.GetTLS()
+	result := &Workload{
+		Name:                    name,
+		Address:                 cfg.Address,
+		Namespace:               cfg.Namespace,
+		ServerName:              tls.ServerName,
+		DisableHostVerification: tls.DisableHostVerification,
+		Env:                     map[string]string{},
+		SecretEnv:               map[string]string{},
+	}
+	var err error
+	if result.Cert, err = tls.CertPEM(); err != nil {
+		return nil, fmt.Errorf("error reading client certificate: %w", err)
+	}
+	if result.Key, err = tls.KeyPEM(); err != nil {
+		return nil, fmt.Errorf("error reading client key: %w", err)
+	}
+	if result.CA, err = tls.CAPEM(); err != nil {
+		return nil, fmt.Errorf("error reading server CA certificate: %w", err)
+	}
+	if tls.CertData == "" {
+		result.CertPath = tls.CertPath
+	}
+	if tls.KeyData == "" {
+		result.KeyPath = tls.KeyPath
+	}
+	if tls.CAData == "" {
+		result.CAPath = tls.CACertPath
+	}
+	for k, v := range cfg.Environment {
+		if config.IsSecretEnvVar(k) {
+			result.SecretEnv[k] = v
+		} else {
+			result.Env[k] = v
+		}
+	}
+	return result, nil
+}
+
+// tlsFiles lists the TLS files of the workload present in the context.
+func (w *Workload) tlsFiles() []tlsFile {
+	var result []tlsFile
+	for _, f := range []tlsFile{
+		{certFile, "TEMPORAL_TLS_CLIENT_CERT_PATH", w.Cert, w.CertPath},
+		{keyFile, "TEMPORAL_TLS_CLIENT_KEY_PATH", w.Key, w.KeyPath},
+		{caFile, "TEMPORAL_TLS_SERVER_CA_CERT_PATH", w.CA, w.CAPath},
+	} {
+		if f.data != nil {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+type tlsFile struct {
+	name     string
+	envVar   string
+	data     []byte
+	hostPath string
+}
+
+// hasTLS reports whether the workload connects with TLS.
+func (w *Workload) hasTLS() bool {
+	return len(w.tlsFiles()) > 0 || w.ServerName != "" || w.DisableHostVerification
+}
+
+// environment returns the variables configuring Temporal clients, other than
+// secrets. TLS file paths refer to files mounted at TLSMountPath.
+func (w *Workload) environment() map[string]string {
+	result := map[string]string{
+		"TEMPORAL_ADDRESS":   w.Address,
+		"TEMPORAL_NAMESPACE": w.Namespace,
+	}
+	if w.hasTLS() {
+		result["TEMPORAL_TLS"] = "true"
+	}
+	if w.ServerName != "" {
+		result["TEMPORAL_TLS_SERVER_NAME"] = w.ServerName
+	}
+	if w.DisableHostVerification {
+		result["TEMPORAL_TLS_DISABLE_HOST_VERIFICATION"] = "true"
+	}
+	for _, f := range w.tlsFiles() {
+		result[f.envVar] = path.Join(TLSMountPath, f.name)
+	}
+	for k, v := range w.Env {
+		result[k] = v
+	}
+	return result
+}
+
+// Kubernetes writes a ConfigMap with the connection settings, a Secret with
+// the TLS files and secret environment variables, and a snippet of a pod
+// spec using them.
+func Kubernetes(out io.Writer, w *Workload) error {
+	configMapName, secretName := w.Name+"-temporal", w.Name+"-temporal-secret"
+
+	configMap := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata(configMapName),
+		"data":       w.environment(),
+	}
+	secretData := map[string]string{}
+	for _, f := range w.tlsFiles() {
+		secretData[f.name] = base64.StdEncoding.EncodeToString(f.data)
+	}
+	for k, v := range w.SecretEnv {
+		secretData[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   metadata(secretName),
+		"type":       "Opaque",
+		"data":       secretData,
+	}
+
+	container := map[string]interface{}{
+		"envFrom": []interface{}{
+			map[string]interface{}{"configMapRef": map[string]string{"name": configMapName}},
+		},
+	}
+	var env []interface{}
+	for _, k := range sortedKeys(w.SecretEnv) {
+		env = append(env, map[string]interface{}{
+			"name": k,
+			"valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]string{"name": secretName, "key": k},
+			},
+		})
+	}
+	if env != nil {
+		container["env"] = env
+	}
+	pod := map[string]interface{}{"containers": []interface{}{container}}
+	if files := w.tlsFiles(); len(files) > 0 {
+		var items []interface{}
+		for _, f := range files {
+			items = append(items, map[string]string{"key": f.name, "path": f.name})
+		}
+		container["volumeMounts"] = []interface{}{
+			map[string]interface{}{"name": "temporal-tls", "mountPath": TLSMountPath, "readOnly": true},
+		}
+		pod["volumes"] = []interface{}{
+			map[string]interface{}{
+				"name":   "temporal-tls",
+				"secret": map[string]interface{}{"secretName": secretName, "items": items},
+			},
+		}
+	}
+
+	if err := writeYAML(out, configMap); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(out, "---"); err != nil {
+		return err
+	}
+	if err := writeYAML(out, secret); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "---\n# Add to the pod spec of %s:\n", w.Name); err != nil {
+		return err
+	}
+	return writeYAMLComment(out, pod)
+}
+
+// Helm writes chart values holding the connection settings and TLS files.
+func Helm(out io.Writer, w *Workload) error {
+	temporal := map[string]interface{}{
+		"address":   w.Address,
+		"namespace": w.Namespace,
+	}
+	if w.hasTLS() {
+		tls := map[string]interface{}{"enabled": true}
+		if w.ServerName != "" {
+			tls["serverName"] = w.ServerName
+		}
+		if w.DisableHostVerification {
+			tls["disableHostVerification"] = true
+		}
+		for _, f := range []struct {
+			key  string
+			data []byte
+		}{{"cert", w.Cert}, {"key", w.Key}, {"ca", w.CA}} {
+			if f.data != nil {
+				tls[f.key] = string(f.data)
+			}
+		}
+		temporal["tls"] = tls
+	}
+	if len(w.Env) > 0 {
+		temporal["env"] = w.Env
+	}
+	if len(w.SecretEnv) > 0 {
+		temporal["secretEnv"] = w.SecretEnv
+	}
+	return writeYAML(out, map[string]interface{}{"temporal": temporal})
+}
+
+// Compose writes the environment of a Docker Compose service, with TLS files
+// mounted from the host.
+func Compose(out io.Writer, w *Workload) error {
+	env := w.environment()
+	for k, v := range w.SecretEnv {
+		env[k] = v
+	}
+	service := map[string]interface{}{"environment": env}
+
+	var volumes []string
+	for _, f := range w.tlsFiles() {
+		if f.hostPath == "" {
+			return fmt.Errorf("compose output requires TLS files: inline %s data is not supported", f.name)
+		}
+		volumes = append(volumes, f.hostPath+":"+path.Join(TLSMountPath, f.name)+":ro")
+	}
+	if volumes != nil {
+		service["volumes"] = volumes
+	}
+	return writeYAML(out, map[string]interface{}{
+		"services": map[string]interface{}{w.Name: service},
+	})
+}
+
+func metadata(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"labels": map[string]string{"app.kubernetes.io/managed-by": "tctx"},
+	}
+}
+
+func writeYAML(out io.Writer, v interface{}) error {
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writeYAMLComment writes v as YAML with every line commented out, since it
+// is a snippet rather than a resource.
+func writeYAMLComment(out io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	if err := writeYAML(&buf, v); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if _, err := fmt.Fprintln(out, "#", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,251 @@
+// Package share encodes single contexts as compact tokens which can be pasted
+// into `tctx add --from_token` to onboard teammates.
+//
+// A token has the form
+//
+//	tctx<version>.<payload>[.<public key>.<signature>]
+//
+// where each part after the version is base64url encoded. The payload is the
+// JSON encoding of the context without any secrets. Signed tokens carry the
+// ed25519 public key of the signer, whose fingerprint is shown to the
+// recipient. Since anyone can re-sign a modified token with a key of their
+// own, a signature only authenticates a token when the recipient checks the
+// fingerprint against one they trust.
+package share
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/bundle"
+)
+
+// Version is the current token format version.
+const Version = 1
+
+const prefix = "tctx"
+
+var encoding = base64.RawURLEncoding
+
+// Token is a decoded share token.
+type Token struct {
+	// Name of the shared context
+	Name string `json:"name"`
+	// Context configuration, without secrets
+	Context *config.ClusterConfig `json:"context"`
+	// Fields which were removed because they hold secrets, such as
+	// "tls.keyPath" or "env.GITHUB_TOKEN"
+	Omitted []string `json:"omitted,omitempty"`
+	// Fingerprint of the key which signed the token, or empty if the token is
+	// unsigned. This is not part of the encoded payload.
+	Signer string `json:"-"`
+}
+
+// Encode returns a token for the named context. Secrets are removed, and the
+// token is signed if key is not nil.
+func Encode(name string, cfg *config.ClusterConfig, key ed25519.PrivateKey) (string, *Token, error) {
+	b, stripped, err := bundle.Export(map[string]*config.ClusterConfig{name: cfg}, bundle.ExportOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+	t := &Token{Name: name, Context: b.Contexts[name]}
+	for _, field := range stripped {
+		t.Omitted = append(t.Omitted, strings.TrimPrefix(field, name+"."))
+	}
+
+	payload, err := compactJSON(t)
+	if err != nil {
+		return "", nil, err
+	}
+	token := fmt.Sprintf("%s%d.%s", prefix, Version, encoding.EncodeToString(payload))
+	if key == nil {
+		return token, t, nil
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	sig := ed25519.Sign(key, []byte(token))
+	t.Signer = Fingerprint(pub)
+	return token + "." + encoding.EncodeToString(pub) + "." + encoding.EncodeToString(sig), t, nil
+}
+
+// Decode parses a token, verifying its signature if it is signed. The signer
+// must still be compared with a trusted fingerprint.
+func Decode(token string) (*Token, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if !strings.HasPrefix(parts[0], prefix) {
+		return nil, errors.New("invalid token: not a tctx share token")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[0], prefix))
+	if err != nil {
+		return nil, errors.New("invalid token: missing version")
+	}
+	if version != Version {
+		return nil, fmt.Errorf("unsupported token version %d: this version of tctx reads version %d tokens", version, Version)
+	}
+	if len(parts) != 2 && len(parts) != 4 {
+		return nil, errors.New("invalid token: unexpected number of parts")
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	var signer string
+	if len(parts) == 4 {
+		pub, err := encoding.DecodeString(parts[2])
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid token: malformed public key")
+		}
+		sig, err := encoding.DecodeString(parts[3])
+		if err != nil {
+			return nil, errors.New("invalid token: malformed signature")
+		}
+		if !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+			return nil, errors.New("token signature is invalid: the token may have been modified")
+		}
+		signer = Fingerprint(pub)
+	}
+
+	var result Token
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if result.Name == "" || result.Context == nil {
+		return nil, errors.New("invalid token: missing context")
+	}
+	result.Signer = signer
+	// Commands and secret references are never accepted from others
+	result.Context.RemoveCommands()
+	if auth := result.Context.Auth; auth != nil && auth.SecretRef != "" {
+		auth.SecretRef = ""
+		if !slices.Contains(result.Omitted, "auth.secretRef") {
+			result.Omitted = append(result.Omitted, "auth.secretRef")
+		}
+	}
+
+	return &result, nil
+}
+
+// Preview returns a YAML description of the non-empty fields of cfg.
+func Preview(cfg *config.ClusterConfig) ([]byte, error) {
+	b, err := compactJSON(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(fields)
+}
+
+// compactJSON encodes v as JSON without empty fields, which keeps tokens short.
+func compactJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(prune(fields))
+}
+
+// prune removes empty values from decoded JSON objects, returning nil if
+// nothing remains.
+func prune(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if field = prune(field); field == nil {
+				delete(v, k)
+			} else {
+				v[k] = field
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		return v
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+	case string:
+		if v == "" {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return v
+}
+
+// Fingerprint returns a short identifier for a public key.
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// LoadOrCreateKey reads the PEM encoded signing key at path, generating and
+// saving a new key if none exists.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createKey(path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("error parsing signing key %s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+func createKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
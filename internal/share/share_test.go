@@ -0,0 +1,185 @@
+package share
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+var prod = &config.ClusterConfig{
+	Address:   "prod:7233",
+	Namespace: "myapp",
+	TLS: &config.TLSConfig{
+		CertPath: "/certs/client.pem",
+		KeyPath:  "/certs/client.key",
+	},
+	Auth: &config.AuthConfig{
+		Type:      config.AuthTypeAPIKey,
+		SecretRef: "env:PROD_KEY",
+	},
+}
+
+func TestRoundTrip(t *testing.T) {
+	key, err := LoadOrCreateKey(filepath.Join(t.TempDir(), "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		key            ed25519.PrivateKey
+		expectedSigner string
+	}{
+		{name: "unsigned"},
+		{name: "signed", key: key, expectedSigner: Fingerprint(key.Public().(ed25519.PublicKey))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token, _, err := Encode("prod", prod, tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded.Name != "prod" {
+				t.Errorf("expected name %q, got %q", "prod", decoded.Name)
+			}
+			if decoded.Signer != tc.expectedSigner {
+				t.Errorf("expected signer %q, got %q", tc.expectedSigner, decoded.Signer)
+			}
+			expectedOmitted := []string{"auth.secretRef", "tls.keyPath"}
+			if !reflect.DeepEqual(decoded.Omitted, expectedOmitted) {
+				t.Errorf("expected omitted fields %v, got %v", expectedOmitted, decoded.Omitted)
+			}
+			if decoded.Context.TLS.KeyPath != "" || decoded.Context.Auth.SecretRef != "" {
+				t.Error("expected secrets to be removed from token")
+			}
+			if decoded.Context.Address != prod.Address || decoded.Context.TLS.CertPath != prod.TLS.CertPath {
+				t.Errorf("expected context settings to be preserved, got %+v", decoded.Context)
+			}
+		})
+	}
+
+	// The shared context must not be modified
+	if prod.TLS.KeyPath == "" || prod.Auth.SecretRef == "" {
+		t.Error("encoding modified the original context")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	key, err := LoadOrCreateKey(filepath.Join(t.TempDir(), "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, _, err := Encode("prod", prod, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := Encode("other", &config.ClusterConfig{Address: "evil:7233"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(signed, ".")
+
+	for _, tc := range []struct {
+		name          string
+		token         string
+		expectedError string
+	}{
+		{
+			name:          "tampered payload",
+			token:         strings.Join([]string{parts[0], strings.Split(other, ".")[1], parts[2], parts[3]}, "."),
+			expectedError: "token signature is invalid: the token may have been modified",
+		},
+		{
+			name:          "tampered version",
+			token:         "tctx2." + strings.Join(parts[1:], "."),
+			expectedError: "unsupported token version 2: this version of tctx reads version 1 tokens",
+		},
+		{
+			name:          "not a token",
+			token:         "hello",
+			expectedError: "invalid token: not a tctx share token",
+		},
+		{
+			name:          "truncated signature",
+			token:         strings.Join(parts[:3], "."),
+			expectedError: "invalid token: unexpected number of parts",
+		},
+		{
+			name:          "bad payload",
+			token:         "tctx1.e30",
+			expectedError: "invalid token: missing context",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(tc.token)
+			if err == nil || err.Error() != tc.expectedError {
+				t.Errorf("expected error %q, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestDecodeRemovesCommands(t *testing.T) {
+	// Tokens made by Encode never contain commands, but others can write them
+	payload := `{"name":"prod","context":{"address":"prod:7233","headersProvider":"evil",` +
+		`"tls":{"certProvider":"evil"},"auth":{"type":"bearer-exec","command":"evil"},` +
+		`"hooks":{"postUse":["evil"]}}}`
+	token := fmt.Sprintf("%s%d.%s", prefix, Version, encoding.EncodeToString([]byte(payload)))
+
+	decoded, err := Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := decoded.Context
+	if cfg.HeadersProvider != "" || cfg.GetTLS().CertProvider != "" || cfg.Auth != nil || cfg.Hooks != nil {
+		t.Errorf("expected commands to be removed, got %+v", cfg)
+	}
+	if cfg.Address != "prod:7233" {
+		t.Errorf("expected address to be kept, got %q", cfg.Address)
+	}
+
+	// Nor settings which run plugins, change how commands run or read local files
+	payload = `{"name":"prod","context":{"address":"prod:7233","dataConverter":"evil",` +
+		`"auth":{"type":"apikey","secretRef":"file:/home/u/.ssh/id_ed25519"},` +
+		`"additional":{"PATH":"/tmp/evil","LD_PRELOAD":"evil.so","TEMPORAL_CLI_PLUGIN_HEADERS_PROVIDER":"evil","REGION":"eu"}}}`
+	token = fmt.Sprintf("%s%d.%s", prefix, Version, encoding.EncodeToString([]byte(payload)))
+
+	decoded, err = Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = decoded.Context
+	if cfg.DataConverter != "" || cfg.Auth.SecretRef != "" {
+		t.Errorf("expected data converter and secret reference to be removed, got %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Environment, map[string]string{"REGION": "eu"}) {
+		t.Errorf("expected unsafe environment variables to be removed, got %v", cfg.Environment)
+	}
+	if !reflect.DeepEqual(decoded.Omitted, []string{"auth.secretRef"}) {
+		t.Errorf("expected the secret reference to be asked for, got %v", decoded.Omitted)
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	created, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Equal(loaded) {
+		t.Error("expected the saved key to be loaded")
+	}
+}
@@ -0,0 +1,223 @@
+// Package watch reports changes to the tctx config, and optionally to the
+// reachability of clusters, as a stream of events.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/daemon"
+)
+
+// Event types
+const (
+	ActiveContextChanged = "active-context-changed"
+	NamespaceChanged     = "namespace-changed"
+	ContextAdded         = "context-added"
+	ContextRemoved       = "context-removed"
+	ClusterUnreachable   = "cluster-unreachable"
+	ClusterReachable     = "cluster-reachable"
+)
+
+// debounce is how long to wait for a burst of file changes to settle before
+// reading the config again.
+const debounce = 50 * time.Millisecond
+
+// Event describes a change.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Context the event applies to; the new active context for
+	// ActiveContextChanged
+	Context string `json:"context,omitempty"`
+	// Previous active context or namespace
+	Previous string `json:"previous,omitempty"`
+	// New namespace, for NamespaceChanged
+	Namespace string `json:"namespace,omitempty"`
+	// Why a cluster is unreachable, for ClusterUnreachable
+	Error string `json:"error,omitempty"`
+}
+
+// String formats the event for people to read.
+func (e Event) String() string {
+	switch e.Type {
+	case ActiveContextChanged:
+		return fmt.Sprintf("%s %q -> %q", e.Type, e.Previous, e.Context)
+	case NamespaceChanged:
+		return fmt.Sprintf("%s %s: %q -> %q", e.Type, e.Context, e.Previous, e.Namespace)
+	case ClusterUnreachable:
+		return fmt.Sprintf("%s %s: %s", e.Type, e.Context, e.Error)
+	default:
+		return fmt.Sprintf("%s %s", e.Type, e.Context)
+	}
+}
+
+// Options configure Watch.
+type Options struct {
+	// Config files to watch
+	Paths []string
+	// Load returns the current config, merged across files
+	Load func() (*config.Config, error)
+	// How often to check whether clusters are reachable; zero disables checks
+	HealthInterval time.Duration
+	// Health returns the statuses of contexts. It is required when
+	// HealthInterval is set.
+	Health func(ctx context.Context, contexts map[string]*config.ClusterConfig) daemon.Statuses
+}
+
+// Diff returns the events turning config old into config new.
+func Diff(old, new *config.Config) []Event {
+	now := time.Now()
+	var events []Event
+	for _, name := range sortedNames(old.Contexts) {
+		if new.Contexts[name] == nil {
+			events = append(events, Event{Type: ContextRemoved, Time: now, Context: name})
+		}
+	}
+	for _, name := range sortedNames(new.Contexts) {
+		if old.Contexts[name] == nil {
+			events = append(events, Event{Type: ContextAdded, Time: now, Context: name})
+		}
+	}
+	if old.ActiveContext != new.ActiveContext {
+		events = append(events, Event{Type: ActiveContextChanged, Time: now, Context: new.ActiveContext, Previous: old.ActiveContext})
+	}
+	for _, name := range sortedNames(new.Contexts) {
+		if prev := old.Contexts[name]; prev != nil && prev.Namespace != new.Contexts[name].Namespace {
+			events = append(events, Event{
+				Type:      NamespaceChanged,
+				Time:      now,
+				Context:   name,
+				Namespace: new.Contexts[name].Namespace,
+				Previous:  prev.Namespace,
+			})
+		}
+	}
+	return events
+}
+
+// Watch calls emit with events as they happen, until ctx is done or emit
+// returns an error. It calls ready once changes are being watched. Cluster
+// reachability is reported when it is first known, and whenever it changes.
+func Watch(ctx context.Context, opts Options, ready func(), emit func(Event) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Config files are replaced rather than written in place, so their
+	// directories are watched
+	files := map[string]bool{}
+	for i, path := range opts.Paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		files[path] = true
+		// Only the user config file is sure to exist
+		if err := watcher.Add(filepath.Dir(path)); err != nil && i == 0 {
+			return err
+		}
+	}
+	current, err := opts.Load()
+	if err != nil {
+		return err
+	}
+	ready()
+
+	var (
+		reload       <-chan time.Time
+		healthTicker <-chan time.Time
+		healthDone   chan daemon.Statuses
+		reachable    = map[string]bool{}
+	)
+	checkHealth := func() {
+		if healthDone != nil {
+			return
+		}
+		healthDone = make(chan daemon.Statuses, 1)
+		go func(done chan<- daemon.Statuses, contexts map[string]*config.ClusterConfig) {
+			done <- opts.Health(ctx, contexts)
+		}(healthDone, current.Contexts)
+	}
+	if opts.HealthInterval > 0 {
+		ticker := time.NewTicker(opts.HealthInterval)
+		defer ticker.Stop()
+		healthTicker = ticker.C
+		checkHealth()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if files[filepath.Clean(event.Name)] && reload == nil {
+				reload = time.After(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-reload:
+			reload = nil
+			next, err := opts.Load()
+			if err != nil {
+				// The file may be edited by hand; wait for it to be valid again
+				continue
+			}
+			for _, event := range Diff(current, next) {
+				if err := emit(event); err != nil {
+					return err
+				}
+			}
+			current = next
+		case <-healthTicker:
+			checkHealth()
+		case statuses := <-healthDone:
+			healthDone = nil
+			for _, name := range sortedNames(current.Contexts) {
+				status := statuses.Get(name, current.Contexts[name])
+				if status == nil {
+					continue
+				}
+				if was, known := reachable[name]; known && was == status.Healthy {
+					continue
+				}
+				reachable[name] = status.Healthy
+				event := Event{Type: ClusterReachable, Time: status.CheckedAt, Context: name}
+				if !status.Healthy {
+					event.Type, event.Error = ClusterUnreachable, status.Error
+				}
+				if err := emit(event); err != nil {
+					return err
+				}
+			}
+			for name := range reachable {
+				if current.Contexts[name] == nil {
+					delete(reachable, name)
+				}
+			}
+		}
+	}
+}
+
+func sortedNames(contexts map[string]*config.ClusterConfig) []string {
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
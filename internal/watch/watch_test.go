@@ -0,0 +1,115 @@
+package watch
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/daemon"
+)
+
+func TestDiff(t *testing.T) {
+	old := &config.Config{
+		ActiveContext: "dev",
+		Contexts: map[string]*config.ClusterConfig{
+			"dev":  {Namespace: "default"},
+			"prod": {Namespace: "orders"},
+			"old":  {Namespace: "default"},
+		},
+	}
+	new := &config.Config{
+		ActiveContext: "prod",
+		Contexts: map[string]*config.ClusterConfig{
+			"dev":  {Namespace: "default"},
+			"prod": {Namespace: "payments"},
+			"new":  {Namespace: "default"},
+		},
+	}
+	var actual []string
+	for _, e := range Diff(old, new) {
+		actual = append(actual, e.String())
+	}
+	expected := []string{
+		"context-removed old",
+		"context-added new",
+		`active-context-changed "dev" -> "prod"`,
+		`namespace-changed prod: "orders" -> "payments"`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected events %q, got %q", expected, actual)
+	}
+	if events := Diff(new, new); len(events) != 0 {
+		t.Errorf("expected no events, got %v", events)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	m, err := config.NewConfigManager(config.WithConfigFile(path), config.WithLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpsertContext("dev", &config.ClusterConfig{Address: "dev:7233", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan struct{})
+	events := make(chan Event, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, Options{
+			Paths:          []string{path},
+			Load:           m.GetAllContexts,
+			HealthInterval: time.Hour,
+			Health: func(_ context.Context, contexts map[string]*config.ClusterConfig) daemon.Statuses {
+				return daemon.Statuses{"dev": {Address: "dev:7233", Error: "connection refused"}}
+			},
+		}, func() { close(ready) }, func(e Event) error {
+			events <- e
+			return nil
+		})
+	}()
+	<-ready
+
+	expect := func(expected string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.String() != expected {
+				t.Errorf("expected event %q, got %q", expected, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %q", expected)
+		}
+	}
+	expect("cluster-unreachable dev: connection refused")
+
+	if err := m.SetActiveContext("dev", ""); err != nil {
+		t.Fatal(err)
+	}
+	expect(`active-context-changed "" -> "dev"`)
+	if err := m.SetCurrentNamespace("dev", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	expect(`namespace-changed dev: "default" -> "orders"`)
+	if err := m.DeleteContext("dev"); err != nil {
+		t.Fatal(err)
+	}
+	expect("context-removed dev")
+	expect(`active-context-changed "dev" -> ""`)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %q", e)
+	default:
+	}
+}
@@ -0,0 +1,93 @@
+// Package webui builds links to pages of the Temporal Web UI.
+package webui
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Namespace returns the URL of a namespace's landing page.
+func Namespace(webAddress, namespace string) (string, error) {
+	return build(webAddress, nil, "namespaces", namespace)
+}
+
+// Workflows returns the URL of a namespace's workflow list, optionally
+// filtered by a visibility query such as `ExecutionStatus="Running"`.
+func Workflows(webAddress, namespace, query string) (string, error) {
+	var params url.Values
+	if query != "" {
+		params = url.Values{"query": {query}}
+	}
+	return build(webAddress, params, "namespaces", namespace, "workflows")
+}
+
+// Workflow returns the URL of a workflow execution. When runID is empty the
+// Web UI shows the latest run.
+func Workflow(webAddress, namespace, workflowID, runID string) (string, error) {
+	if runID == "" {
+		return build(webAddress, nil, "namespaces", namespace, "workflows", workflowID)
+	}
+	return build(webAddress, nil, "namespaces", namespace, "workflows", workflowID, runID, "history")
+}
+
+// Schedule returns the URL of a schedule.
+func Schedule(webAddress, namespace, scheduleID string) (string, error) {
+	return build(webAddress, nil, "namespaces", namespace, "schedules", scheduleID)
+}
+
+// TaskQueue returns the URL of a task queue.
+func TaskQueue(webAddress, namespace, taskQueue string) (string, error) {
+	return build(webAddress, nil, "namespaces", namespace, "task-queues", taskQueue)
+}
+
+// build appends path segments to webAddress. Segments are escaped individually,
+// so that identifiers containing slashes or other reserved characters remain
+// a single path segment.
+func build(webAddress string, params url.Values, segments ...string) (string, error) {
+	u, err := url.Parse(webAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid web address %q: %w", webAddress, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid web address %q: must be an absolute URL such as https://temporal.example.com", webAddress)
+	}
+
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		if s == "" {
+			return "", fmt.Errorf("web UI link is missing a path segment after %q", strings.Join(segments[:i], "/"))
+		}
+		escaped[i] = url.PathEscape(s)
+	}
+
+	rawPath := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + strings.Join(escaped, "/")
+	if u.Path, err = url.PathUnescape(rawPath); err != nil {
+		return "", err
+	}
+	u.RawPath = rawPath
+	if params != nil {
+		u.RawQuery = params.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// Open launches the system browser at the given URL.
+func Open(link string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", link)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		cmd = exec.Command("xdg-open", link)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error opening browser: %w", err)
+	}
+	return cmd.Process.Release()
+}
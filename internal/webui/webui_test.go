@@ -0,0 +1,80 @@
+package webui
+
+import "testing"
+
+func TestURLs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		build    func() (string, error)
+		expected string
+	}{
+		{
+			name:     "namespace",
+			build:    func() (string, error) { return Namespace("https://temporal.example.com", "default") },
+			expected: "https://temporal.example.com/namespaces/default",
+		},
+		{
+			name:     "workflows",
+			build:    func() (string, error) { return Workflows("http://localhost:8080", "myapp", "") },
+			expected: "http://localhost:8080/namespaces/myapp/workflows",
+		},
+		{
+			name:     "workflows with trailing slash and base path",
+			build:    func() (string, error) { return Workflows("https://example.com/temporal/", "myapp", "") },
+			expected: "https://example.com/temporal/namespaces/myapp/workflows",
+		},
+		{
+			name:     "workflows query",
+			build:    func() (string, error) { return Workflows("https://example.com", "myapp", `ExecutionStatus="Running"`) },
+			expected: "https://example.com/namespaces/myapp/workflows?query=ExecutionStatus%3D%22Running%22",
+		},
+		{
+			name:     "workflow",
+			build:    func() (string, error) { return Workflow("https://example.com", "myapp", "order/123", "") },
+			expected: "https://example.com/namespaces/myapp/workflows/order%2F123",
+		},
+		{
+			name:     "workflow run",
+			build:    func() (string, error) { return Workflow("https://example.com", "myapp", "order 123", "abc-def") },
+			expected: "https://example.com/namespaces/myapp/workflows/order%20123/abc-def/history",
+		},
+		{
+			name:     "schedule",
+			build:    func() (string, error) { return Schedule("https://example.com", "myapp", "nightly") },
+			expected: "https://example.com/namespaces/myapp/schedules/nightly",
+		},
+		{
+			name:     "task queue",
+			build:    func() (string, error) { return TaskQueue("https://example.com", "myapp", "orders") },
+			expected: "https://example.com/namespaces/myapp/task-queues/orders",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := tc.build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestURLErrors(t *testing.T) {
+	for name, webAddress := range map[string]string{
+		"relative":  "temporal.example.com",
+		"no host":   "https://",
+		"malformed": "http://[::1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Workflows(webAddress, "default", ""); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := Workflows("https://example.com", "", ""); err == nil {
+		t.Error("expected error for empty namespace")
+	}
+}
@@ -3,21 +3,17 @@
 package xbar
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
-	"path"
 	"sort"
 	"strings"
-	"syscall"
-	"time"
 
+	"github.com/jlegrone/tctx/client"
 	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/webui"
 	"github.com/jlegrone/xbargo"
-	"golang.org/x/sys/unix"
 )
 
 func Render(ctx context.Context, opts *Options) error {
@@ -44,35 +40,27 @@ func Render(ctx context.Context, opts *Options) error {
 		WithStyle(xbargo.Style{MaxLength: 60}).
 		WithShortcut("o", xbargo.CommandKey)
 	if activeContext.WebAddress != "" {
-		activeContextStatus = activeContextStatus.WithHref(path.Join(activeContext.WebAddress, "namespaces", activeContext.Namespace))
+		if href, err := webui.Namespace(activeContext.WebAddress, activeContext.Namespace); err == nil {
+			activeContextStatus = activeContextStatus.WithHref(href)
+		}
 	}
 
-	// Get list of namespaces in active cluster
+	// Get list of namespaces in active cluster, preferably from the daemon's
+	// cache
 	var namespaces []string
 	if activeContext.Address != "" {
-		combinedOutput, err := execContext(ctx, opts.TctxPath, "exec", "--", opts.TctlPath,
-			"--context_timeout", "1",
-			"namespace",
-			"list",
-		)
+		var err error
+		if status := opts.Statuses.Get(opts.ActiveContext, activeContext); status != nil {
+			namespaces, err = status.Namespaces, status.Err()
+		} else {
+			namespaces, err = listNamespaces(ctx, activeContext)
+		}
 		if err != nil {
 			activeContextStatus.Icon = bytes.NewReader(statusUnavailable)
-			// Let the user know if we can't find a binary in PATH
-			if errMessage := combinedOutput.String(); strings.Contains(errMessage, "not found in $PATH") {
-				panic(errMessage)
-			}
 			// Print error for debugging
-			_, _ = fmt.Fprintln(os.Stderr, combinedOutput)
+			_, _ = fmt.Fprintln(os.Stderr, err)
 		} else {
 			activeContextStatus.Icon = bytes.NewReader(statusAvailable)
-			scanner := bufio.NewScanner(combinedOutput)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.HasPrefix(line, "Name: ") {
-					namespaces = append(namespaces, strings.TrimPrefix(line, "Name: "))
-				}
-			}
-			sort.Strings(namespaces)
 		}
 	} else {
 		activeContextStatus.Title = "No active context"
@@ -93,58 +81,95 @@ func Render(ctx context.Context, opts *Options) error {
 		if k == opts.ActiveContext {
 			prefix = "✓ "
 		}
-		clusterOptions = append(clusterOptions, xbargo.NewMenuItem(prefix+k).
+		item := xbargo.NewMenuItem(prefix+k).
 			WithShell(opts.TctxPath, "use", "-c", k).
 			WithShortcut(fmt.Sprintf("%d", i), xbargo.ControlKey).
-			WithRefresh(),
-		)
+			WithRefresh()
+		// Health of other clusters is only known from the daemon
+		if status := opts.Statuses.Get(k, opts.Contexts[k]); status != nil {
+			if status.Healthy {
+				item.Icon = bytes.NewReader(statusAvailable)
+			} else {
+				item.Icon = bytes.NewReader(statusUnavailable)
+			}
+		}
+		clusterOptions = append(clusterOptions, item)
+	}
+	if opts.GroupBy != "" {
+		clusterOptions = groupBy(opts.GroupBy, contextNames, clusterOptions, opts.Contexts)
 	}
 	plugin = plugin.WithElements(xbargo.NewMenuItem("Clusters").WithSubMenu(clusterOptions...))
 
+	// Favorite namespaces are listed first so that they can be selected even
+	// when the cluster is unreachable
 	var namespaceOptions []*xbargo.MenuItem
-	var hasActiveNamespace bool
-	for i, ns := range namespaces {
+	for i, ns := range opts.KnownNamespaces(opts.ActiveContext, namespaces...) {
 		prefix := "    "
 		if ns == activeContext.Namespace {
 			prefix = "✓ "
-			hasActiveNamespace = true
 		}
-		namespaceOptions = append(namespaceOptions, xbargo.NewMenuItem(prefix+ns).
-			WithShell(opts.TctxPath, "use", "-c", opts.ActiveContext, "--ns", ns).
+		item := xbargo.NewMenuItem(prefix+ns).
+			WithShell(opts.TctxPath, "ns", "use", "-c", opts.ActiveContext, ns).
 			WithShortcut(fmt.Sprintf("%d", i), xbargo.ShiftKey).
-			WithRefresh(),
-		)
-	}
-	if !hasActiveNamespace && activeContext.Namespace != "" {
-		// The namespace currently set in tctx doesn't exist in the cluster
-		namespaceOptions = append(namespaceOptions, xbargo.NewMenuItem("✓ "+activeContext.Namespace).
-			WithStyle(xbargo.Style{Color: "red"}))
-
+			WithRefresh()
+		if ns == activeContext.Namespace && namespaces != nil && !contains(namespaces, ns) {
+			// The namespace currently set in tctx doesn't exist in the cluster
+			item = item.WithStyle(xbargo.Style{Color: "red"})
+		}
+		namespaceOptions = append(namespaceOptions, item)
 	}
 	plugin = plugin.WithElements(xbargo.NewMenuItem("Namespaces").WithSubMenu(namespaceOptions...))
 
 	return plugin.RunW(os.Stdout)
 }
 
-func execContext(ctx context.Context, command string, args ...string) (*bytes.Buffer, error) {
-	cmd := exec.CommandContext(ctx, command, args...)
-
-	// tctx starts its own child process, so we need to create a new process
-	// group for cmd and manually send a kill signal to this process group id
-	// after timeout is reached.
-	//
-	// This doesn't work on Windows but that's fine because xbar is macOS only.
-	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
-	deadline, ok := ctx.Deadline()
-	if ok {
-		time.AfterFunc(deadline.Sub(time.Now()), func() {
-			unix.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-		})
+// groupBy nests the menu items for the named contexts in submenus titled with
+// the value of the given label. Contexts without the label are listed after
+// all groups.
+func groupBy(label string, names []string, items []*xbargo.MenuItem, contexts map[string]*config.ClusterConfig) []*xbargo.MenuItem {
+	groups := map[string][]*xbargo.MenuItem{}
+	var ungrouped []*xbargo.MenuItem
+	for i, name := range names {
+		value, ok := contexts[name].Labels[label]
+		if !ok {
+			ungrouped = append(ungrouped, items[i])
+			continue
+		}
+		groups[value] = append(groups[value], items[i])
 	}
 
-	b := bytes.NewBuffer(nil)
-	cmd.Stdout = b
-	cmd.Stderr = b
+	var values []string
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	var result []*xbargo.MenuItem
+	for _, value := range values {
+		result = append(result, xbargo.NewMenuItem(fmt.Sprintf("%s=%s", label, value)).WithSubMenu(groups[value]...))
+	}
+	return append(result, ungrouped...)
+}
 
-	return b, cmd.Run()
+func listNamespaces(ctx context.Context, cfg *config.ClusterConfig) ([]string, error) {
+	opts, err := client.NewOptions(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := opts.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return client.ListNamespaces(ctx, conn)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/daemon"
 )
 
 var (
@@ -19,6 +20,11 @@ var (
 		Usage:   "display Temporal namespace in menu bar",
 		EnvVars: []string{"SHOW_NAMESPACE"},
 	}
+	GroupByFlag = cli.StringFlag{
+		Name:    "group-by",
+		Usage:   "group clusters in the menu by the value of this context label",
+		EnvVars: []string{"GROUP_BY"},
+	}
 	//go:embed Temporal_Favicon.png
 	temporalIcon []byte
 	//go:embed Status_Available.png
@@ -29,6 +35,10 @@ var (
 
 type Options struct {
 	*config.Config
-	TctxPath, TctlPath         string
+	TctxPath                   string
 	ShowCluster, ShowNamespace bool
+	// Context label used to group clusters in the menu, if any
+	GroupBy string
+	// Cluster statuses cached by `tctx daemon`, or nil when it isn't running
+	Statuses daemon.Statuses
 }
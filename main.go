@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/url"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -16,9 +23,26 @@ import (
 
 	"github.com/jlegrone/tctx/config"
 
+	"github.com/jlegrone/tctx/internal/bundle"
+	"github.com/jlegrone/tctx/internal/certprovider"
+	"github.com/jlegrone/tctx/internal/daemon"
+	"github.com/jlegrone/tctx/internal/devca"
+	"github.com/jlegrone/tctx/internal/devserver"
+	"github.com/jlegrone/tctx/internal/headersprovider"
+	"github.com/jlegrone/tctx/internal/hooks"
+	"github.com/jlegrone/tctx/internal/project"
+	"github.com/jlegrone/tctx/internal/prompt"
+	"github.com/jlegrone/tctx/internal/proxy"
+	"github.com/jlegrone/tctx/internal/render"
+	"github.com/jlegrone/tctx/internal/share"
+	"github.com/jlegrone/tctx/internal/watch"
+	"github.com/jlegrone/tctx/internal/webui"
 	"github.com/jlegrone/tctx/internal/xbar"
 )
 
+// historyTimeFormat is used to show when config changes were made
+const historyTimeFormat = "2006-01-02 15:04:05"
+
 const (
 	configPathFlag                 = "config_path"
 	contextNameFlag                = "context"
@@ -30,9 +54,57 @@ const (
 	tlsCAFlag                      = "tls_ca_path"
 	tlsDisableHostVerificationFlag = "tls_disable_host_verification"
 	tlsServerNameFlag              = "tls_server_name"
+	tlsCertProviderFlag            = "tls_cert_provider"
+	tlsCertDataFlag                = "tls_cert_data"
+	tlsKeyDataFlag                 = "tls_key_data"
+	tlsCADataFlag                  = "tls_ca_data"
 	headersProviderPluginFlag      = "headers_provider_plugin"
 	dataConverterPluginFlag        = "data_converter_plugin"
 	envFlag                        = "env"
+	authTypeFlag                   = "auth_type"
+	authHeaderFlag                 = "auth_header"
+	authSecretRefFlag              = "auth_secret_ref"
+	authCommandFlag                = "auth_command"
+	listenFlag                     = "listen"
+	rewriteNamespaceFlag           = "rewrite_namespace"
+	workflowIDFlag                 = "workflow_id"
+	runIDFlag                      = "run_id"
+	scheduleIDFlag                 = "schedule_id"
+	taskQueueFlag                  = "task_queue"
+	queryFlag                      = "query"
+	printFlag                      = "print"
+	verboseFlag                    = "verbose"
+	revokeFlag                     = "revoke"
+	toFlag                         = "to"
+	contextsFlag                   = "contexts"
+	relativeToFlag                 = "relative_to"
+	includeSecretsFlag             = "include_secrets"
+	onConflictFlag                 = "on_conflict"
+	sourceFlag                     = "source"
+	nameFlag                       = "name"
+	fromTokenFlag                  = "from_token"
+	signerFlag                     = "signer"
+	yesFlag                        = "yes"
+	signFlag                       = "sign"
+	outputFlag                     = "output"
+	labelFlag                      = "label"
+	descriptionFlag                = "description"
+	intervalFlag                   = "interval"
+	healthIntervalFlag             = "health_interval"
+	selectorFlag                   = "selector"
+	discoverFlag                   = "discover"
+	hostsFlag                      = "hosts"
+	validForFlag                   = "valid_for"
+	rotateFlag                     = "rotate"
+	portFlag                       = "port"
+	uiPortFlag                     = "ui_port"
+	dbFlag                         = "db"
+	serverFlag                     = "server"
+	timeoutFlag                    = "timeout"
+	colorFlag                      = "color"
+	formatFlag                     = "format"
+	shellFlag                      = "shell"
+	forceFlag                      = "force"
 )
 
 func getContextFlag(required bool) *cli.StringFlag {
@@ -44,6 +116,13 @@ func getContextFlag(required bool) *cli.StringFlag {
 	}
 }
 
+func getForceFlag() *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:  forceFlag,
+		Usage: "save contexts even if their settings are invalid or break the policy file",
+	}
+}
+
 func getContextAndNamespaceFlags(required bool, defaultNamespace string) []cli.Flag {
 	return []cli.Flag{
 		getContextFlag(true),
@@ -91,6 +170,22 @@ func getAddOrUpdateFlags(required bool) []cli.Flag {
 			Name:  tlsServerNameFlag,
 			Usage: "override for target server name",
 		},
+		&cli.StringFlag{
+			Name:  tlsCertDataFlag,
+			Usage: "x509 certificate as PEM or base64 encoded PEM, in place of a path",
+		},
+		&cli.StringFlag{
+			Name:  tlsKeyDataFlag,
+			Usage: "private key as PEM or base64 encoded PEM, or a reference in the form of env:NAME or file:PATH",
+		},
+		&cli.StringFlag{
+			Name:  tlsCADataFlag,
+			Usage: "server CA certificate as PEM or base64 encoded PEM, in place of a path",
+		},
+		&cli.StringFlag{
+			Name:  tlsCertProviderFlag,
+			Usage: "command issuing a short-lived client certificate, in place of a certificate and key path",
+		},
 		&cli.StringFlag{
 			Name:    headersProviderPluginFlag,
 			Aliases: []string{"hpp"},
@@ -105,9 +200,49 @@ func getAddOrUpdateFlags(required bool) []cli.Flag {
 			Name:  envFlag,
 			Usage: "arbitrary environment variables to be set in this context, in the form of KEY=value",
 		},
+		&cli.StringSliceFlag{
+			Name:  labelFlag,
+			Usage: "labels used to select and group contexts, in the form of key=value (key- removes a label)",
+		},
+		&cli.StringFlag{
+			Name:  descriptionFlag,
+			Usage: "free-text description of the context",
+		},
+		&cli.StringFlag{
+			Name:  colorFlag,
+			Usage: "color of the context in shell prompts, such as red",
+		},
+		&cli.StringFlag{
+			Name:  authTypeFlag,
+			Usage: "authentication type: apikey, bearer-static or bearer-exec",
+		},
+		&cli.StringFlag{
+			Name:  authHeaderFlag,
+			Usage: "header carrying the API key or token (default: authorization)",
+		},
+		&cli.StringFlag{
+			Name:  authSecretRefFlag,
+			Usage: "reference to the API key or token, in the form of env:NAME or file:PATH",
+		},
+		&cli.StringFlag{
+			Name:  authCommandFlag,
+			Usage: "command printing a bearer token (auth type bearer-exec)",
+		},
+		getForceFlag(),
 	)
 }
 
+func getRenderFlags() []cli.Flag {
+	return []cli.Flag{
+		getContextFlag(false),
+		&cli.StringFlag{
+			Name:     nameFlag,
+			Usage:    "name of the worker deployment, used to name generated resources",
+			Required: true,
+		},
+	}
+}
+
 func main() {
 	userConfigFile, err := config.GetDefaultConfigPath()
 	if err != nil {
@@ -120,9 +255,70 @@ func main() {
 	}
 }
 
+// getConfigManager returns a ConfigManager for the config file selected by the
+// --config_path flag, which records the running command in the history.
+func getConfigManager(c *cli.Context) (*config.ConfigManager, error) {
+	// The outermost context with arguments holds the full command line
+	var args []string
+	for _, ctx := range c.Lineage() {
+		if ctx.Args().Present() {
+			args = ctx.Args().Slice()
+		}
+	}
+	return config.NewConfigManager(
+		config.WithConfigFile(c.String(configPathFlag)),
+		config.WithCommand(strings.Join(append([]string{"tctx"}, args...), " ")),
+		config.WithForce(c.Bool(forceFlag)),
+		config.WithWarningHandler(func(warning string) {
+			_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", warning)
+		}),
+	)
+}
+
+// forceHint suggests --force when a context is rejected by validation or
+// policy checks.
+func forceHint(err error) error {
+	var (
+		validationErr *config.ValidationError
+		policyErr     *config.PolicyError
+	)
+	if errors.As(err, &validationErr) || errors.As(err, &policyErr) {
+		return fmt.Errorf("%w (use --force to save it anyway)", err)
+	}
+	return err
+}
+
+// historyIDArg parses the ID of a history entry from the first argument.
+func historyIDArg(c *cli.Context, command string) (int, error) {
+	if c.Args().Len() != 1 {
+		return 0, cli.ShowCommandHelp(c, command)
+	}
+	id, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return 0, fmt.Errorf("invalid change ID %q: see `tctx config log`", c.Args().First())
+	}
+	return id, nil
+}
+
 func configFromFlags(c *cli.Context) (configPath string, contextName string, clusterConfig *config.ClusterConfig, err error) {
 	additionalEnvVars, err := parseAdditionalEnvVars(c.StringSlice(envFlag))
+	if err != nil {
+		return "", "", nil, err
+	}
+	labels, err := parseLabels(c.StringSlice(labelFlag))
+	if err != nil {
+		return "", "", nil, err
+	}
+	if c.IsSet(colorFlag) {
+		if err := prompt.CheckColor(c.String(colorFlag)); err != nil {
+			return "", "", nil, err
+		}
+	}
+	authConfig, err := authFromFlags(c)
 	return c.String(configPathFlag), c.String(contextNameFlag), &config.ClusterConfig{
+			Description:     c.String(descriptionFlag),
+			Labels:          labels,
+			Color:           c.String(colorFlag),
 			Address:         c.String(addressFlag),
 			WebAddress:      c.String(webAddressFlag),
 			Namespace:       c.String(namespaceFlag),
@@ -134,12 +330,35 @@ func configFromFlags(c *cli.Context) (configPath string, contextName string, clu
 				CACertPath:              c.String(tlsCAFlag),
 				DisableHostVerification: c.Bool(tlsDisableHostVerificationFlag),
 				ServerName:              c.String(tlsServerNameFlag),
+				CertData:                c.String(tlsCertDataFlag),
+				KeyData:                 c.String(tlsKeyDataFlag),
+				CAData:                  c.String(tlsCADataFlag),
+				CertProvider:            c.String(tlsCertProviderFlag),
 			},
+			Auth:        authConfig,
 			Environment: additionalEnvVars,
 		},
 		err
 }
 
+func authFromFlags(c *cli.Context) (*config.AuthConfig, error) {
+	if !c.IsSet(authTypeFlag) && !c.IsSet(authHeaderFlag) && !c.IsSet(authSecretRefFlag) && !c.IsSet(authCommandFlag) {
+		return nil, nil
+	}
+	// Secrets must not be stored in the config file
+	if ref := c.String(authSecretRefFlag); ref != "" {
+		if err := config.ValidateSecretRef(ref); err != nil {
+			return nil, err
+		}
+	}
+	return &config.AuthConfig{
+		Type:      c.String(authTypeFlag),
+		Header:    c.String(authHeaderFlag),
+		SecretRef: c.String(authSecretRefFlag),
+		Command:   c.String(authCommandFlag),
+	}, nil
+}
+
 func parseAdditionalEnvVars(input []string) (additional map[string]string, err error) {
 	envVars := make(map[string]string)
 	if input == nil {
@@ -156,18 +375,265 @@ func parseAdditionalEnvVars(input []string) (additional map[string]string, err e
 	return envVars, nil
 }
 
-func switchContexts(w io.Writer, t *config.ConfigManager, contextName, namespace string) error {
-	if err := t.SetActiveContext(contextName, namespace); err != nil {
+// commandEnvironment returns the environment for commands run against a
+// context. The returned function removes temporary files referenced by the
+// environment, and must be called once the command has exited.
+func commandEnvironment(ctx context.Context, resolved *resolvedContext) ([]string, func(), error) {
+	cfg, err := certprovider.Resolve(ctx, resolved.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, cleanup, err := writeInlineTLS(cfg.GetTLS())
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg = cfg.Clone()
+	cfg.TLS = &tlsConfig
+	env := os.Environ()
+	// Variables of the context come first, so that they can't override the
+	// settings managed by tctx
+	for k, v := range cfg.Environment {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	for k, v := range map[string]string{
+		"TEMPORAL_CLI_ADDRESS":   cfg.Address,
+		"TEMPORAL_CLI_NAMESPACE": cfg.Namespace,
+		"TEMPORAL_CLI_TLS_CERT":  cfg.GetTLS().CertPath,
+		"TEMPORAL_CLI_TLS_KEY":   cfg.GetTLS().KeyPath,
+		"TEMPORAL_CLI_TLS_CA":    cfg.GetTLS().CACertPath,
+		"TEMPORAL_CLI_TLS_DISABLE_HOST_VERIFICATION": fmt.Sprintf(
+			"%t", cfg.GetTLS().DisableHostVerification,
+		),
+		"TEMPORAL_CLI_TLS_SERVER_NAME":         cfg.GetTLS().ServerName,
+		"TEMPORAL_CLI_PLUGIN_HEADERS_PROVIDER": cfg.HeadersProvider,
+		"TEMPORAL_CLI_PLUGIN_DATA_CONVERTER":   cfg.DataConverter,
+	} {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	if cfg.Auth != nil {
+		authEnv, err := headersprovider.AuthEnvironment(ctx, *cfg.Auth)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		for k, v := range authEnv {
+			env = append(env, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	for k, v := range resolved.Environment {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env, cleanup, nil
+}
+
+// writeInlineTLS writes certificates and keys given inline to private
+// temporary files, since Temporal CLIs only accept file paths. The returned
+// function removes the files.
+func writeInlineTLS(tlsConfig config.TLSConfig) (config.TLSConfig, func(), error) {
+	if !tlsConfig.HasInlineData() {
+		return tlsConfig, func() {}, nil
+	}
+	// The directory is only accessible by the user
+	dir, err := os.MkdirTemp("", "tctx-tls-")
+	if err != nil {
+		return tlsConfig, nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	for _, f := range []struct {
+		name string
+		data string
+		pem  func() ([]byte, error)
+		path *string
+	}{
+		{"cert.pem", tlsConfig.CertData, tlsConfig.CertPEM, &tlsConfig.CertPath},
+		{"key.pem", tlsConfig.KeyData, tlsConfig.KeyPEM, &tlsConfig.KeyPath},
+		{"ca.pem", tlsConfig.CAData, tlsConfig.CAPEM, &tlsConfig.CACertPath},
+	} {
+		if f.data == "" {
+			continue
+		}
+		b, err := f.pem()
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, f.name), b, 0600)
+		}
+		if err != nil {
+			cleanup()
+			return tlsConfig, nil, err
+		}
+		*f.path = filepath.Join(dir, f.name)
+	}
+	return tlsConfig, cleanup, nil
+}
+
+// execSelected runs the command given to `tctx exec` once for each context
+// matching the --selector flag, in order of context name.
+func execSelected(c *cli.Context, t *config.ConfigManager) error {
+	if c.IsSet(contextNameFlag) {
+		return fmt.Errorf("--%s and --%s cannot be used together", contextNameFlag, selectorFlag)
+	}
+	selector, err := config.ParseSelector(c.String(selectorFlag))
+	if err != nil {
 		return err
 	}
+	contexts, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name, cfg := range contexts.Contexts {
+		if selector.Matches(cfg.Labels) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no contexts match selector %q", c.String(selectorFlag))
+	}
+	sort.Strings(names)
+
+	var failed []string
+	for _, name := range names {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "==> %s\n", name)
+		cmd := exec.Command(c.Args().First(), c.Args().Tail()...)
+		cmd.Stdin = c.App.Reader
+		cmd.Stdout = c.App.Writer
+		cmd.Stderr = c.App.ErrWriter
+		if err := runWithExecHooks(c, contexts, &resolvedContext{Name: name, Config: contexts.Contexts[name]}, cmd); err != nil {
+			_, _ = fmt.Fprintf(c.App.ErrWriter, "error: %s\n", err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("command failed for contexts: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// renderWorkload returns an action printing the deployment configuration of
+// the selected context in the format written by fn.
+func renderWorkload(fn func(io.Writer, *render.Workload) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		t, err := getConfigManager(c)
+		if err != nil {
+			return err
+		}
+		resolved, err := resolveContext(c, t)
+		if err != nil {
+			return err
+		}
+		workload, err := render.NewWorkload(c.String(nameFlag), resolved.Config)
+		if err != nil {
+			return err
+		}
+		return fn(c.App.Writer, workload)
+	}
+}
+
+// checkDirName returns an error when a context name cannot be used to name
+// files in a directory of its own.
+func checkDirName(name string) error {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("context name %q cannot be used as a directory name", name)
+	}
+	return nil
+}
+
+// parseLabels parses labels of the form key=value. Labels of the form key-
+// are returned with an empty value, which removes them from existing contexts.
+func parseLabels(input []string) (map[string]string, error) {
+	if input == nil {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, kv := range input {
+		if key := strings.TrimSuffix(kv, "-"); key != kv && !strings.Contains(kv, "=") {
+			labels[key] = ""
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("unable to parse label %q: enter labels in the form --%s key=value", kv, labelFlag)
+		}
+		if err := config.ValidateLabel(key, value); err != nil {
+			return nil, err
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func webURLFromFlags(c *cli.Context, contextName string, cfg *config.ClusterConfig) (string, error) {
+	if cfg.WebAddress == "" {
+		return "", fmt.Errorf("context %q has no web address: set one with `tctx update -c %s --web_address <url>`", contextName, contextName)
+	}
+
+	namespace := c.String(namespaceFlag)
+	if namespace == "" {
+		namespace = cfg.Namespace
+	}
+
+	var targets []string
+	for _, flag := range []string{workflowIDFlag, scheduleIDFlag, taskQueueFlag, queryFlag} {
+		if c.String(flag) != "" {
+			targets = append(targets, "--"+flag)
+		}
+	}
+	if len(targets) > 1 {
+		return "", fmt.Errorf("flags %s cannot be used together", strings.Join(targets, ", "))
+	}
+	if c.String(runIDFlag) != "" && c.String(workflowIDFlag) == "" {
+		return "", fmt.Errorf("--%s requires --%s", runIDFlag, workflowIDFlag)
+	}
+
+	switch {
+	case c.String(workflowIDFlag) != "":
+		return webui.Workflow(cfg.WebAddress, namespace, c.String(workflowIDFlag), c.String(runIDFlag))
+	case c.String(scheduleIDFlag) != "":
+		return webui.Schedule(cfg.WebAddress, namespace, c.String(scheduleIDFlag))
+	case c.String(taskQueueFlag) != "":
+		return webui.TaskQueue(cfg.WebAddress, namespace, c.String(taskQueueFlag))
+	default:
+		return webui.Workflows(cfg.WebAddress, namespace, c.String(queryFlag))
+	}
+}
 
+func switchContexts(c *cli.Context, t *config.ConfigManager, contextName, namespace string) error {
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
 	cfg, err := t.GetContext(contextName)
 	if err != nil {
 		return err
 	}
 
-	_, err = fmt.Fprintf(w, "Context %q modified.\nActive namespace is %q.\n", contextName, cfg.Namespace)
-	return err
+	inv := hooks.Invocation{Event: hooks.PreUse, New: hookContext(contextName, cfg)}
+	if all.ActiveContext != "" {
+		inv.Old = hookContext(all.ActiveContext, all.Contexts[all.ActiveContext])
+	}
+	if namespace != "" {
+		inv.New.Namespace = namespace
+	}
+	if err := runHooks(c, all, inv); err != nil {
+		return err
+	}
+
+	if err := t.SetActiveContext(contextName, namespace); err != nil {
+		return err
+	}
+
+	if cfg, err = t.GetContext(contextName); err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(c.App.Writer, "Context %q modified.\nActive namespace is %q.\n", contextName, cfg.Namespace); err != nil {
+		return err
+	}
+
+	inv.Event = hooks.PostUse
+	inv.New.Namespace = cfg.Namespace
+	runPostHooks(c, all, inv)
+	return nil
 }
 
 func newApp(configFile string) *cli.App {
@@ -182,20 +648,53 @@ func newApp(configFile string) *cli.App {
 				// require flag when default path could not be computed
 				Required: configFile == "",
 				Value:    configFile,
+				EnvVars:  []string{configPathEnvVar},
 			},
 		},
+		// Unknown commands run plugins
+		Action: func(c *cli.Context) error {
+			if c.Args().Present() {
+				return runPlugin(c)
+			}
+			return cli.ShowAppHelp(c)
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "add",
 				Usage: "add a new context",
-				Flags: getAddOrUpdateFlags(true),
+				Flags: append(getAddOrUpdateFlags(false),
+					&cli.StringFlag{
+						Name:  fromTokenFlag,
+						Usage: "add the context shared by `tctx share`; other flags override the shared settings",
+					},
+					&cli.StringFlag{
+						Name:  signerFlag,
+						Usage: "require the token to be signed by the key with this fingerprint",
+					},
+					&cli.BoolFlag{
+						Name:    yesFlag,
+						Aliases: []string{"y"},
+						Usage:   "add the context from a token without asking for confirmation",
+					},
+				),
 				Action: func(c *cli.Context) error {
-					path, name, cfg, err := configFromFlags(c)
+					_, name, cfg, err := configFromFlags(c)
 					if err != nil {
 						return err
 					}
+					if c.IsSet(fromTokenFlag) {
+						if cfg, err = configFromToken(c, name); err != nil {
+							return err
+						}
+						if cfg == nil {
+							_, err = fmt.Fprintf(c.App.Writer, "Context %q not added.\n", name)
+							return err
+						}
+					} else if cfg.Address == "" {
+						return fmt.Errorf("Required flag %q not set", addressFlag)
+					}
 
-					t, err := config.NewConfigManager(config.WithConfigFile(path))
+					t, err := getConfigManager(c)
 					if err != nil {
 						return err
 					}
@@ -207,10 +706,15 @@ func newApp(configFile string) *cli.App {
 					}
 
 					if err := t.UpsertContext(name, cfg); err != nil {
+						return forceHint(err)
+					}
+					all, err := t.GetAllContexts()
+					if err != nil {
 						return err
 					}
+					runPostHooks(c, all, hooks.Invocation{Event: hooks.PostAdd, New: hookContext(name, all.Contexts[name])})
 
-					return switchContexts(c.App.Writer, t, name, cfg.Namespace)
+					return switchContexts(c, t, name, cfg.Namespace)
 				},
 			},
 			{
@@ -218,12 +722,12 @@ func newApp(configFile string) *cli.App {
 				Usage: "update an existing context",
 				Flags: getAddOrUpdateFlags(false),
 				Action: func(c *cli.Context) error {
-					path, name, newCfg, err := configFromFlags(c)
+					_, name, newCfg, err := configFromFlags(c)
 					if err != nil {
 						return err
 					}
 
-					t, err := config.NewConfigManager(config.WithConfigFile(path))
+					t, err := getConfigManager(c)
 					if err != nil {
 						return err
 					}
@@ -234,10 +738,10 @@ func newApp(configFile string) *cli.App {
 					}
 
 					if err := t.UpsertContext(name, newCfg); err != nil {
-						return err
+						return forceHint(err)
 					}
 
-					return switchContexts(c.App.Writer, t, name, newCfg.Namespace)
+					return switchContexts(c, t, name, newCfg.Namespace)
 				},
 			},
 			{
@@ -248,7 +752,13 @@ func newApp(configFile string) *cli.App {
 					getContextFlag(true),
 				},
 				Action: func(c *cli.Context) error {
-					t, err := config.NewConfigManager(config.WithConfigFile(c.String(configPathFlag)))
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+
+					// Hooks of the deleted context are read before it is gone
+					all, err := t.GetAllContexts()
 					if err != nil {
 						return err
 					}
@@ -260,6 +770,7 @@ func newApp(configFile string) *cli.App {
 
 					_, _ = fmt.Fprintf(c.App.Writer, "Context %q deleted.\n", contextName)
 
+					runPostHooks(c, all, hooks.Invocation{Event: hooks.PostDelete, Old: hookContext(contextName, all.Contexts[contextName])})
 					return nil
 				},
 			},
@@ -267,8 +778,29 @@ func newApp(configFile string) *cli.App {
 				Name:    "list",
 				Aliases: []string{"ls"},
 				Usage:   "list contexts",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    outputFlag,
+						Aliases: []string{"o"},
+						Usage:   "output format: wide, name, json, yaml or template=<go template>",
+					},
+					&cli.StringFlag{
+						Name:    selectorFlag,
+						Aliases: []string{"l"},
+						Usage:   "only list contexts with matching labels, such as env=prod,region!=eu",
+					},
+				},
 				Action: func(c *cli.Context) error {
-					t, err := config.NewConfigManager(config.WithConfigFile(c.String(configPathFlag)))
+					format, err := parseOutputFormat(c.String(outputFlag),
+						outputTable, outputWide, outputName, outputJSON, outputYAML, outputTemplate)
+					if err != nil {
+						return err
+					}
+					selector, err := config.ParseSelector(c.String(selectorFlag))
+					if err != nil {
+						return err
+					}
+					t, err := getConfigManager(c)
 					if err != nil {
 						return err
 					}
@@ -276,29 +808,88 @@ func newApp(configFile string) *cli.App {
 					if err != nil {
 						return err
 					}
+					// Highlight the context selected by the session or a project
+					// file, falling back to the active context
+					activeContext := contexts.ActiveContext
+					resolved, resolveErr := resolveContext(c, t)
+					if resolveErr == nil {
+						activeContext = resolved.Name
+					}
+					outputs := newContextOutputs(contexts, t.GetLayers()[0], activeContext, selector)
+					// Health is only shown when the daemon has it at hand
+					statuses := daemonStatuses(c)
+					for _, o := range outputs {
+						if status := statuses.Get(o.Name, contexts.Contexts[o.Name]); status != nil {
+							o.Health = &healthOutput{Healthy: status.Healthy, Error: status.Error, CheckedAt: status.CheckedAt}
+						}
+					}
+
+					switch format.kind {
+					case outputTable, outputWide:
+					case outputName:
+						for _, o := range outputs {
+							if _, err := fmt.Fprintln(c.App.Writer, o.Name); err != nil {
+								return err
+							}
+						}
+						return nil
+					default:
+						return format.write(c.App.Writer, outputs)
+					}
+					wide := format.kind == outputWide
 
-					var names []string
-					for k := range contexts.Contexts {
-						names = append(names, k)
+					// Only show where contexts come from when some are shared
+					// through read-only config layers
+					var showSource bool
+					for _, o := range outputs {
+						showSource = showSource || o.Source != "user"
 					}
-					sort.Strings(names)
 
 					w := tabwriter.NewWriter(c.App.Writer, 1, 1, 4, ' ', 0)
-					if _, err := fmt.Fprintln(w, "NAME\tADDRESS\tNAMESPACE\tWEB\tSTATUS\t"); err != nil {
+					header := "NAME\tADDRESS\tNAMESPACE\tWEB\t"
+					if showSource {
+						header += "SOURCE\t"
+					}
+					if wide {
+						header += "LABELS\tTLS\tAUTH\tHEADERS PROVIDER\tDATA CONVERTER\tENV\t"
+					}
+					if statuses != nil {
+						header += "HEALTH\t"
+					}
+					if _, err := fmt.Fprintln(w, header+"STATUS\t"); err != nil {
 						return err
 					}
 
-					for _, k := range names {
-						v := contexts.Contexts[k]
+					for _, o := range outputs {
 						webAddr := ""
-						if v.WebAddress != "" {
-							webAddr, err = url.JoinPath(v.WebAddress, "namespaces", v.Namespace, "workflows")
-							if err != nil {
-								return err
+						if o.WebAddress != "" {
+							// Show addresses which aren't URLs as they are, rather
+							// than failing to list every context
+							if webAddr, err = webui.Workflows(o.WebAddress, o.Namespace, ""); err != nil {
+								webAddr = o.WebAddress
+							}
+						}
+						row := fmt.Sprintf("%s\t%s\t%s\t%s\t", o.Name, o.Address, o.Namespace, webAddr)
+						if showSource {
+							row += o.Source + "\t"
+						}
+						if wide {
+							var auth string
+							if o.Auth != nil {
+								auth = o.Auth.Type
 							}
+							var env []string
+							for k := range o.Environment {
+								env = append(env, k)
+							}
+							sort.Strings(env)
+							row += fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t",
+								config.FormatLabels(o.Labels), o.tlsSummary(), auth, o.HeadersProvider, o.DataConverter, strings.Join(env, ","))
+						}
+						if statuses != nil {
+							row += healthLabel(statuses.Get(o.Name, contexts.Contexts[o.Name])) + "\t"
 						}
-						row := fmt.Sprintf("%s\t%s\t%s\t%s\t", k, v.Address, v.Namespace, webAddr)
-						if contexts.ActiveContext == k {
+						if o.Active {
 							row += "active\t"
 						}
 						if _, err := fmt.Fprintln(w, row); err != nil {
@@ -306,123 +897,1138 @@ func newApp(configFile string) *cli.App {
 						}
 					}
 
-					return w.Flush()
-				},
-			},
-			{
-				Name:    "use",
-				Aliases: []string{"u"},
-				Usage:   "switch cluster contexts",
-				Flags:   getContextAndNamespaceFlags(false, ""),
-				Action: func(c *cli.Context) error {
-					var (
-						configPath  = c.String(configPathFlag)
-						contextName = c.String(contextNameFlag)
-						namespace   = c.String(namespaceFlag)
-					)
-
-					t, err := config.NewConfigManager(config.WithConfigFile(configPath))
-					if err != nil {
+					if err := w.Flush(); err != nil {
 						return err
 					}
-
-					return switchContexts(c.App.Writer, t, contextName, namespace)
+					if resolved != nil && resolved.Source != activeContextSource {
+						_, err = fmt.Fprintf(c.App.Writer, "\nContext %q selected by %s.\n", resolved.Name, resolved.Source)
+						return err
+					}
+					return nil
 				},
 			},
 			{
-				Name:   "tctxbar",
-				Hidden: true,
+				Name:  "show",
+				Usage: "print all settings of a context, with secrets masked",
 				Flags: []cli.Flag{
-					&xbar.ShowClusterFlag,
-					&xbar.ShowNamespaceFlag,
+					getContextFlag(false),
+					&cli.StringFlag{
+						Name:    outputFlag,
+						Aliases: []string{"o"},
+						Usage:   "output format: yaml, json or template=<go template>",
+						Value:   outputYAML,
+					},
 				},
 				Action: func(c *cli.Context) error {
-					executablePath, err := os.Executable()
+					format, err := parseOutputFormat(c.String(outputFlag), outputJSON, outputYAML, outputTemplate)
 					if err != nil {
 						return err
 					}
-
-					t, err := config.NewConfigManager(config.WithConfigFile(c.String(configPathFlag)))
+					t, err := getConfigManager(c)
 					if err != nil {
 						return err
 					}
-					cfg, err := t.GetAllContexts()
+					contexts, err := t.GetAllContexts()
 					if err != nil {
 						return err
 					}
-
-					// Define a timeout to avoid blocking menu rendering on querying
-					// Temporal cluster state.
-					ctx, cancel := context.WithTimeout(c.Context, time.Second)
-					defer cancel()
-
-					return xbar.Render(ctx, &xbar.Options{
-						Config:        cfg,
-						TctxPath:      executablePath,
-						TctlPath:      "tctl",
-						ShowCluster:   c.Bool(xbar.ShowClusterFlag.Name),
-						ShowNamespace: c.Bool(xbar.ShowNamespaceFlag.Name),
-					})
-				},
-			},
-			{
-				Name:      "exec",
-				Aliases:   []string{},
-				ArgsUsage: "-- <command> [args]",
-				Usage:     "execute a command with temporal environment variables set",
-				Flags: []cli.Flag{
-					getContextFlag(false),
-				},
-				Action: func(c *cli.Context) error {
-					if c.Args().Len() == 0 {
-						return cli.ShowCommandHelp(c, "exec")
-					}
-
-					t, err := config.NewConfigManager(config.WithConfigFile(c.String(configPathFlag)))
+					resolved, err := resolveContext(c, t)
 					if err != nil {
 						return err
 					}
 
-					contextName := c.String(contextNameFlag)
-					if contextName == "" {
-						contextName, err = t.GetActiveContextName()
-						if err != nil {
-							return err
+					// Contexts selected by --context are only active if they
+					// are also the active context
+					active := resolved.Name
+					if c.IsSet(contextNameFlag) {
+						active = contexts.ActiveContext
+					}
+					for _, o := range newContextOutputs(contexts, t.GetLayers()[0], active, nil) {
+						if o.Name == resolved.Name {
+							return format.write(c.App.Writer, o)
 						}
 					}
+					return fmt.Errorf("context %q does not exist", resolved.Name)
+				},
+			},
+			{
+				Name:    "use",
+				Aliases: []string{"u"},
+				Usage:   "switch cluster contexts",
+				Flags:   getContextAndNamespaceFlags(false, ""),
+				Action: func(c *cli.Context) error {
+					var (
+						contextName = c.String(contextNameFlag)
+						namespace   = c.String(namespaceFlag)
+					)
 
-					cfg, err := t.GetContext(contextName)
+					t, err := getConfigManager(c)
 					if err != nil {
 						return err
 					}
 
-					env := os.Environ()
-					for k, v := range map[string]string{
-						"TEMPORAL_CLI_ADDRESS":   cfg.Address,
-						"TEMPORAL_CLI_NAMESPACE": cfg.Namespace,
-						"TEMPORAL_CLI_TLS_CERT":  cfg.GetTLS().CertPath,
-						"TEMPORAL_CLI_TLS_KEY":   cfg.GetTLS().KeyPath,
-						"TEMPORAL_CLI_TLS_CA":    cfg.GetTLS().CACertPath,
-						"TEMPORAL_CLI_TLS_DISABLE_HOST_VERIFICATION": fmt.Sprintf(
-							"%t", cfg.GetTLS().DisableHostVerification,
-						),
-						"TEMPORAL_CLI_TLS_SERVER_NAME":         cfg.GetTLS().ServerName,
-						"TEMPORAL_CLI_PLUGIN_HEADERS_PROVIDER": cfg.HeadersProvider,
-						"TEMPORAL_CLI_PLUGIN_DATA_CONVERTER":   cfg.DataConverter,
-					} {
-						env = append(env, fmt.Sprintf("%s=%s", k, v))
-					}
-					for k, v := range cfg.Environment {
-						env = append(env, fmt.Sprintf("%s=%s", k, v))
-					}
+					return switchContexts(c, t, contextName, namespace)
+				},
+			},
+			{
+				Name:    "ns",
+				Aliases: []string{"namespace"},
+				Usage:   "manage the namespaces used with a context",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list favorite and current namespaces, favorites first",
+						Flags: []cli.Flag{
+							getContextFlag(false),
+							&cli.BoolFlag{
+								Name:  discoverFlag,
+								Usage: "include namespaces registered in the cluster",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							resolved, err := resolveContext(c, t)
+							if err != nil {
+								return err
+							}
+							all, err := t.GetAllContexts()
+							if err != nil {
+								return err
+							}
+
+							var discovered []string
+							if c.Bool(discoverFlag) {
+								if discovered, err = discoverNamespaces(c, resolved.Name, resolved.Config); err != nil {
+									_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: could not list namespaces in the cluster: %s\n", err)
+								}
+							}
+							favorites := all.GetFavoriteNamespaces(resolved.Name)
+
+							w := tabwriter.NewWriter(c.App.Writer, 1, 1, 4, ' ', 0)
+							if _, err := fmt.Fprintln(w, "NAMESPACE\tFAVORITE\tSTATUS\t"); err != nil {
+								return err
+							}
+							for _, ns := range all.KnownNamespaces(resolved.Name, discovered...) {
+								var favorite, status string
+								for _, f := range favorites {
+									if f == ns {
+										favorite = "yes"
+									}
+								}
+								if ns == resolved.Config.Namespace {
+									status = "active"
+								}
+								if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t\n", ns, favorite, status); err != nil {
+									return err
+								}
+							}
+							return w.Flush()
+						},
+					},
+					{
+						Name:      "add",
+						ArgsUsage: "<namespace>...",
+						Usage:     "add favorite namespaces",
+						Flags:     []cli.Flag{getContextFlag(false)},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() == 0 {
+								return cli.ShowCommandHelp(c, "add")
+							}
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							resolved, err := resolveContext(c, t)
+							if err != nil {
+								return err
+							}
+							if err := t.AddFavoriteNamespaces(resolved.Name, c.Args().Slice()...); err != nil {
+								return err
+							}
+							_, err = fmt.Fprintf(c.App.Writer, "Added favorite namespaces of context %q: %s.\n",
+								resolved.Name, strings.Join(c.Args().Slice(), ", "))
+							return err
+						},
+					},
+					{
+						Name:         "remove",
+						Aliases:      []string{"rm"},
+						ArgsUsage:    "<namespace>...",
+						Usage:        "remove favorite namespaces",
+						Flags:        []cli.Flag{getContextFlag(false)},
+						BashComplete: completeNamespaces,
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() == 0 {
+								return cli.ShowCommandHelp(c, "remove")
+							}
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							resolved, err := resolveContext(c, t)
+							if err != nil {
+								return err
+							}
+							if err := t.RemoveFavoriteNamespaces(resolved.Name, c.Args().Slice()...); err != nil {
+								return err
+							}
+							_, err = fmt.Fprintf(c.App.Writer, "Removed favorite namespaces of context %q: %s.\n",
+								resolved.Name, strings.Join(c.Args().Slice(), ", "))
+							return err
+						},
+					},
+					{
+						Name:         "use",
+						ArgsUsage:    "<namespace>",
+						Usage:        "switch the namespace used with a context, without changing the active context",
+						Flags:        []cli.Flag{getContextFlag(false)},
+						BashComplete: completeNamespaces,
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() != 1 {
+								return cli.ShowCommandHelp(c, "use")
+							}
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							resolved, err := resolveContext(c, t)
+							if err != nil {
+								return err
+							}
+							if err := t.SetCurrentNamespace(resolved.Name, c.Args().First()); err != nil {
+								return err
+							}
+							_, err = fmt.Fprintf(c.App.Writer, "Context %q modified.\nActive namespace is %q.\n", resolved.Name, c.Args().First())
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "tls",
+				Usage: "manage TLS certificates",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "gen",
+						Usage: "generate a CA, server and client certificates for a local mTLS cluster, and use them in a context",
+						Flags: []cli.Flag{
+							getContextFlag(true),
+							&cli.StringSliceFlag{
+								Name:  hostsFlag,
+								Usage: "DNS names and IP addresses of the server",
+								Value: cli.NewStringSlice("localhost", "127.0.0.1"),
+							},
+							&cli.StringFlag{
+								Name:    addressFlag,
+								Aliases: []string{"ad"},
+								Usage:   "host:port for Temporal frontend service, when creating the context",
+								Value:   "localhost:7233",
+							},
+							&cli.DurationFlag{
+								Name:  validForFlag,
+								Usage: "how long the certificates are valid for",
+								Value: devca.DefaultValidity,
+							},
+							&cli.BoolFlag{
+								Name:  rotateFlag,
+								Usage: "replace existing certificates, including the CA",
+							},
+							getForceFlag(),
+						},
+						Action: func(c *cli.Context) error {
+							name := c.String(contextNameFlag)
+							if err := checkDirName(name); err != nil {
+								return err
+							}
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+
+							dir := filepath.Join(filepath.Dir(c.String(configPathFlag)), "tls", name)
+							files := devca.Paths(dir)
+							action := "updated"
+							// The certificates are generated in a staging directory, and
+							// only replace the existing ones once the context is checked
+							if err := t.Update(func(all *config.Config) error {
+								definitions, err := t.GetDefinitions()
+								if err != nil {
+									return err
+								}
+								if _, ok := definitions.Contexts[name]; ok && all.Contexts[name] == nil {
+									return fmt.Errorf("context %q is defined in read-only config file %s", name, definitions.Sources[name])
+								}
+								if _, err := os.Stat(files.CACert); err == nil && !c.Bool(rotateFlag) {
+									return fmt.Errorf("%w in %s: use --%s to replace them", devca.ErrExists, dir, rotateFlag)
+								}
+
+								if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+									return err
+								}
+								staging, err := os.MkdirTemp(filepath.Dir(dir), name+".*")
+								if err != nil {
+									return err
+								}
+								defer os.RemoveAll(staging)
+								staged, err := devca.Generate(staging, devca.Options{
+									Hosts:    c.StringSlice(hostsFlag),
+									Validity: c.Duration(validForFlag),
+									Rotate:   true,
+								})
+								if err != nil {
+									return err
+								}
+
+								previous := all.Contexts[name]
+								cfg := &config.ClusterConfig{Address: c.String(addressFlag), Namespace: "default"}
+								if previous != nil {
+									cfg = previous.Clone()
+								} else {
+									action = "added"
+								}
+								// Inline data and certificate providers would take precedence
+								// over the generated files
+								if cfg.TLS == nil {
+									cfg.TLS = &config.TLSConfig{}
+								}
+								tls := cfg.TLS
+								tls.CertPath, tls.KeyPath, tls.CACertPath = staged.ClientCert, staged.ClientKey, staged.CACert
+								tls.CertData, tls.KeyData, tls.CAData, tls.CertProvider = "", "", "", ""
+								if err := t.CheckUpdate(name, previous, cfg); err != nil {
+									return err
+								}
+
+								if err := devca.Install(staging, dir); err != nil {
+									return err
+								}
+								tls.CertPath, tls.KeyPath, tls.CACertPath = files.ClientCert, files.ClientKey, files.CACert
+								files.NotAfter = staged.NotAfter
+								all.Contexts[name] = cfg
+								return nil
+							}); err != nil {
+								return forceHint(err)
+							}
+
+							_, err = fmt.Fprintf(c.App.Writer, `Generated certificates valid until %s in %s.
+Context %q %s to use the client certificate.
+
+Configure the Temporal server with:
+  CA certificate:     %s
+  Server certificate: %s
+  Server key:         %s
+
+For example, in the server config file:
+  global:
+    tls:
+      frontend:
+        server:
+          certFile: %s
+          keyFile: %s
+          requireClientAuth: true
+          clientCaFiles:
+            - %s
+`,
+								files.NotAfter.Format(historyTimeFormat), dir, name, action,
+								files.CACert, files.ServerCert, files.ServerKey,
+								files.ServerCert, files.ServerKey, files.CACert)
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "render",
+				Usage: "generate deployment configuration for workers connecting to a context",
+				Subcommands: []*cli.Command{
+					{
+						Name:    "k8s",
+						Aliases: []string{"kubernetes"},
+						Usage:   "print a Kubernetes ConfigMap, Secret and pod spec snippet",
+						Flags:   getRenderFlags(),
+						Action:  renderWorkload(render.Kubernetes),
+					},
+					{
+						Name:   "helm",
+						Usage:  "print Helm chart values",
+						Flags:  getRenderFlags(),
+						Action: renderWorkload(render.Helm),
+					},
+					{
+						Name:   "compose",
+						Usage:  "print the environment of a Docker Compose service",
+						Flags:  getRenderFlags(),
+						Action: renderWorkload(render.Compose),
+					},
+				},
+			},
+			{
+				Name:  "daemon",
+				Usage: "poll the health and namespaces of all contexts in the background, for fast menus, completions and listings",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  intervalFlag,
+						Usage: "how often to poll contexts",
+						Value: daemon.DefaultInterval,
+					},
+				},
+				Action: runDaemon,
+				Subcommands: []*cli.Command{
+					{
+						Name:  "status",
+						Usage: "print the health and namespaces of contexts cached by the daemon",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    outputFlag,
+								Aliases: []string{"o"},
+								Usage:   "output format: json or yaml",
+							},
+						},
+						Action: daemonStatus,
+					},
+				},
+			},
+			{
+				Name:  "watch",
+				Usage: "print config changes, and optionally changes in cluster reachability, as they happen",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  formatFlag,
+						Usage: "event format: text, or json for one JSON object per line",
+						Value: "text",
+					},
+					&cli.DurationFlag{
+						Name:  healthIntervalFlag,
+						Usage: "check whether clusters are reachable at this interval, such as 30s (default: no checks)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					format := c.String(formatFlag)
+					if format != "text" && format != "json" {
+						return fmt.Errorf("unsupported event format %q: must be one of text, json", format)
+					}
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+					paths := t.GetLayers()
+
+					ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					return watch.Watch(ctx, watch.Options{
+						Paths:          paths,
+						Load:           t.GetAllContexts,
+						HealthInterval: c.Duration(healthIntervalFlag),
+						Health:         contextStatuses(c),
+					}, func() {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "Watching %s for changes.\n", paths[0])
+					}, func(e watch.Event) error {
+						if format == "text" {
+							_, err := fmt.Fprintln(c.App.Writer, e)
+							return err
+						}
+						b, err := json.Marshal(e)
+						if err != nil {
+							return err
+						}
+						_, err = fmt.Fprintf(c.App.Writer, "%s\n", b)
+						return err
+					})
+				},
+			},
+			{
+				Name:  "prompt",
+				Usage: "print the selected context for shell prompts, without connecting to the cluster",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        formatFlag,
+						Usage:       "Go template with fields .Context, .Namespace, .Address and .Labels",
+						DefaultText: prompt.DefaultFormat,
+					},
+					&cli.StringFlag{
+						Name:  shellFlag,
+						Usage: "escape colors for a shell: " + strings.Join(prompt.Shells, ", "),
+						Value: prompt.ShellANSI,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					// Prompts run often and must not wait for other commands
+					t, err := config.NewConfigManager(config.WithConfigFile(c.String(configPathFlag)), config.WithReadOnly())
+					if err != nil {
+						return err
+					}
+					// Prompts are left empty outside of any context
+					resolved, err := resolveContext(c, t)
+					if err != nil {
+						return nil
+					}
+					all, err := t.GetAllContexts()
+					if err != nil {
+						return err
+					}
+					settings := all.Prompt
+					if settings == nil {
+						settings = &config.PromptConfig{}
+					}
+					format := settings.Format
+					if c.IsSet(formatFlag) {
+						format = c.String(formatFlag)
+					}
+					color, err := prompt.Color(resolved.Name, resolved.Config, settings.Colors)
+					if err != nil {
+						return err
+					}
+					data := prompt.Data{
+						Context:   resolved.Name,
+						Namespace: resolved.Config.Namespace,
+						Address:   resolved.Config.Address,
+						Labels:    resolved.Config.Labels,
+					}
+					if err := prompt.Render(c.App.Writer, format, data, color, c.String(shellFlag)); err != nil {
+						return err
+					}
+					_, err = fmt.Fprintln(c.App.Writer)
+					return err
+				},
+			},
+			{
+				Name:  "dev",
+				Usage: "run local Temporal dev servers with a context for each",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "up",
+						Usage: "start a dev server in the background and add a context for it",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    contextNameFlag,
+								Aliases: []string{"c"},
+								Usage:   "name of the context to add (default: dev-<port>)",
+							},
+							&cli.IntFlag{
+								Name:  portFlag,
+								Usage: "frontend port of the dev server",
+								Value: devserver.DefaultPort,
+							},
+							&cli.IntFlag{
+								Name:        uiPortFlag,
+								Aliases:     []string{"ui-port"},
+								Usage:       "web UI port of the dev server",
+								DefaultText: "port + 1000",
+							},
+							&cli.StringFlag{
+								Name:      dbFlag,
+								Usage:     "file to persist data to, instead of keeping it in memory",
+								TakesFile: true,
+							},
+							&cli.StringFlag{
+								Name:    serverFlag,
+								Usage:   "Temporal CLI executable, run as `<server> server start-dev`",
+								EnvVars: []string{"TCTX_DEV_SERVER"},
+								Value:   "temporal",
+							},
+							&cli.DurationFlag{
+								Name:  timeoutFlag,
+								Usage: "how long to wait for the dev server to become healthy",
+								Value: devserver.DefaultStartTimeout,
+							},
+						},
+						Action: devUp,
+					},
+					{
+						Name:  "down",
+						Usage: "stop a dev server and delete its context",
+						Flags: []cli.Flag{
+							getContextFlag(false),
+						},
+						Action: devDown,
+					},
+					{
+						Name:    "list",
+						Aliases: []string{"ls"},
+						Usage:   "list dev servers started by tctx",
+						Action:  devList,
+					},
+				},
+			},
+			{
+				Name:  "open",
+				Usage: "open the Temporal web UI for a context",
+				Flags: []cli.Flag{
+					getContextFlag(false),
+					&cli.StringFlag{
+						Name:    namespaceFlag,
+						Aliases: []string{"ns"},
+						Usage:   "Temporal workflow namespace (default: the context's namespace)",
+					},
+					&cli.StringFlag{
+						Name:    workflowIDFlag,
+						Aliases: []string{"workflow-id", "w"},
+						Usage:   "open a workflow execution",
+					},
+					&cli.StringFlag{
+						Name:    runIDFlag,
+						Aliases: []string{"run-id", "r"},
+						Usage:   "run id of the workflow execution (default: latest run)",
+					},
+					&cli.StringFlag{
+						Name:    scheduleIDFlag,
+						Aliases: []string{"schedule"},
+						Usage:   "open a schedule",
+					},
+					&cli.StringFlag{
+						Name:    taskQueueFlag,
+						Aliases: []string{"task-queue"},
+						Usage:   "open a task queue",
+					},
+					&cli.StringFlag{
+						Name:    queryFlag,
+						Aliases: []string{"q"},
+						Usage:   "list workflows matching a visibility query",
+					},
+					&cli.BoolFlag{
+						Name:  printFlag,
+						Usage: "print the URL instead of opening a browser",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+
+					resolved, err := resolveContext(c, t)
+					if err != nil {
+						return err
+					}
+
+					link, err := webURLFromFlags(c, resolved.Name, resolved.Config)
+					if err != nil {
+						return err
+					}
+
+					if c.Bool(printFlag) {
+						_, err := fmt.Fprintln(c.App.Writer, link)
+						return err
+					}
+					return webui.Open(link)
+				},
+			},
+			{
+				Name:  "proxy",
+				Usage: "run a local gRPC proxy to the active context",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  listenFlag,
+						Usage: "local address to accept plaintext gRPC connections on",
+						Value: "localhost:7233",
+					},
+					&cli.BoolFlag{
+						Name:  rewriteNamespaceFlag,
+						Usage: "replace the namespace of each request with the active context's namespace",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+
+					var opts []proxy.Option
+					if c.Bool(rewriteNamespaceFlag) {
+						opts = append(opts, proxy.WithNamespaceRewrite())
+					}
+					p := proxy.New(t, opts...)
+					p.OnSwitch = func(name string, cfg *config.ClusterConfig) {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "Forwarding requests to %s.\n", proxy.Describe(name, cfg))
+					}
+
+					lis, err := net.Listen("tcp", c.String(listenFlag))
+					if err != nil {
+						return err
+					}
+					_, _ = fmt.Fprintf(c.App.Writer, "Proxy listening on %s.\n", lis.Addr())
+
+					ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+
+					return p.Serve(ctx, lis)
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "manage the tctx config file",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "log",
+						Usage: "list recent changes to the config file",
+						Action: func(c *cli.Context) error {
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							entries, err := t.GetHistory()
+							if err != nil {
+								return err
+							}
+
+							w := tabwriter.NewWriter(c.App.Writer, 1, 1, 4, ' ', 0)
+							if _, err := fmt.Fprintln(w, "ID\tTIME\tCOMMAND\t"); err != nil {
+								return err
+							}
+							for i := len(entries) - 1; i >= 0; i-- {
+								entry := entries[i]
+								if _, err := fmt.Fprintf(w, "%d\t%s\t%s\t\n",
+									entry.ID, entry.Time.Local().Format(historyTimeFormat), entry.Command); err != nil {
+									return err
+								}
+							}
+							return w.Flush()
+						},
+					},
+					{
+						Name:      "diff",
+						ArgsUsage: "<id>",
+						Usage:     "show a change to the config file",
+						Action: func(c *cli.Context) error {
+							id, err := historyIDArg(c, "diff")
+							if err != nil {
+								return err
+							}
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							entry, err := t.GetHistoryEntry(id)
+							if err != nil {
+								return err
+							}
+
+							_, err = fmt.Fprintf(c.App.Writer, "Change %d at %s: %s\n\n%s",
+								entry.ID, entry.Time.Local().Format(historyTimeFormat), entry.Command, entry.Diff())
+							return err
+						},
+					},
+					{
+						Name:      "restore",
+						ArgsUsage: "<id>",
+						Usage:     "revert the config file to its state after a change",
+						Action: func(c *cli.Context) error {
+							id, err := historyIDArg(c, "restore")
+							if err != nil {
+								return err
+							}
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+							if err := t.Restore(id); err != nil {
+								return err
+							}
+
+							_, err = fmt.Fprintf(c.App.Writer, "Config restored to its state after change %d.\n", id)
+							return err
+						},
+					},
+					{
+						Name:  "convert",
+						Usage: "convert the config file to another format",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     toFlag,
+								Usage:    fmt.Sprintf("config file format (%s)", strings.Join(config.Formats, ", ")),
+								Required: true,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							t, err := getConfigManager(c)
+							if err != nil {
+								return err
+							}
+
+							oldPath := c.String(configPathFlag)
+							newPath, err := t.Convert(c.String(toFlag))
+							if err != nil {
+								return err
+							}
+
+							_, err = fmt.Fprintf(c.App.Writer, "Config file converted to %s.\nPrevious config file saved as %s.\n", newPath, oldPath+".bak")
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "undo",
+				Usage: "revert the most recent change to the config file",
+				Action: func(c *cli.Context) error {
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+					entry, err := t.Undo()
+					if err != nil {
+						return err
+					}
+
+					_, err = fmt.Fprintf(c.App.Writer, "Reverted change %d: %s\n", entry.ID, entry.Command)
+					return err
+				},
+			},
+			{
+				Name:  "trust",
+				Usage: "allow the project file for the current directory to set environment variables",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  revokeFlag,
+						Usage: "stop trusting the project file",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					wd, err := os.Getwd()
+					if err != nil {
+						return err
+					}
+					f, err := project.Find(wd)
+					if err != nil {
+						return err
+					}
+					if f == nil {
+						return fmt.Errorf("no project file found in %s or its parent directories", wd)
+					}
+
+					if c.Bool(revokeFlag) {
+						if err := getTrustStore(c).Revoke(f); err != nil {
+							return err
+						}
+						_, err = fmt.Fprintf(c.App.Writer, "Project file %s is no longer trusted.\n", f.Path)
+						return err
+					}
+					if err := getTrustStore(c).Trust(f); err != nil {
+						return err
+					}
+					_, err = fmt.Fprintf(c.App.Writer, "Project file %s trusted.\n", f.Path)
+					return err
+				},
+			},
+			{
+				Name:  "share",
+				Usage: "print a token which teammates can pass to `tctx add --from_token`",
+				Flags: []cli.Flag{
+					getContextFlag(false),
+					&cli.BoolFlag{
+						Name:  signFlag,
+						Usage: "sign the token with your local signing key, which is created if needed",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+					resolved, err := resolveContext(c, t)
+					if err != nil {
+						return err
+					}
+
+					var key ed25519.PrivateKey
+					if c.Bool(signFlag) {
+						if key, err = share.LoadOrCreateKey(getSigningKeyPath(c)); err != nil {
+							return err
+						}
+					}
+					token, decoded, err := share.Encode(resolved.Name, resolved.Config, key)
+					if err != nil {
+						return err
+					}
+					if _, err := fmt.Fprintln(c.App.Writer, token); err != nil {
+						return err
+					}
+
+					if len(decoded.Omitted) > 0 {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "Secrets not shared: %s.\n", strings.Join(decoded.Omitted, ", "))
+					}
+					if decoded.Signer != "" {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "Signed by %s.\n", decoded.Signer)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "write contexts to stdout as a bundle which can be shared with `tctx import` or `tctx sync`",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  contextsFlag,
+						Usage: "names of contexts to export (default: all contexts)",
+					},
+					&cli.StringFlag{
+						Name:  relativeToFlag,
+						Usage: "make TLS file paths relative to this directory",
+					},
+					&cli.BoolFlag{
+						Name:  includeSecretsFlag,
+						Usage: "keep TLS private keys, auth secrets and secret environment variables",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+					contexts, err := t.GetAllContexts()
+					if err != nil {
+						return err
+					}
+
+					selected := contexts.Contexts
+					if names := c.StringSlice(contextsFlag); len(names) > 0 {
+						selected = map[string]*config.ClusterConfig{}
+						for _, name := range names {
+							cfg, ok := contexts.Contexts[name]
+							if !ok {
+								return fmt.Errorf("context %q does not exist", name)
+							}
+							selected[name] = cfg
+						}
+					}
+
+					b, stripped, err := bundle.Export(selected, bundle.ExportOptions{
+						RelativeTo:     c.String(relativeToFlag),
+						IncludeSecrets: c.Bool(includeSecretsFlag),
+					})
+					if err != nil {
+						return err
+					}
+					out, err := b.Marshal()
+					if err != nil {
+						return err
+					}
+					if _, err := c.App.Writer.Write(out); err != nil {
+						return err
+					}
+					if len(stripped) > 0 {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "Removed secrets: %s (use --%s to keep them).\n",
+							strings.Join(stripped, ", "), includeSecretsFlag)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "import",
+				ArgsUsage: "<bundle file>",
+				Usage:     "add contexts from a bundle file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  onConflictFlag,
+						Usage: fmt.Sprintf("how to handle contexts which already exist with different settings (%v)", bundle.Strategies),
+						Value: string(bundle.Prompt),
+					},
+					getForceFlag(),
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return cli.ShowCommandHelp(c, "import")
+					}
+					strategy, err := bundle.ParseStrategy(c.String(onConflictFlag))
+					if err != nil {
+						return err
+					}
+					resolve := func(string) (bundle.Strategy, error) { return strategy, nil }
+					if strategy == bundle.Prompt {
+						resolve = promptStrategy(c.App.Reader, c.App.Writer)
+					}
+
+					b, err := bundle.Read(c.Args().First())
+					if err != nil {
+						return err
+					}
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+
+					var report *bundle.Report
+					if err := t.Update(func(cfg *config.Config) error {
+						// Compare with the definitions rather than the namespaces in use
+						all, err := t.GetDefinitions()
+						if err != nil {
+							return err
+						}
+						if report, err = bundle.Import(cfg, all, b, resolve); err != nil {
+							return err
+						}
+						for _, name := range append(report.Added, report.Changed...) {
+							if err := t.CheckContext(name, cfg.Contexts[name]); err != nil {
+								return err
+							}
+						}
+						return nil
+					}); err != nil {
+						return forceHint(err)
+					}
+					return printReport(c.App.Writer, report)
+				},
+			},
+			{
+				Name:  "sync",
+				Usage: "keep a set of managed contexts in line with a bundle file or directory, such as a git checkout",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     sourceFlag,
+						Usage:    "bundle file, or directory of bundle files",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  nameFlag,
+						Usage: "name of the set of contexts managed by this source",
+						Value: "default",
+					},
+					getForceFlag(),
+				},
+				Action: func(c *cli.Context) error {
+					b, err := bundle.ReadSource(c.String(sourceFlag))
+					if err != nil {
+						return err
+					}
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+
+					var report *bundle.Report
+					if err := t.Update(func(cfg *config.Config) error {
+						all, err := t.GetDefinitions()
+						if err != nil {
+							return err
+						}
+						report = bundle.Sync(cfg, all.Contexts, c.String(nameFlag), b)
+						for _, name := range append(report.Added, report.Changed...) {
+							if err := t.CheckContext(name, cfg.Contexts[name]); err != nil {
+								return err
+							}
+						}
+						return nil
+					}); err != nil {
+						return forceHint(err)
+					}
+					for _, name := range report.Skipped {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: context %q is not managed by %q and was not synced\n", name, c.String(nameFlag))
+					}
+					return printReport(c.App.Writer, report)
+				},
+			},
+			{
+				Name:   "tctxbar",
+				Hidden: true,
+				Flags: []cli.Flag{
+					&xbar.ShowClusterFlag,
+					&xbar.ShowNamespaceFlag,
+					&xbar.GroupByFlag,
+				},
+				Action: func(c *cli.Context) error {
+					executablePath, err := os.Executable()
+					if err != nil {
+						return err
+					}
+
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+					cfg, err := t.GetAllContexts()
+					if err != nil {
+						return err
+					}
+
+					// Define a timeout to avoid blocking menu rendering on querying
+					// Temporal cluster state.
+					ctx, cancel := context.WithTimeout(c.Context, time.Second)
+					defer cancel()
+
+					return xbar.Render(ctx, &xbar.Options{
+						Config:        cfg,
+						TctxPath:      executablePath,
+						ShowCluster:   c.Bool(xbar.ShowClusterFlag.Name),
+						ShowNamespace: c.Bool(xbar.ShowNamespaceFlag.Name),
+						GroupBy:       c.String(xbar.GroupByFlag.Name),
+						Statuses:      daemonStatuses(c),
+					})
+				},
+			},
+			{
+				Name:  "plugin",
+				Usage: "manage external subcommands: executables named tctx-<name> on PATH run as `tctx <name>`",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list the plugins found on PATH",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    outputFlag,
+								Aliases: []string{"o"},
+								Usage:   "output format: json or yaml",
+							},
+						},
+						Action: listPlugins,
+					},
+				},
+			},
+			{
+				Name:      "exec",
+				Aliases:   []string{},
+				ArgsUsage: "-- <command> [args]",
+				Usage:     "execute a command with temporal environment variables set",
+				Flags: []cli.Flag{
+					getContextFlag(false),
+					&cli.BoolFlag{
+						Name:    verboseFlag,
+						Aliases: []string{"v"},
+						Usage:   "print the selected context and what selected it to stderr",
+					},
+					&cli.StringFlag{
+						Name:    selectorFlag,
+						Aliases: []string{"l"},
+						Usage:   "run the command once for each context with matching labels, such as env=prod",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() == 0 {
+						return cli.ShowCommandHelp(c, "exec")
+					}
+
+					t, err := getConfigManager(c)
+					if err != nil {
+						return err
+					}
+
+					if c.IsSet(selectorFlag) {
+						return execSelected(c, t)
+					}
+
+					resolved, err := resolveContext(c, t)
+					if err != nil {
+						return err
+					}
+					for _, warning := range resolved.Warnings {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", warning)
+					}
+					if c.Bool(verboseFlag) {
+						_, _ = fmt.Fprintf(c.App.ErrWriter, "Using context %q with namespace %q (selected by %s).\n",
+							resolved.Name, resolved.Config.Namespace, resolved.Source)
+					}
 
 					cmd := exec.Command(c.Args().First(), c.Args().Tail()...)
-					cmd.Env = env
 					cmd.Stdin = c.App.Reader
 					cmd.Stdout = c.App.Writer
 					cmd.Stderr = c.App.ErrWriter
 
-					return cmd.Run()
+					all, err := t.GetAllContexts()
+					if err != nil {
+						return err
+					}
+					return runWithExecHooks(c, all, resolved, cmd)
 				},
 			},
 		},
@@ -1,17 +1,44 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/internal/daemon"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
+func TestMain(m *testing.M) {
+	// Keep config files shared on this machine out of the tests
+	dir, err := os.MkdirTemp("", "tctx_layers")
+	if err != nil {
+		panic(err)
+	}
+	_ = os.Setenv("TCTX_CONFIG_PATHS", "")
+	_ = os.Setenv("TCTX_SYSTEM_CONFIG_PATH", filepath.Join(dir, "config.json"))
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestCLI(t *testing.T) {
 	configDir, err := ioutil.TempDir("", "tctx_test")
 	if err != nil {
@@ -23,6 +50,16 @@ func TestCLI(t *testing.T) {
 		}
 	}()
 	c := tctxConfigFile(filepath.Join(configDir, "tctx", "config.json"))
+	// TLS files are relative to the working directory, and must exist
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(configDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	createFiles(t, configDir, "foo", "bar", "baz")
 
 	// Check for no error when config is empty
 	c.Run(t, TestCase{
@@ -143,6 +180,16 @@ func TestCLI(t *testing.T) {
 			"FOO=bar",
 		},
 	})
+
+	// Environment variables of the context can't override the settings tctx manages
+	c.Run(t, TestCase{
+		Command: "update -c production --ns test --env TEMPORAL_CLI_ADDRESS=evil:7233",
+		StdOut:  "Context \"production\" modified.\nActive namespace is \"test\".",
+	})
+	c.Run(t, TestCase{
+		Command: "exec -- printenv TEMPORAL_CLI_ADDRESS",
+		StdOut:  "temporal.example.com:443",
+	})
 }
 
 func TestNestedRegression(t *testing.T) {
@@ -158,7 +205,8 @@ func TestNestedRegression(t *testing.T) {
 	c := tctxConfigFile(filepath.Join(configDir, "tctx", "config.json"))
 
 	// Add a context
-	tlsCaPath := "/path/to/ca"
+	tlsCaPath := filepath.Join(configDir, "ca.pem")
+	createFiles(t, configDir, "ca.pem")
 	c.Run(t, TestCase{
 		Command: fmt.Sprintf("add -c regression --namespace default --address localhost:7233 --tls_ca_path %s", tlsCaPath),
 		StdOut:  "Context \"regression\" modified.\nActive namespace is \"default\".\n",
@@ -195,25 +243,601 @@ func TestNestedRegression(t *testing.T) {
 	})
 }
 
+func TestAuth(t *testing.T) {
+	configDir := t.TempDir()
+	c := tctxConfigFile(filepath.Join(configDir, "tctx", "config.json"))
+
+	t.Setenv("TCTX_TEST_API_KEY", "my-api-key")
+	tokenFile := filepath.Join(configDir, "token")
+	if err := os.WriteFile(tokenFile, []byte("my-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets must be passed by reference
+	c.Run(t, TestCase{
+		Command:       "add -c cloud --ns default --address example.tmprl.cloud:7233 --auth_type apikey --auth_secret_ref my-api-key",
+		ExpectedError: fmt.Errorf("invalid secret reference \"my-api-key\": must be of the form env:NAME or file:PATH"),
+	})
+	c.Run(t, TestCase{
+		Command: "add -c cloud --ns default --address example.tmprl.cloud:7233 --auth_type apikey --auth_secret_ref env:TCTX_TEST_API_KEY",
+		StdOut:  "Context \"cloud\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command:        "exec -- printenv",
+		StdOutContains: []string{"TEMPORAL_API_KEY=my-api-key"},
+	})
+	// Switch to a bearer token in a custom header
+	c.Run(t, TestCase{
+		Command: "update -c cloud --auth_type bearer-static --auth_header x-token --auth_secret_ref file:" + tokenFile,
+		StdOut:  "Context \"cloud\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command:        "exec -- printenv",
+		StdOutContains: []string{"TEMPORAL_GRPC_META=x-token=my-token"},
+	})
+	// Incomplete auth settings are rejected
+	c.Run(t, TestCase{
+		Command:       "update -c cloud --auth_type bearer-exec",
+		ExpectedError: fmt.Errorf("auth type \"bearer-exec\" requires a command"),
+	})
+}
+
+func TestOpen(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "tctx", "config.json"))
+
+	c.Run(t, TestCase{
+		Command: "add -c local --ns default --address localhost:7233",
+		StdOut:  "Context \"local\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command:       "open --print",
+		ExpectedError: fmt.Errorf("context \"local\" has no web address: set one with `tctx update -c local --web_address <url>`"),
+	})
+	c.Run(t, TestCase{
+		Command: "update -c local --web_address http://localhost:8233",
+		StdOut:  "Context \"local\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command: "open --print",
+		StdOut:  "http://localhost:8233/namespaces/default/workflows",
+	})
+	c.Run(t, TestCase{
+		Command: "open --print --ns other --workflow-id order/1 --run-id abc",
+		StdOut:  "http://localhost:8233/namespaces/other/workflows/order%2F1/abc/history",
+	})
+	c.Run(t, TestCase{
+		Command: "open -c local --print --schedule nightly",
+		StdOut:  "http://localhost:8233/namespaces/default/schedules/nightly",
+	})
+	c.Run(t, TestCase{
+		Command: "open --print --task-queue orders",
+		StdOut:  "http://localhost:8233/namespaces/default/task-queues/orders",
+	})
+	c.Run(t, TestCase{
+		Command: "open --print --query ExecutionStatus=\"Running\"",
+		StdOut:  "http://localhost:8233/namespaces/default/workflows?query=ExecutionStatus%3D%22Running%22",
+	})
+	c.Run(t, TestCase{
+		Command:       "open --print --run-id abc",
+		ExpectedError: fmt.Errorf("--run_id requires --workflow_id"),
+	})
+	c.Run(t, TestCase{
+		Command:       "open --print --workflow-id order --task-queue orders",
+		ExpectedError: fmt.Errorf("flags --workflow_id, --task_queue cannot be used together"),
+	})
+	// Web addresses which aren't URLs don't prevent listing other contexts
+	c.Run(t, TestCase{Command: "add -c legacy --ns default --address legacy:7233 --web_address localhost:8080 --force"})
+	c.Run(t, TestCase{
+		Command: "list",
+		StdOutContains: []string{
+			"legacy    legacy:7233       default      localhost:8080 ",
+			"local     localhost:7233    default      http://localhost:8233/namespaces/default/workflows",
+		},
+	})
+}
+
+func TestProjectFile(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "tctx", "config.json"))
+	c.Run(t, TestCase{Command: "add -c staging --ns staging --address staging:7233"})
+	c.Run(t, TestCase{Command: "add -c production --ns myapp --address production:7233"})
+
+	projectDir := t.TempDir()
+	projectFile := filepath.Join(projectDir, ".tctx.yaml")
+	if err := os.WriteFile(projectFile, []byte("context: staging\nnamespace: orders\nenv:\n  FOO: bar\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	serviceDir := filepath.Join(projectDir, "services", "orders")
+	if err := os.MkdirAll(serviceDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(serviceDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	// Ensure session environment is unset
+	t.Setenv(contextEnvVar, "")
+	t.Setenv(namespaceEnvVar, "")
+
+	// Project file takes precedence over the active context
+	c.Run(t, TestCase{
+		Command: "list",
+		StdOutContains: []string{
+			"staging       staging:7233       staging             active",
+			fmt.Sprintf("Context \"staging\" selected by %s.", projectFile),
+		},
+	})
+	// Environment variables are ignored until the project file is trusted
+	c.Run(t, TestCase{
+		Command:           "exec -v -- printenv",
+		StdOutContains:    []string{"TEMPORAL_CLI_ADDRESS=staging:7233", "TEMPORAL_CLI_NAMESPACE=orders"},
+		StdOutNotContains: []string{"FOO=bar"},
+		StdErrContains: []string{
+			fmt.Sprintf("warning: ignoring environment variables from untrusted project file %s", projectFile),
+			fmt.Sprintf("Using context \"staging\" with namespace \"orders\" (selected by %s).", projectFile),
+		},
+	})
+	c.Run(t, TestCase{
+		Command: "trust",
+		StdOut:  fmt.Sprintf("Project file %s trusted.", projectFile),
+	})
+	c.Run(t, TestCase{
+		Command:        "exec -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_NAMESPACE=orders", "FOO=bar"},
+	})
+	// Changing the project file requires trusting it again
+	if err := os.WriteFile(projectFile, []byte("context: staging\nenv:\n  FOO: baz\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.Run(t, TestCase{
+		Command:           "exec -- printenv",
+		StdOutContains:    []string{"TEMPORAL_CLI_NAMESPACE=staging"},
+		StdOutNotContains: []string{"FOO=baz"},
+	})
+
+	// Session environment takes precedence over the project file
+	t.Setenv(contextEnvVar, "production")
+	t.Setenv(namespaceEnvVar, "session")
+	c.Run(t, TestCase{
+		Command:        "exec -v -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_ADDRESS=production:7233", "TEMPORAL_CLI_NAMESPACE=session"},
+		StdErrContains: []string{"(selected by TCTX_CONTEXT)"},
+	})
+	// Flags take precedence over everything
+	c.Run(t, TestCase{
+		Command:        "exec -c staging -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_ADDRESS=staging:7233", "TEMPORAL_CLI_NAMESPACE=staging"},
+	})
+}
+
+func TestConfigLayers(t *testing.T) {
+	configDir := t.TempDir()
+	teamConfig := filepath.Join(configDir, "team.json")
+	if err := os.WriteFile(teamConfig, []byte(`{"contexts": {"shared": {"address": "shared:7233", "namespace": "default"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TCTX_CONFIG_PATHS", teamConfig)
+	c := tctxConfigFile(filepath.Join(configDir, "tctx", "config.json"))
+
+	c.Run(t, TestCase{
+		Command: "add -c personal --ns default --address localhost:7233",
+		StdOut:  "Context \"personal\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command: "list",
+		StdOutContains: []string{
+			"NAME        ADDRESS           NAMESPACE    WEB    SOURCE" + strings.Repeat(" ", len(teamConfig)-2) + "STATUS",
+			"personal    localhost:7233    default             user" + strings.Repeat(" ", len(teamConfig)) + "active",
+			"shared      shared:7233       default             " + teamConfig,
+		},
+	})
+	// Read-only contexts can be selected but not modified
+	c.Run(t, TestCase{
+		Command: "use -c shared",
+		StdOut:  "Context \"shared\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command:       "update -c shared --address other:7233",
+		ExpectedError: fmt.Errorf("context \"shared\" is defined in read-only config file %s", teamConfig),
+	})
+	c.Run(t, TestCase{
+		Command:       "delete -c shared",
+		ExpectedError: fmt.Errorf("context \"shared\" is defined in read-only config file %s", teamConfig),
+	})
+}
+
+func TestConfigConvert(t *testing.T) {
+	configDir := t.TempDir()
+	c := tctxConfigFile(filepath.Join(configDir, "config.json"))
+
+	c.Run(t, TestCase{
+		Command: "add -c local --ns default --address localhost:7233",
+		StdOut:  "Context \"local\" modified.\nActive namespace is \"default\".\n",
+	})
+	c.Run(t, TestCase{
+		Command: "config convert --to yaml",
+		StdOut: fmt.Sprintf("Config file converted to %s.\nPrevious config file saved as %s.",
+			filepath.Join(configDir, "config.yaml"), filepath.Join(configDir, "config.json.bak")),
+	})
+
+	yamlConfig := tctxConfigFile(filepath.Join(configDir, "config.yaml"))
+	yamlConfig.Run(t, TestCase{
+		Command:        "list",
+		StdOutContains: []string{"local    localhost:7233    default             active"},
+	})
+	yamlConfig.Run(t, TestCase{
+		Command:       "config convert --to yaml",
+		ExpectedError: fmt.Errorf("config file %s is already in yaml format", filepath.Join(configDir, "config.yaml")),
+	})
+}
+
+func TestBundles(t *testing.T) {
+	dir := t.TempDir()
+	src := tctxConfigFile(filepath.Join(dir, "src", "config.json"))
+	dst := tctxConfigFile(filepath.Join(dir, "dst", "config.json"))
+	createFiles(t, dir, filepath.Join("certs", "client.pem"), filepath.Join("certs", "client.key"))
+
+	src.Run(t, TestCase{
+		Command: "add -c staging --ns default --address staging:7233 --tls_cert_path " + filepath.Join(dir, "certs", "client.pem") +
+			" --tls_key_path " + filepath.Join(dir, "certs", "client.key"),
+	})
+	src.Run(t, TestCase{
+		Command: "add -c prod --ns default --address prod:7233 --env TEAM=payments --env TEMPORAL_API_KEY=secret",
+	})
+
+	// Export strips secrets and relativizes paths
+	app, buf, errBuf := src.newApp()
+	if err := app.Run([]string{"tctx", "export", "--relative_to", dir}); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{`"certPath": "certs/client.pem"`, `"TEAM": "payments"`} {
+		if !strings.Contains(buf.String(), text) {
+			t.Errorf("expected export to contain %q. Got: \n%s", text, buf.String())
+		}
+	}
+	for _, text := range []string{"client.key", "TEMPORAL_API_KEY"} {
+		if strings.Contains(buf.String(), text) {
+			t.Errorf("expected export not to contain %q. Got: \n%s", text, buf.String())
+		}
+	}
+	if !strings.Contains(errBuf.String(), "Removed secrets: prod.env.TEMPORAL_API_KEY, staging.tls.keyPath") {
+		t.Errorf("expected export to report removed secrets. Got: \n%s", errBuf.String())
+	}
+	bundlePath := filepath.Join(dir, "team.json")
+	if err := os.WriteFile(bundlePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import into an empty config
+	dst.Run(t, TestCase{
+		Command: "import " + bundlePath,
+		StdOut:  "Added: prod, staging",
+	})
+	dst.Run(t, TestCase{
+		Command:        "exec -c staging -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_TLS_CERT=" + filepath.Join(dir, "certs", "client.pem")},
+	})
+	dst.Run(t, TestCase{
+		Command: "import " + bundlePath,
+		StdOut:  "No contexts changed.",
+	})
+	// The namespace in use is not part of the definition
+	dst.Run(t, TestCase{Command: "use -c staging --ns other"})
+	dst.Run(t, TestCase{
+		Command: "import " + bundlePath,
+		StdOut:  "No contexts changed.",
+	})
+
+	// Conflicts
+	dst.Run(t, TestCase{Command: "update -c prod --address other:7233"})
+	dst.Run(t, TestCase{
+		Command: "import --on_conflict skip " + bundlePath,
+		StdOut:  "Skipped: prod\nNo contexts changed.",
+	})
+	dst.Run(t, TestCase{
+		Command: "import --on_conflict rename " + bundlePath,
+		StdOut:  "Added: prod-2\nRenamed \"prod\" to \"prod-2\".",
+	})
+	dst.Run(t, TestCase{
+		Command:        "import " + bundlePath,
+		Stdin:          "x\noverwrite\n",
+		StdOutContains: []string{"[s]kip, [o]verwrite or [r]ename?", "Changed: prod"},
+	})
+	dst.Run(t, TestCase{
+		Command:       "import --on_conflict merge " + bundlePath,
+		ExpectedError: fmt.Errorf("unknown conflict strategy \"merge\": must be one of [skip overwrite rename prompt]"),
+	})
+
+	// Sync from a directory
+	checkout := filepath.Join(dir, "checkout")
+	if err := os.Mkdir(checkout, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeBundle := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(checkout, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeBundle("a.yaml", "version: 1\ncontexts:\n  dev:\n    address: dev:7233\n    namespace: default\n  prod:\n    address: prod:7233\n    namespace: default\n")
+	writeBundle("b.json", `{"version": 1, "contexts": {"qa": {"address": "qa:7233", "namespace": "default"}}}`)
+	dst.Run(t, TestCase{
+		Command:        "sync --source " + checkout,
+		StdOut:         "Added: dev, qa\nSkipped: prod",
+		StdErrContains: []string{`warning: context "prod" is not managed by "default" and was not synced`},
+	})
+	dst.Run(t, TestCase{Command: "use -c dev --ns other"})
+	dst.Run(t, TestCase{
+		Command: "sync --source " + checkout,
+		StdOut:  "Skipped: prod\nNo contexts changed.",
+	})
+	writeBundle("a.yaml", "version: 1\ncontexts:\n  dev:\n    address: dev2:7233\n    namespace: default\n")
+	if err := os.Remove(filepath.Join(checkout, "b.json")); err != nil {
+		t.Fatal(err)
+	}
+	dst.Run(t, TestCase{
+		Command: "sync --source " + checkout,
+		StdOut:  "Changed: dev\nRemoved: qa",
+	})
+	dst.Run(t, TestCase{
+		Command:           "list",
+		StdOutContains:    []string{"dev2:7233"},
+		StdOutNotContains: []string{"qa:7233"},
+	})
+}
+
+func TestShare(t *testing.T) {
+	dir := t.TempDir()
+	alice := tctxConfigFile(filepath.Join(dir, "alice", "config.json"))
+	bob := tctxConfigFile(filepath.Join(dir, "bob", "config.json"))
+
+	alice.Run(t, TestCase{
+		Command: "add -c prod --ns myapp --address prod:7233 --tls_cert_path /certs/client.pem --tls_key_path /certs/client.key --force",
+	})
+	app, buf, errBuf := alice.newApp()
+	if err := app.Run([]string{"tctx", "share", "-c", "prod", "--sign"}); err != nil {
+		t.Fatal(err)
+	}
+	token := strings.TrimSpace(buf.String())
+	if !strings.Contains(errBuf.String(), "Secrets not shared: tls.keyPath.") {
+		t.Errorf("expected share to report omitted secrets. Got: \n%s", errBuf.String())
+	}
+	signer := strings.TrimSuffix(strings.TrimPrefix(strings.Split(errBuf.String(), "\n")[1], "Signed by "), ".")
+
+	bob.Run(t, TestCase{
+		Command:       "add -c prod --from_token " + token,
+		ExpectedError: fmt.Errorf("token does not include secrets: provide them with --tls_key_path"),
+	})
+	bob.Run(t, TestCase{
+		Command:       "add -c prod --signer SHA256:other --from_token " + token,
+		ExpectedError: fmt.Errorf("token is signed by %s: expected a signature by SHA256:other", signer),
+	})
+	bob.Run(t, TestCase{
+		Command: "add -c prod --tls_key_path /bob/client.key --from_token " + token,
+		Stdin:   "n\n",
+		StdOut: fmt.Sprintf(`Context "prod" shared as "prod" (signed by %s, not verified):
+
+    address: prod:7233
+    namespace: myapp
+    tls:
+        certPath: /certs/client.pem
+        keyPath: /bob/client.key
+
+This token is not authenticated: anyone could have created or modified it. Check these settings,
+or pass --signer with a fingerprint you trust.
+
+Add this context? [y/N] Context "prod" not added.`, signer),
+	})
+	bob.Run(t, TestCase{
+		Command:           "add -c prod --tls_key_path /bob/client.key --force --signer " + signer + " --from_token " + token,
+		Stdin:             "y\n",
+		StdOutContains:    []string{fmt.Sprintf("(signed by %s):", signer), "Context \"prod\" modified.\nActive namespace is \"myapp\"."},
+		StdOutNotContains: []string{"not authenticated"},
+	})
+	bob.Run(t, TestCase{
+		Command:        "add -c prod-2 --tls_key_path /bob/client.key --force --yes --from_token " + token,
+		StdErrContains: []string{"warning: the token is not authenticated: pass --signer to check who created it"},
+	})
+	bob.Run(t, TestCase{
+		Command:        "exec -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_TLS_CERT=/certs/client.pem", "TEMPORAL_CLI_TLS_KEY=/bob/client.key"},
+	})
+	bob.Run(t, TestCase{
+		Command:       "add -c staging",
+		ExpectedError: fmt.Errorf("Required flag \"address\" not set"),
+	})
+}
+
+func TestHistory(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+
+	c.Run(t, TestCase{Command: "add -c prod --ns myapp --address prod:7233 --tls_server_name prod.example.com"})
+	c.Run(t, TestCase{Command: "update -c prod --address prod.example.com:7233"})
+	c.Run(t, TestCase{
+		Command: "config log",
+		StdOutContains: []string{
+			"ID    TIME",
+			"2     ",
+			"tctx update -c prod --address prod.example.com:7233",
+			"tctx add -c prod --ns myapp --address prod:7233 --tls_server_name prod.example.com",
+		},
+	})
+	c.Run(t, TestCase{
+		Command: "config diff 2",
+		StdOutContains: []string{
+			": tctx update -c prod --address prod.example.com:7233\n",
+			"-			\"address\": \"prod:7233\",\n+			\"address\": \"prod.example.com:7233\",",
+		},
+	})
+	c.Run(t, TestCase{
+		Command: "undo",
+		StdOut:  "Reverted change 2: tctx update -c prod --address prod.example.com:7233",
+	})
+	c.Run(t, TestCase{
+		Command:        "exec -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_ADDRESS=prod:7233", "TEMPORAL_CLI_NAMESPACE=myapp"},
+	})
+	c.Run(t, TestCase{
+		Command: "config restore 2",
+		StdOut:  "Config restored to its state after change 2.",
+	})
+	c.Run(t, TestCase{
+		Command:        "exec -- printenv",
+		StdOutContains: []string{"TEMPORAL_CLI_ADDRESS=prod.example.com:7233"},
+	})
+	c.Run(t, TestCase{
+		Command:       "config diff two",
+		ExpectedError: fmt.Errorf("invalid change ID \"two\": see `tctx config log`"),
+	})
+}
+
+func TestOutput(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{Command: "add -c local --ns default --address localhost:7233"})
+	c.Run(t, TestCase{
+		Command: "add -c prod --ns myapp --address prod:7233 --web_address https://temporal.example.com" +
+			" --tls_cert_path /certs/client.pem --tls_key_path /certs/client.key --tls_server_name prod.example.com" +
+			" --auth_type apikey --auth_secret_ref env:PROD_API_KEY --headers_provider_plugin tctx-headers" +
+			" --env TEAM=payments --env GITHUB_TOKEN=hunter2 --label env=prod --label region=us --description Payments --force",
+	})
+
+	for _, tc := range []struct {
+		golden  string
+		command string
+	}{
+		{"list.txt", "list"},
+		{"list-wide.txt", "list -o wide"},
+		{"list-name.txt", "list -o name"},
+		{"list.json", "list -o json"},
+		{"list.yaml", "list -o yaml"},
+		{"list-template.txt", "list -o template={{.Name}}:{{.Namespace}}:{{.Active}}"},
+		{"show.yaml", "show"},
+		{"show.json", "show -c local -o json"},
+		{"show-template.txt", "show -o template={{.Auth.Type}}:{{.Environment.GITHUB_TOKEN}}"},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			app, buf, _ := c.newApp()
+			if err := app.Run(append([]string{"tctx"}, strings.Split(tc.command, " ")...)); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("testdata", "golden", tc.golden), buf.Bytes())
+		})
+	}
+
+	c.Run(t, TestCase{
+		Command:       "list -o xml",
+		ExpectedError: fmt.Errorf("unsupported output format \"xml\": must be one of wide, name, json, yaml, template=<go template>"),
+	})
+	c.Run(t, TestCase{
+		Command:       "show -o wide",
+		ExpectedError: fmt.Errorf("unsupported output format \"wide\": must be one of json, yaml, template=<go template>"),
+	})
+}
+
+func TestLabels(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{Command: "add -c dev --ns default --address dev:7233 --label env=dev"})
+	c.Run(t, TestCase{Command: "add -c prod-us --ns default --address prod-us:7233 --label env=prod --label region=us"})
+	c.Run(t, TestCase{Command: "add -c prod-eu --ns default --address prod-eu:7233 --label env=prod --label region=eu"})
+	c.Run(t, TestCase{
+		Command:       "add -c bad --ns default --address bad:7233 --label env",
+		ExpectedError: fmt.Errorf("unable to parse label \"env\": enter labels in the form --label key=value"),
+	})
+
+	c.Run(t, TestCase{
+		Command: "list -o name -l env=prod,region!=eu",
+		StdOut:  "prod-us",
+	})
+	c.Run(t, TestCase{
+		Command: "list -o name --selector !region",
+		StdOut:  "dev",
+	})
+
+	// Labels are merged on update, and removed with a trailing dash
+	c.Run(t, TestCase{Command: "update -c prod-eu --label region- --label tier=gold --description Europe"})
+	c.Run(t, TestCase{
+		Command: "show -c prod-eu -o template={{.Description}}:{{.Labels}}",
+		StdOut:  "Europe:map[env:prod tier:gold]",
+	})
+
+	// Run a command for each matching context
+	c.Run(t, TestCase{
+		Command:        "exec -l env=prod -- printenv TEMPORAL_CLI_ADDRESS",
+		StdOut:         "prod-eu:7233\nprod-us:7233",
+		StdErrContains: []string{"==> prod-eu\n", "==> prod-us\n"},
+	})
+	// Standard input is passed on, and read by the first context's command
+	c.Run(t, TestCase{
+		Command: "exec -l env=prod -- cat",
+		Stdin:   "input\n",
+		StdOut:  "input",
+	})
+	c.Run(t, TestCase{
+		Command:        "exec -l env=prod -- false",
+		ExpectedError:  fmt.Errorf("command failed for contexts: prod-eu, prod-us"),
+		StdErrContains: []string{"error: exit status 1"},
+	})
+	c.Run(t, TestCase{
+		Command:       "exec -l env=staging -- true",
+		ExpectedError: fmt.Errorf("no contexts match selector \"env=staging\""),
+	})
+	c.Run(t, TestCase{
+		Command:       "exec -c dev -l env=prod -- true",
+		ExpectedError: fmt.Errorf("--context and --selector cannot be used together"),
+	})
+}
+
+// assertGolden compares actual with the contents of a golden file, or updates
+// the golden file when tests are run with -update.
+func assertGolden(t *testing.T, path string, actual []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("output did not match %s (run tests with -update to update it)\n=== expected ===\n%s\n==== actual ====\n%s", path, expected, actual)
+	}
+}
+
 type TestCase struct {
-	Command        string
-	ExpectedError  error
-	StdOut         string
-	StdOutContains []string
+	Command           string
+	Stdin             string
+	ExpectedError     error
+	StdOut            string
+	StdOutContains    []string
+	StdOutNotContains []string
+	StdErrContains    []string
 }
 
 type tctxConfigFile string
 
-func (f tctxConfigFile) newApp() (*cli.App, *bytes.Buffer) {
+func (f tctxConfigFile) newApp() (*cli.App, *bytes.Buffer, *bytes.Buffer) {
 	buf := bytes.NewBufferString("")
+	errBuf := bytes.NewBufferString("")
 	app := newApp(string(f))
 	app.Writer = buf
-	return app, buf
+	app.ErrWriter = errBuf
+	return app, buf, errBuf
 }
 
 func (f tctxConfigFile) Run(t *testing.T, tc TestCase) {
 	t.Helper()
-	app, buf := f.newApp()
+	app, buf, errBuf := f.newApp()
+	if tc.Stdin != "" {
+		app.Reader = strings.NewReader(tc.Stdin)
+	}
 	err := app.Run(append([]string{"tctx"}, strings.Split(tc.Command, " ")...))
 
 	if tc.ExpectedError != nil {
@@ -234,6 +858,18 @@ func (f tctxConfigFile) Run(t *testing.T, tc TestCase) {
 			t.Errorf("expected CLI output to contain %q. Got: \n%s", text, actualStdOut)
 		}
 	}
+	for _, text := range tc.StdOutNotContains {
+		if strings.Contains(actualStdOut, text) {
+			t.Errorf("expected CLI output not to contain %q. Got: \n%s", text, actualStdOut)
+		}
+	}
+
+	actualStdErr := errBuf.String()
+	for _, text := range tc.StdErrContains {
+		if !strings.Contains(actualStdErr, text) {
+			t.Errorf("expected CLI error output to contain %q. Got: \n%s", text, actualStdErr)
+		}
+	}
 }
 
 func assertOutput(t *testing.T, expected, actual string) {
@@ -244,3 +880,844 @@ func assertOutput(t *testing.T, expected, actual string) {
 		t.Errorf("CLI output did not match expected\n=== expected ===\n%q\n==== actual ====\n%q\n", expected, actual)
 	}
 }
+
+func TestNamespaces(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{Command: "add -c dev --ns default --address 127.0.0.1:1"})
+	c.Run(t, TestCase{
+		Command: "ns add orders payments",
+		StdOut:  "Added favorite namespaces of context \"dev\": orders, payments.",
+	})
+	c.Run(t, TestCase{
+		Command: "ns use payments",
+		StdOut:  "Context \"dev\" modified.\nActive namespace is \"payments\".",
+	})
+	c.Run(t, TestCase{
+		Command: "show -o template={{.Namespace}}:{{.FavoriteNamespaces}}",
+		StdOut:  "payments:[orders payments]",
+	})
+	c.Run(t, TestCase{
+		Command: "exec -- printenv TEMPORAL_CLI_NAMESPACE",
+		StdOut:  "payments",
+	})
+
+	// Favorites are listed first, and still listed when discovery fails
+	c.Run(t, TestCase{
+		Command:        "ns list --discover",
+		StdOutContains: []string{"orders       yes", "payments     yes         active"},
+		StdErrContains: []string{"warning: could not list namespaces in the cluster"},
+	})
+	c.Run(t, TestCase{
+		Command: "ns use --generate-bash-completion",
+		StdOut:  "orders\npayments",
+	})
+
+	c.Run(t, TestCase{
+		Command: "ns remove orders",
+		StdOut:  "Removed favorite namespaces of context \"dev\": orders.",
+	})
+	c.Run(t, TestCase{
+		Command:       "ns remove orders",
+		ExpectedError: fmt.Errorf("namespace \"orders\" is not a favorite of context \"dev\""),
+	})
+
+	// Selecting the namespace of the definition clears the pointer
+	c.Run(t, TestCase{Command: "use -c dev --ns default"})
+	c.Run(t, TestCase{
+		Command:        "ns list",
+		StdOutContains: []string{"payments     yes", "default                  active"},
+	})
+}
+
+func TestHooks(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(`{
+  "active": "dev",
+  "contexts": {
+    "dev": {"address": "dev:7233", "namespace": "default"},
+    "prod": {
+      "address": "prod:7233",
+      "namespace": "orders",
+      "hooks": {"preUse": ["test \"$ALLOW_PROD\" = yes || { echo prod is locked; exit 1; }"]}
+    }
+  },
+  "hooks": {
+    "postUse": ["echo \"switched $TCTX_OLD_CONTEXT/$TCTX_OLD_NAMESPACE -> $TCTX_NEW_CONTEXT/$TCTX_NEW_NAMESPACE\""],
+    "preExec": ["test -z \"$BLOCK_EXEC\""],
+    "postExec": ["echo \"exec in $TCTX_NEW_CONTEXT exited $TCTX_EXIT_CODE\""],
+    "postAdd": ["echo \"added $TCTX_NEW_CONTEXT at $TCTX_NEW_ADDRESS\""],
+    "postDelete": ["echo \"deleted $TCTX_OLD_CONTEXT\"; exit 1"]
+  }
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := tctxConfigFile(configPath)
+
+	// A failing pre-use hook aborts the switch
+	c.Run(t, TestCase{
+		Command:        "use -c prod",
+		ExpectedError:  fmt.Errorf("pre-use hook \"test \\\"$ALLOW_PROD\\\" = yes || { echo prod is locked; exit 1; }\" failed: exit status 1"),
+		StdErrContains: []string{"prod is locked"},
+	})
+	c.Run(t, TestCase{Command: "exec -- printenv TEMPORAL_CLI_ADDRESS", StdOut: "dev:7233"})
+
+	t.Setenv("ALLOW_PROD", "yes")
+	c.Run(t, TestCase{
+		Command:        "use -c prod --ns payments",
+		StdOut:         "Context \"prod\" modified.\nActive namespace is \"payments\".",
+		StdErrContains: []string{"switched dev/default -> prod/payments\n"},
+	})
+
+	c.Run(t, TestCase{
+		Command:        "exec -- true",
+		StdErrContains: []string{"exec in prod exited 0\n"},
+	})
+	t.Setenv("BLOCK_EXEC", "yes")
+	c.Run(t, TestCase{
+		Command:       "exec -- echo unreachable",
+		ExpectedError: fmt.Errorf("pre-exec hook \"test -z \\\"$BLOCK_EXEC\\\"\" failed: exit status 1"),
+		StdOut:        "",
+	})
+	t.Setenv("BLOCK_EXEC", "")
+
+	c.Run(t, TestCase{
+		Command: "add -c staging --address staging:7233",
+		StdErrContains: []string{
+			"added staging at staging:7233\n",
+			"switched prod/payments -> staging/default\n",
+		},
+	})
+
+	// Post hooks can't undo the action, so failures are only reported
+	c.Run(t, TestCase{
+		Command:        "delete -c staging",
+		StdOut:         "Context \"staging\" deleted.",
+		StdErrContains: []string{"deleted staging\n", "warning: post-delete hook"},
+	})
+}
+
+func TestExecHooksPrepareCredentials(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, []byte(strings.ReplaceAll(`{
+  "active": "cloud",
+  "contexts": {
+    "broken": {
+      "address": "broken:7233",
+      "namespace": "default",
+      "labels": {"env": "prod"},
+      "auth": {"type": "bearer-exec", "command": "exit 1"}
+    },
+    "cloud": {
+      "address": "cloud:7233",
+      "namespace": "default",
+      "labels": {"env": "prod"},
+      "auth": {"type": "bearer-exec", "command": "cat TOKEN"},
+      "hooks": {"preExec": ["echo secret > TOKEN"]}
+    }
+  }
+}`, "TOKEN", filepath.Join(dir, "token"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := tctxConfigFile(configPath)
+
+	// The token only exists once the pre-exec hook has run
+	c.Run(t, TestCase{
+		Command: "exec -- printenv TEMPORAL_GRPC_META",
+		StdOut:  "authorization=Bearer secret",
+	})
+
+	// Contexts whose credentials fail are reported without stopping the others
+	c.Run(t, TestCase{
+		Command:        "exec -l env=prod -- printenv TEMPORAL_CLI_ADDRESS",
+		ExpectedError:  fmt.Errorf("command failed for contexts: broken"),
+		StdOut:         "cloud:7233",
+		StdErrContains: []string{"==> broken\nerror: ", "==> cloud\n"},
+	})
+}
+
+func TestCertProvider(t *testing.T) {
+	// Certificates are cached in the user cache directory
+	cacheDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+	t.Setenv("HOME", cacheDir)
+
+	credential, err := filepath.Abs(filepath.Join("testdata", "certprovider", "credential.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	issuer := filepath.Join(dir, "issue-cert")
+	if err := os.WriteFile(issuer, []byte(fmt.Sprintf("#!/bin/sh\necho run >> %s\ncat %s\n", runs, credential)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{Command: "add -c prod --address prod:7233 --tls_cert_provider " + issuer})
+	c.Run(t, TestCase{
+		Command: "show -o template={{.TLS.CertProvider}}",
+		StdOut:  issuer,
+	})
+
+	for i := 0; i < 2; i++ {
+		c.Run(t, TestCase{
+			Command:        "exec -- printenv TEMPORAL_CLI_TLS_CERT TEMPORAL_CLI_TLS_KEY",
+			StdOutContains: []string{filepath.Join(cacheDir, "tctx", "certs"), "cert.pem\n", "key.pem"},
+		})
+	}
+	// The certificate is cached until it is about to expire
+	b, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "run"); n != 1 {
+		t.Errorf("expected certificate provider to run once, ran %d times", n)
+	}
+
+	c.Run(t, TestCase{Command: "add -c broken --address broken:7233 --tls_cert_provider false"})
+	c.Run(t, TestCase{
+		Command:       "exec -- true",
+		ExpectedError: fmt.Errorf("error running certificate provider: exit status 1"),
+	})
+}
+
+func TestInlineTLSData(t *testing.T) {
+	credential, err := os.ReadFile(filepath.Join("testdata", "certprovider", "credential.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pems struct {
+		ClientCertificateData string
+		ClientKeyData         string
+	}
+	if err := json.Unmarshal(credential, &pems); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TCTX_TEST_TLS_KEY", pems.ClientKeyData)
+
+	// The child sees private temporary files holding the inline data
+	dir := t.TempDir()
+	script := filepath.Join(dir, "inspect")
+	if err := os.WriteFile(script, []byte(`#!/bin/sh
+ls -l "$TEMPORAL_CLI_TLS_KEY" | cut -c1-10
+cat "$TEMPORAL_CLI_TLS_CERT" "$TEMPORAL_CLI_TLS_KEY"
+echo "$TEMPORAL_CLI_TLS_KEY" > `+filepath.Join(dir, "key-path")+`
+`), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{Command: "add -c prod --address prod:7233 --tls_key_data env:TCTX_TEST_TLS_KEY --tls_cert_data " +
+		base64.StdEncoding.EncodeToString([]byte(pems.ClientCertificateData))})
+	c.Run(t, TestCase{
+		Command: "exec -- " + script,
+		StdOut:  "-rw-------\n" + pems.ClientCertificateData + pems.ClientKeyData,
+	})
+
+	// Files are removed once the child exits
+	keyPath, err := os.ReadFile(filepath.Join(dir, "key-path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(strings.TrimSpace(string(keyPath))); !os.IsNotExist(err) {
+		t.Errorf("expected temporary key file to be removed, got %v", err)
+	}
+
+	c.Run(t, TestCase{
+		Command: "show -o template={{.TLS.KeyData}}",
+		StdOut:  "env:TCTX_TEST_TLS_KEY",
+	})
+	c.Run(t, TestCase{Command: "update -c prod --tls_key_data " + base64.StdEncoding.EncodeToString([]byte(pems.ClientKeyData))})
+	c.Run(t, TestCase{
+		Command: "show -o template={{.TLS.KeyData}}",
+		StdOut:  "********",
+	})
+}
+
+func TestTLSGen(t *testing.T) {
+	configDir := t.TempDir()
+	tlsDir := filepath.Join(configDir, "tls", "local-mtls")
+	c := tctxConfigFile(filepath.Join(configDir, "config.json"))
+
+	c.Run(t, TestCase{
+		Command: "tls gen -c local-mtls --hosts localhost,127.0.0.1 --valid_for 24h",
+		StdOutContains: []string{
+			"Context \"local-mtls\" added to use the client certificate.",
+			"Server certificate: " + filepath.Join(tlsDir, "server.pem"),
+			"Server key:         " + filepath.Join(tlsDir, "server-key.pem"),
+		},
+	})
+	c.Run(t, TestCase{
+		Command: "show -c local-mtls -o template={{.Address}}|{{.TLS.CertPath}}|{{.TLS.KeyPath}}|{{.TLS.CACertPath}}",
+		StdOut: strings.Join([]string{
+			"localhost:7233",
+			filepath.Join(tlsDir, "client.pem"),
+			filepath.Join(tlsDir, "client-key.pem"),
+			filepath.Join(tlsDir, "ca.pem"),
+		}, "|"),
+	})
+	c.Run(t, TestCase{
+		Command:       "tls gen -c local-mtls",
+		ExpectedError: fmt.Errorf("certificates already exist in %s: use --rotate to replace them", tlsDir),
+	})
+
+	// Existing contexts keep their other settings, but no longer use inline
+	// data or a certificate provider
+	c.Run(t, TestCase{Command: "update -c local-mtls --address 127.0.0.1:7233 --tls_cert_provider issue-cert"})
+	c.Run(t, TestCase{
+		Command:        "tls gen -c local-mtls --rotate",
+		StdOutContains: []string{"Context \"local-mtls\" updated to use the client certificate."},
+	})
+	c.Run(t, TestCase{
+		Command: "show -c local-mtls -o template={{.Address}}|{{.TLS.CertProvider}}|{{.TLS.CertPath}}",
+		StdOut:  "127.0.0.1:7233||" + filepath.Join(tlsDir, "client.pem"),
+	})
+	// The rotation is a single change
+	c.Run(t, TestCase{
+		Command: "undo",
+		StdOut:  "Reverted change 3: tctx tls gen -c local-mtls --rotate",
+	})
+	c.Run(t, TestCase{
+		Command: "show -c local-mtls -o template={{.TLS.CertProvider}}",
+		StdOut:  "issue-cert",
+	})
+
+	// Contexts are checked before any certificate is written
+	c.Run(t, TestCase{
+		Command:       "tls gen -c invalid --address invalid",
+		ExpectedError: fmt.Errorf("invalid context \"invalid\": invalid address \"invalid\": must be host:port (use --force to save it anyway)"),
+	})
+	if entries, err := os.ReadDir(filepath.Dir(tlsDir)); err != nil || len(entries) != 1 {
+		t.Errorf("expected only the certificates of local-mtls, got %v (%v)", entries, err)
+	}
+
+	c.Run(t, TestCase{
+		Command:       "tls gen -c ../escape",
+		ExpectedError: fmt.Errorf("context name \"../escape\" cannot be used as a directory name"),
+	})
+}
+
+func TestRender(t *testing.T) {
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{
+		Command: "add -c prod --ns myapp --address prod:7233 --tls_server_name prod.example.com" +
+			" --tls_cert_path testdata/render/client.pem --tls_key_path testdata/render/client-key.pem" +
+			" --tls_ca_path testdata/render/ca.pem --env TEAM=payments --env API_TOKEN=hunter2",
+	})
+
+	for _, tc := range []struct {
+		golden  string
+		command string
+	}{
+		{"render-k8s.yaml", "render k8s -c prod --name my-worker"},
+		{"render-helm.yaml", "render helm -c prod --name my-worker"},
+		{"render-compose.yaml", "render compose -c prod --name my-worker"},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			app, buf, _ := c.newApp()
+			if err := app.Run(append([]string{"tctx"}, strings.Split(tc.command, " ")...)); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("testdata", "golden", tc.golden), buf.Bytes())
+		})
+	}
+
+	c.Run(t, TestCase{
+		Command:       "render k8s -c prod",
+		ExpectedError: fmt.Errorf("Required flag \"name\" not set"),
+	})
+
+	// Inline certificates cannot be mounted from the host
+	cert, err := os.ReadFile(filepath.Join("testdata", "render", "client.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Run(t, TestCase{
+		Command: "update -c prod --tls_cert_data " + base64.StdEncoding.EncodeToString(cert),
+	})
+	c.Run(t, TestCase{
+		Command:        "render k8s -c prod --name my-worker",
+		StdOutContains: []string{"  tls.crt: " + base64.StdEncoding.EncodeToString(cert)},
+	})
+	c.Run(t, TestCase{
+		Command:       "render compose -c prod --name my-worker",
+		ExpectedError: fmt.Errorf("compose output requires TLS files: inline tls.crt data is not supported"),
+	})
+}
+
+func TestDev(t *testing.T) {
+	fakeServer := filepath.Join(t.TempDir(), "fakeserver")
+	if out, err := exec.Command("go", "build", "-o", fakeServer, "./internal/devserver/testdata/fakeserver").CombinedOutput(); err != nil {
+		t.Fatalf("error building fake server: %s\n%s", err, out)
+	}
+	t.Setenv("TCTX_DEV_SERVER", fakeServer)
+	port, uiPort, otherPort := freePort(t), freePort(t), freePort(t)
+
+	c := tctxConfigFile(filepath.Join(t.TempDir(), "config.json"))
+	c.Run(t, TestCase{
+		Command:        fmt.Sprintf("dev up --port %d --ui_port %d", port, uiPort),
+		StdOutContains: []string{fmt.Sprintf("Context \"dev-%d\" added", port)},
+	})
+	c.Run(t, TestCase{
+		Command: fmt.Sprintf("show -c dev-%d -o template={{.Address}}|{{.WebAddress}}|{{.Namespace}}", port),
+		StdOut:  fmt.Sprintf("localhost:%d|http://localhost:%d|default", port, uiPort),
+	})
+	c.Run(t, TestCase{
+		Command:        fmt.Sprintf("dev up -c second --port %d", otherPort),
+		StdOutContains: []string{"Context \"second\" added"},
+	})
+	c.Run(t, TestCase{
+		Command: "dev ls",
+		StdOutContains: []string{
+			fmt.Sprintf("localhost:%d    http://localhost:%d    running", port, uiPort),
+			fmt.Sprintf("localhost:%d    http://localhost:%d    running", otherPort, otherPort+1000),
+		},
+	})
+	c.Run(t, TestCase{
+		Command:       "dev down",
+		ExpectedError: fmt.Errorf("several dev servers are running: use --context to choose one of dev-%d, second", port),
+	})
+	c.Run(t, TestCase{
+		Command: "dev down -c second",
+		StdOut:  "Dev server for context \"second\" stopped and context deleted.",
+	})
+	c.Run(t, TestCase{
+		Command: "dev down",
+		StdOut:  fmt.Sprintf("Dev server for context \"dev-%d\" stopped and context deleted.", port),
+	})
+	c.Run(t, TestCase{
+		Command:           "list -o name",
+		StdOutNotContains: []string{"dev-", "second"},
+	})
+	c.Run(t, TestCase{
+		Command:       "dev down",
+		ExpectedError: fmt.Errorf("no dev servers are running"),
+	})
+
+	// Existing contexts are not replaced
+	c.Run(t, TestCase{Command: "add -c local --ns default --address localhost:7233"})
+	c.Run(t, TestCase{
+		Command:       fmt.Sprintf("dev up -c local --port %d", port),
+		ExpectedError: fmt.Errorf("a context with name \"local\" already exists"),
+	})
+
+	t.Setenv("FAKE_SERVER_FAIL", "unable to open database")
+	c.Run(t, TestCase{
+		Command:       fmt.Sprintf("dev up --port %d --ui_port %d", port, uiPort),
+		ExpectedError: fmt.Errorf("dev server exited before becoming healthy:\nunable to open database"),
+	})
+}
+
+// createFiles creates empty files in dir, such as the TLS files of contexts,
+// which must exist.
+func createFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// freePort returns a local port which is not in use.
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestPrompt(t *testing.T) {
+	t.Setenv(contextEnvVar, "")
+	t.Setenv(namespaceEnvVar, "")
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(`{
+  "contexts": {
+    "dev": {"address": "dev:7233", "namespace": "default"},
+    "prod-us": {"address": "prod-us:7233", "namespace": "orders"},
+    "prod-eu": {"address": "prod-eu:7233", "namespace": "orders"}
+  },
+  "prompt": {
+    "colors": [{"pattern": "prod-*", "color": "red"}]
+  }
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := tctxConfigFile(configPath)
+
+	// Prompts are empty until a context is selected
+	c.Run(t, TestCase{Command: "prompt", StdOut: ""})
+
+	c.Run(t, TestCase{Command: "use -c dev"})
+	c.Run(t, TestCase{Command: "prompt", StdOut: "dev:default"})
+	c.Run(t, TestCase{Command: "ns use payments"})
+	c.Run(t, TestCase{Command: "prompt --format {{.Context}}@{{.Address}}/{{.Namespace}}", StdOut: "dev@dev:7233/payments"})
+
+	c.Run(t, TestCase{Command: "use -c prod-us"})
+	c.Run(t, TestCase{Command: "prompt", StdOut: "\x1b[31mprod-us:orders\x1b[0m"})
+	c.Run(t, TestCase{Command: "prompt --shell zsh", StdOut: "%F{red}prod-us:orders%f"})
+	c.Run(t, TestCase{Command: "prompt --shell none", StdOut: "prod-us:orders"})
+
+	// A context's own color takes precedence over name patterns
+	c.Run(t, TestCase{Command: "update -c prod-us --ns orders --color yellow"})
+	c.Run(t, TestCase{Command: "prompt --shell tmux", StdOut: "#[fg=yellow]prod-us:orders#[default]"})
+	c.Run(t, TestCase{
+		Command:       "update -c prod-us --color crimson",
+		ExpectedError: fmt.Errorf("unsupported color \"crimson\": must be one of black, blue, cyan, green, magenta, red, white, yellow"),
+	})
+
+	// Session contexts are shown too
+	t.Setenv(contextEnvVar, "prod-eu")
+	c.Run(t, TestCase{Command: "prompt --shell bash", StdOut: "\x01\x1b[31m\x02prod-eu:orders\x01\x1b[0m\x02"})
+
+	// Prompts never create the config file
+	missing := filepath.Join(t.TempDir(), "tctx", "config.json")
+	tctxConfigFile(missing).Run(t, TestCase{Command: "prompt", StdOut: ""})
+	if _, err := os.Stat(filepath.Dir(missing)); !os.IsNotExist(err) {
+		t.Errorf("expected the config directory not to be created, got %v", err)
+	}
+}
+
+// BenchmarkPrompt measures `tctx prompt`, which runs on every shell prompt and
+// should take a few milliseconds at most.
+func BenchmarkPrompt(b *testing.B) {
+	b.Setenv(contextEnvVar, "")
+	b.Setenv(namespaceEnvVar, "")
+	configPath := filepath.Join(b.TempDir(), "config.json")
+	contexts := map[string]interface{}{}
+	for i := 0; i < 50; i++ {
+		contexts[fmt.Sprintf("prod-%d", i)] = map[string]string{"address": fmt.Sprintf("prod-%d:7233", i), "namespace": "default"}
+	}
+	cfg, err := json.Marshal(map[string]interface{}{
+		"active":   "prod-0",
+		"contexts": contexts,
+		"prompt":   map[string]interface{}{"colors": []map[string]string{{"pattern": "prod-*", "color": "red"}}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, cfg, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		app, buf, _ := tctxConfigFile(configPath).newApp()
+		if err := app.Run([]string{"tctx", "prompt"}); err != nil {
+			b.Fatal(err)
+		}
+		if buf.Len() == 0 {
+			b.Fatal("expected prompt output")
+		}
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	t.Setenv(contextEnvVar, "")
+	t.Setenv(namespaceEnvVar, "")
+	configDir := t.TempDir()
+	socketPath := filepath.Join(configDir, "daemon.sock")
+	c := tctxConfigFile(filepath.Join(configDir, "config.json"))
+	c.Run(t, TestCase{Command: "add -c down --ns default --address 127.0.0.1:1"})
+
+	// Without the daemon, no health is shown
+	c.Run(t, TestCase{Command: "list", StdOutNotContains: []string{"HEALTH"}})
+	c.Run(t, TestCase{
+		Command:       "daemon status",
+		ExpectedError: fmt.Errorf("daemon is not running: start it with `tctx daemon`"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app, buf, _ := c.newApp()
+	done := make(chan error)
+	go func() { done <- app.RunContext(ctx, []string{"tctx", "daemon", "--interval", "1h"}) }()
+
+	// Wait for the first poll
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		statuses, _ := daemon.Query(context.Background(), socketPath)
+		if statuses["down"] != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the daemon to poll contexts")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	c.Run(t, TestCase{
+		Command:       "daemon",
+		ExpectedError: fmt.Errorf("daemon is already running on %s", socketPath),
+	})
+	c.Run(t, TestCase{Command: "list", StdOutContains: []string{"HEALTH", "unhealthy"}})
+	c.Run(t, TestCase{Command: "list -o json", StdOutContains: []string{`"healthy": false`}})
+	c.Run(t, TestCase{Command: "daemon status", StdOutContains: []string{"down       127.0.0.1:1    unhealthy"}})
+	c.Run(t, TestCase{
+		Command:        "ns list --discover",
+		StdErrContains: []string{"warning: could not list namespaces in the cluster: "},
+	})
+
+	// Contexts added since the last poll have unknown health
+	c.Run(t, TestCase{Command: "add -c new --ns default --address 127.0.0.1:2"})
+	c.Run(t, TestCase{Command: "list", StdOutContains: []string{"unknown"}})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Daemon listening on "+socketPath) {
+		t.Errorf("unexpected daemon output: %s", buf.String())
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %v", err)
+	}
+	c.Run(t, TestCase{Command: "list", StdOutNotContains: []string{"HEALTH"}})
+}
+
+func TestWatch(t *testing.T) {
+	t.Setenv("TCTX_CONFIG_PATHS", "")
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	c := tctxConfigFile(configPath)
+	c.Run(t, TestCase{Command: "add -c dev --ns default --address dev:7233"})
+
+	c.Run(t, TestCase{
+		Command:       "watch --format xml",
+		ExpectedError: fmt.Errorf("unsupported event format \"xml\": must be one of text, json"),
+	})
+
+	// Events are read from the output of a running watch command
+	watchEvents := func(ctx context.Context, args ...string) (events <-chan string, done <-chan error) {
+		app, _, _ := c.newApp()
+		outReader, outWriter := io.Pipe()
+		errReader, errWriter := io.Pipe()
+		app.Writer, app.ErrWriter = outWriter, errWriter
+		lines, result := make(chan string, 10), make(chan error, 1)
+		go func() {
+			err := app.RunContext(ctx, append([]string{"tctx", "watch"}, args...))
+			_ = outWriter.Close()
+			_ = errWriter.Close()
+			result <- err
+		}()
+		go func() {
+			scanner := bufio.NewScanner(outReader)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+		scanner := bufio.NewScanner(errReader)
+		if !scanner.Scan() || scanner.Text() != fmt.Sprintf("Watching %s for changes.", configPath) {
+			t.Fatalf("unexpected watch output: %q", scanner.Text())
+		}
+		go func() { _, _ = io.Copy(io.Discard, errReader) }()
+		return lines, result
+	}
+	expect := func(events <-chan string, expected string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if !strings.Contains(e, expected) {
+				t.Errorf("expected event containing %q, got %q", expected, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %q", expected)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, done := watchEvents(ctx, "--format", "json")
+	c.Run(t, TestCase{Command: "add -c prod --ns orders --address prod:7233"})
+	expect(events, `{"type":"context-added",`)
+	expect(events, `"context":"prod","previous":"dev"}`)
+	c.Run(t, TestCase{Command: "ns use payments"})
+	expect(events, `"context":"prod","previous":"orders","namespace":"payments"}`)
+	c.Run(t, TestCase{Command: "use -c dev"})
+	expect(events, `"context":"dev","previous":"prod"}`)
+	c.Run(t, TestCase{Command: "delete -c prod"})
+	expect(events, `{"type":"context-removed",`)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Clusters are reported unreachable when checked
+	c.Run(t, TestCase{Command: "add -c down --ns default --address 127.0.0.1:1"})
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	events, done = watchEvents(ctx, "--health_interval", "1h")
+	for _, name := range []string{"dev", "down"} {
+		expect(events, "cluster-unreachable "+name+": ")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	t.Setenv(contextEnvVar, "")
+	t.Setenv(namespaceEnvVar, "")
+	// An empty variable would override the config file given to tests
+	t.Setenv(configPathEnvVar, "")
+	if err := os.Unsetenv(configPathEnvVar); err != nil {
+		t.Fatal(err)
+	}
+	bin, err := filepath.Abs(filepath.Join("testdata", "plugins", "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := filepath.Abs(filepath.Join("testdata", "plugins", "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+other)
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	c := tctxConfigFile(configPath)
+
+	c.Run(t, TestCase{
+		Command: "plugin list",
+		StdOutContains: []string{
+			"hello     " + filepath.Join(bin, "tctx-hello"),
+			"replay    " + filepath.Join(other, "tctx-replay"),
+		},
+		StdOutNotContains: []string{"tctx-list", "tctx-notes"},
+		StdErrContains: []string{
+			fmt.Sprintf("warning: %s is shadowed by the built-in command \"list\"", filepath.Join(bin, "tctx-list")),
+			fmt.Sprintf("warning: %s is shadowed by %s", filepath.Join(other, "tctx-hello"), filepath.Join(bin, "tctx-hello")),
+		},
+	})
+
+	// Plugins need a context, like exec
+	c.Run(t, TestCase{
+		Command:       "hello",
+		ExpectedError: fmt.Errorf("no contexts exist: create one with `tctx add`"),
+	})
+
+	c.Run(t, TestCase{Command: "add -c staging --address staging:7233 --ns orders"})
+	c.Run(t, TestCase{
+		Command: "hello --flag value",
+		StdOut: fmt.Sprintf(`args: --flag value
+context: staging
+namespace: orders
+config: %s
+address: staging:7233
+`, configPath),
+	})
+	c.Run(t, TestCase{Command: "replay wf-1", StdOut: "replaying wf-1"})
+
+	// Built-in commands take precedence over plugins
+	c.Run(t, TestCase{Command: "list", StdOutNotContains: []string{"plugin list"}})
+
+	c.Run(t, TestCase{
+		Command:       "unknown",
+		ExpectedError: fmt.Errorf("unknown command \"unknown\": no built-in command or tctx-unknown plugin on PATH"),
+	})
+	c.Run(t, TestCase{
+		Command:       "../bin/tctx-hello",
+		ExpectedError: fmt.Errorf("unknown command \"../bin/tctx-hello\": no built-in command or tctx-../bin/tctx-hello plugin on PATH"),
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	dir := t.TempDir()
+	c := tctxConfigFile(filepath.Join(dir, "config.json"))
+
+	// Settings are validated even without a policy file
+	for _, tc := range []struct {
+		command  string
+		expected string
+	}{
+		{
+			"add -c bad --address localhost",
+			`invalid context "bad": invalid address "localhost": must be host:port`,
+		},
+		{
+			"add -c bad --address localhost:7233 --web_address localhost:8080",
+			`invalid context "bad": invalid web address "localhost:8080": must be an http or https URL`,
+		},
+		{
+			"add -c bad --address localhost:7233 --tls_ca_path " + filepath.Join(dir, "missing.pem"),
+			`invalid context "bad": TLS CA certificate file ` + filepath.Join(dir, "missing.pem") + ` does not exist`,
+		},
+		{
+			"add -c bad --address localhost:7233 --tls_disable_host_verification",
+			`invalid context "bad": host verification can only be disabled when TLS is enabled`,
+		},
+	} {
+		c.Run(t, TestCase{Command: tc.command, ExpectedError: fmt.Errorf("%s (use --force to save it anyway)", tc.expected)})
+	}
+	c.Run(t, TestCase{Command: "add -c bad --address localhost --force"})
+	// Problems the context already had are only warned about
+	c.Run(t, TestCase{
+		Command:        "update -c bad --description Legacy",
+		StdErrContains: []string{`warning: context "bad": invalid address "localhost": must be host:port`},
+	})
+	// So are TLS files saved with --force before they were issued
+	c.Run(t, TestCase{Command: "add -c pending --address localhost:7233 --tls_ca_path " + filepath.Join(dir, "missing.pem") + " --force"})
+	c.Run(t, TestCase{
+		Command:        "update -c pending --description Pending",
+		StdErrContains: []string{`warning: context "pending": TLS CA certificate file ` + filepath.Join(dir, "missing.pem") + ` does not exist`},
+	})
+
+	if err := os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(`rules:
+  - name: prod-tls
+    selector: env=prod
+    requireTLS: true
+    requireCA: true
+    requireHostVerification: true
+    forbiddenEnvironment: [FOO]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ca := filepath.Join("testdata", "render", "ca.pem")
+
+	c.Run(t, TestCase{Command: "add -c dev --address dev:7233 --label env=dev --env FOO=bar"})
+	c.Run(t, TestCase{
+		Command:       "add -c prod --address prod:7233 --label env=prod",
+		ExpectedError: fmt.Errorf(`context "prod" violates policy: rule "prod-tls": must use TLS; rule "prod-tls": must set a CA certificate (use --force to save it anyway)`),
+	})
+	c.Run(t, TestCase{Command: "add -c prod --address prod:7233 --label env=prod --tls_ca_path " + ca})
+	c.Run(t, TestCase{
+		Command:       "update -c prod --tls_ca_path " + ca + " --tls_disable_host_verification --env FOO=bar",
+		ExpectedError: fmt.Errorf(`context "prod" violates policy: rule "prod-tls": must not disable host verification; rule "prod-tls": must not set environment variable FOO (use --force to save it anyway)`),
+	})
+	c.Run(t, TestCase{Command: "show -c prod", StdOutNotContains: []string{"FOO"}})
+	c.Run(t, TestCase{Command: "update -c prod --env FOO=bar --force"})
+	c.Run(t, TestCase{Command: "show -c prod", StdOutContains: []string{"FOO: bar"}})
+
+	// Labeling an existing context can bring it under a rule
+	c.Run(t, TestCase{
+		Command:       "update -c dev --label env=prod",
+		ExpectedError: fmt.Errorf(`context "dev" violates policy: rule "prod-tls": must use TLS; rule "prod-tls": must set a CA certificate; rule "prod-tls": must not set environment variable FOO (use --force to save it anyway)`),
+	})
+
+	bundlePath := filepath.Join(dir, "team.yaml")
+	if err := os.WriteFile(bundlePath, []byte("version: 1\ncontexts:\n  prod-eu:\n    address: prod-eu:7233\n    namespace: default\n    labels:\n      env: prod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.Run(t, TestCase{
+		Command:       "import " + bundlePath,
+		ExpectedError: fmt.Errorf(`context "prod-eu" violates policy: rule "prod-tls": must use TLS; rule "prod-tls": must set a CA certificate (use --force to save it anyway)`),
+	})
+	c.Run(t, TestCase{Command: "list", StdOutNotContains: []string{"prod-eu"}})
+	c.Run(t, TestCase{Command: "import --force " + bundlePath, StdOut: "Added: prod-eu"})
+
+	// Synced contexts are checked too
+	if err := os.WriteFile(bundlePath, []byte("version: 1\ncontexts:\n  prod-us:\n    address: prod-us:7233\n    namespace: default\n    labels:\n      env: prod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.Run(t, TestCase{
+		Command:       "sync --source " + bundlePath,
+		ExpectedError: fmt.Errorf(`context "prod-us" violates policy: rule "prod-tls": must use TLS; rule "prod-tls": must set a CA certificate (use --force to save it anyway)`),
+	})
+	c.Run(t, TestCase{Command: "list", StdOutNotContains: []string{"prod-us"}})
+	c.Run(t, TestCase{Command: "sync --force --source " + bundlePath, StdOut: "Added: prod-us"})
+}
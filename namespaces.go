@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/client"
+	"github.com/jlegrone/tctx/config"
+)
+
+// discoveryTimeout bounds how long listing namespaces in a cluster may delay
+// output such as shell completions.
+const discoveryTimeout = time.Second
+
+// discoverNamespaces returns the namespaces registered in the cluster of a
+// context, as cached by `tctx daemon` when it is running, or else by asking
+// the cluster.
+func discoverNamespaces(c *cli.Context, name string, cfg *config.ClusterConfig) ([]string, error) {
+	if status := daemonStatuses(c).Get(name, cfg); status != nil {
+		return status.Namespaces, status.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context, discoveryTimeout)
+	defer cancel()
+
+	opts, err := client.NewOptions(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := opts.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return client.ListNamespaces(ctx, conn)
+}
+
+// completeNamespaces prints the namespaces of the selected context for shell
+// completion: favorites first, followed by namespaces discovered in the
+// cluster when it can be reached.
+func completeNamespaces(c *cli.Context) {
+	t, err := getConfigManager(c)
+	if err != nil {
+		return
+	}
+	resolved, err := resolveContext(c, t)
+	if err != nil {
+		return
+	}
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return
+	}
+	// Completions must still work offline, so discovery errors are ignored
+	discovered, _ := discoverNamespaces(c, resolved.Name, resolved.Config)
+	for _, ns := range all.KnownNamespaces(resolved.Name, discovered...) {
+		_, _ = fmt.Fprintln(c.App.Writer, ns)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jlegrone/tctx/config"
+)
+
+// Output formats accepted by the --output flag. Templates are given as
+// "template=<go template>".
+const (
+	outputTable    = ""
+	outputWide     = "wide"
+	outputName     = "name"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputTemplate = "template"
+)
+
+// maskedValue replaces the values of secret environment variables in output.
+const maskedValue = "********"
+
+// contextOutput is the schema of contexts printed by `tctx list` and `tctx
+// show` in JSON, YAML and template formats. Fields may be added, but existing
+// fields must not be renamed or removed.
+type contextOutput struct {
+	Name string `json:"name" yaml:"name"`
+	// Whether the context is selected for the current directory and session
+	Active bool `json:"active" yaml:"active"`
+	// "user" for contexts defined in the user config file, otherwise the
+	// path of the read-only config file defining the context
+	Source      string            `json:"source" yaml:"source"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Color of the context in shell prompts
+	Color     string `json:"color,omitempty" yaml:"color,omitempty"`
+	Address   string `json:"address" yaml:"address"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// Favorite namespaces, in the order they were added
+	FavoriteNamespaces []string    `json:"favoriteNamespaces,omitempty" yaml:"favoriteNamespaces,omitempty"`
+	WebAddress         string      `json:"webAddress,omitempty" yaml:"webAddress,omitempty"`
+	TLS                *tlsOutput  `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Auth               *authOutput `json:"auth,omitempty" yaml:"auth,omitempty"`
+	HeadersProvider    string      `json:"headersProvider,omitempty" yaml:"headersProvider,omitempty"`
+	DataConverter      string      `json:"dataConverter,omitempty" yaml:"dataConverter,omitempty"`
+	// Additional environment variables, with secret values masked
+	Environment map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// Health last reported by `tctx daemon`, when it is running
+	Health *healthOutput `json:"health,omitempty" yaml:"health,omitempty"`
+}
+
+type healthOutput struct {
+	Healthy   bool      `json:"healthy" yaml:"healthy"`
+	Error     string    `json:"error,omitempty" yaml:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt" yaml:"checkedAt"`
+}
+
+type tlsOutput struct {
+	CertPath   string `json:"certPath,omitempty" yaml:"certPath,omitempty"`
+	KeyPath    string `json:"keyPath,omitempty" yaml:"keyPath,omitempty"`
+	CACertPath string `json:"caPath,omitempty" yaml:"caPath,omitempty"`
+	CertData   string `json:"certData,omitempty" yaml:"certData,omitempty"`
+	// Inline private key, masked unless it is a reference such as env:NAME
+	KeyData                 string `json:"keyData,omitempty" yaml:"keyData,omitempty"`
+	CAData                  string `json:"caData,omitempty" yaml:"caData,omitempty"`
+	ServerName              string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	DisableHostVerification bool   `json:"disableHostVerification,omitempty" yaml:"disableHostVerification,omitempty"`
+	CertProvider            string `json:"certProvider,omitempty" yaml:"certProvider,omitempty"`
+}
+
+type authOutput struct {
+	Type      string `json:"type" yaml:"type"`
+	Header    string `json:"header" yaml:"header"`
+	SecretRef string `json:"secretRef,omitempty" yaml:"secretRef,omitempty"`
+	Command   string `json:"command,omitempty" yaml:"command,omitempty"`
+}
+
+// newContextOutputs returns the output schema of every context in contexts
+// matching selector, sorted by name.
+func newContextOutputs(contexts *config.Config, userConfigPath, activeContext string, selector config.Selector) []*contextOutput {
+	var names []string
+	for name, cfg := range contexts.Contexts {
+		if selector.Matches(cfg.Labels) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := make([]*contextOutput, 0, len(names))
+	for _, name := range names {
+		source := contexts.Sources[name]
+		if source == userConfigPath {
+			source = "user"
+		}
+		output := newContextOutput(name, contexts.Contexts[name], source, name == activeContext)
+		output.FavoriteNamespaces = contexts.GetFavoriteNamespaces(name)
+		result = append(result, output)
+	}
+	return result
+}
+
+func newContextOutput(name string, cfg *config.ClusterConfig, source string, active bool) *contextOutput {
+	result := &contextOutput{
+		Name:            name,
+		Active:          active,
+		Source:          source,
+		Description:     cfg.Description,
+		Labels:          cfg.Labels,
+		Color:           cfg.Color,
+		Address:         cfg.Address,
+		Namespace:       cfg.Namespace,
+		WebAddress:      cfg.WebAddress,
+		HeadersProvider: cfg.HeadersProvider,
+		DataConverter:   cfg.DataConverter,
+	}
+	if tls := cfg.TLS; tls != nil && *tls != (config.TLSConfig{}) {
+		result.TLS = &tlsOutput{
+			CertPath:                tls.CertPath,
+			KeyPath:                 tls.KeyPath,
+			CACertPath:              tls.CACertPath,
+			CertData:                tls.CertData,
+			KeyData:                 tls.KeyData,
+			CAData:                  tls.CAData,
+			ServerName:              tls.ServerName,
+			DisableHostVerification: tls.DisableHostVerification,
+			CertProvider:            tls.CertProvider,
+		}
+		if tls.KeyData != "" && config.ValidateSecretRef(tls.KeyData) != nil {
+			result.TLS.KeyData = maskedValue
+		}
+	}
+	if auth := cfg.Auth; auth != nil {
+		result.Auth = &authOutput{
+			Type:      auth.Type,
+			Header:    auth.GetHeader(),
+			SecretRef: auth.SecretRef,
+			Command:   auth.Command,
+		}
+	}
+	for k, v := range cfg.Environment {
+		if result.Environment == nil {
+			result.Environment = map[string]string{}
+		}
+		if config.IsSecretEnvVar(k) {
+			v = maskedValue
+		}
+		result.Environment[k] = v
+	}
+	return result
+}
+
+// tlsSummary describes the transport security of a context in a word.
+func (o *contextOutput) tlsSummary() string {
+	switch {
+	case o.TLS == nil:
+		return ""
+	case o.TLS.CertPath != "" || o.TLS.CertData != "" || o.TLS.CertProvider != "":
+		return "mtls"
+	default:
+		return "tls"
+	}
+}
+
+// outputFormat is a parsed --output flag.
+type outputFormat struct {
+	kind     string
+	template *template.Template
+}
+
+// parseOutputFormat parses the value of the --output flag, which must be one of
+// allowed.
+func parseOutputFormat(value string, allowed ...string) (*outputFormat, error) {
+	kind, text, isTemplate := strings.Cut(value, "=")
+	if isTemplate && kind != outputTemplate {
+		kind = value
+	}
+	for _, a := range allowed {
+		if kind != a {
+			continue
+		}
+		result := &outputFormat{kind: kind}
+		if kind == outputTemplate {
+			tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid output template: %w", err)
+			}
+			result.template = tmpl
+		}
+		return result, nil
+	}
+
+	var names []string
+	for _, a := range allowed {
+		if a == outputTemplate {
+			a += "=<go template>"
+		}
+		if a != outputTable {
+			names = append(names, a)
+		}
+	}
+	return nil, fmt.Errorf("unsupported output format %q: must be one of %s", value, strings.Join(names, ", "))
+}
+
+// write prints v in a structured format. Templates are executed for each item
+// if v is a slice, and each result is printed on its own line.
+func (f *outputFormat) write(w io.Writer, v interface{}) error {
+	switch f.kind {
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputTemplate:
+		items, ok := v.([]*contextOutput)
+		if !ok {
+			items = []*contextOutput{v.(*contextOutput)}
+		}
+		for _, item := range items {
+			if err := f.template.Execute(w, item); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q", f.kind)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/internal/plugin"
+)
+
+// configPathEnvVar passes the config file in use on to plugins, and to any
+// tctx commands they run
+const configPathEnvVar = "TCTX_CONFIG_PATH"
+
+// runPlugin runs an unknown subcommand `tctx <name>` as the executable
+// tctx-<name> from the PATH, with the environment of the resolved context.
+// Built-in commands always take precedence over plugins.
+func runPlugin(c *cli.Context) error {
+	name := c.Args().First()
+	path, err := plugin.Find(name)
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("unknown command %q: no built-in command or %s%s plugin on PATH", name, plugin.Prefix, name)
+	} else if err != nil {
+		return err
+	}
+
+	t, err := getConfigManager(c)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveContext(c, t)
+	if err != nil {
+		return err
+	}
+	for _, warning := range resolved.Warnings {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", warning)
+	}
+
+	cmd := exec.Command(path, c.Args().Tail()...)
+	cmd.Stdin = c.App.Reader
+	cmd.Stdout = c.App.Writer
+	cmd.Stderr = c.App.ErrWriter
+
+	all, err := t.GetAllContexts()
+	if err != nil {
+		return err
+	}
+	// Nested tctx commands select the same context and config file
+	return runWithExecHooks(c, all, resolved, cmd,
+		fmt.Sprintf("%s=%s", contextEnvVar, resolved.Name),
+		fmt.Sprintf("%s=%s", namespaceEnvVar, resolved.Config.Namespace),
+		fmt.Sprintf("%s=%s", configPathEnvVar, c.String(configPathFlag)),
+	)
+}
+
+// listPlugins prints the plugins found on the PATH.
+func listPlugins(c *cli.Context) error {
+	format, err := parseOutputFormat(c.String(outputFlag), outputTable, outputJSON, outputYAML)
+	if err != nil {
+		return err
+	}
+	plugins := []plugin.Plugin{}
+	for _, p := range plugin.List(os.Getenv("PATH")) {
+		if c.App.Command(p.Name) != nil {
+			_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s is shadowed by the built-in command %q\n", p.Path, p.Name)
+			continue
+		}
+		for _, shadowed := range p.Shadows {
+			_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: %s is shadowed by %s\n", shadowed, p.Path)
+		}
+		plugins = append(plugins, p)
+	}
+	if format.kind != outputTable {
+		return format.write(c.App.Writer, plugins)
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 1, 1, 4, ' ', 0)
+	if _, err := fmt.Fprintln(w, "NAME\tPATH\t"); err != nil {
+		return err
+	}
+	for _, p := range plugins {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t\n", p.Name, p.Path); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
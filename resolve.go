@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/project"
+)
+
+const (
+	// contextEnvVar selects a context for the current shell session
+	contextEnvVar = "TCTX_CONTEXT"
+	// namespaceEnvVar overrides the namespace of the session context
+	namespaceEnvVar = "TCTX_NAMESPACE"
+	// activeContextSource describes contexts selected by `tctx use`
+	activeContextSource = "active context"
+)
+
+// resolvedContext is the context selected for a command, in order of
+// precedence, by the --context flag, the session environment, a project file,
+// or the active context.
+type resolvedContext struct {
+	Name string
+	// Cluster configuration with the selected namespace applied
+	Config *config.ClusterConfig
+	// Description of what selected the context
+	Source string
+	// Project file in effect, if any
+	Project *project.File
+	// Environment variables from a trusted project file
+	Environment map[string]string
+	// Warnings to be shown to the user
+	Warnings []string
+}
+
+func resolveContext(c *cli.Context, t *config.ConfigManager) (*resolvedContext, error) {
+	var (
+		result    = &resolvedContext{}
+		namespace string
+		err       error
+	)
+
+	switch {
+	case c.String(contextNameFlag) != "":
+		result.Name, result.Source = c.String(contextNameFlag), "--context flag"
+	case os.Getenv(contextEnvVar) != "":
+		result.Name, result.Source = os.Getenv(contextEnvVar), contextEnvVar
+		namespace = os.Getenv(namespaceEnvVar)
+	default:
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if result.Project, err = project.Find(wd); err != nil {
+			return nil, err
+		}
+		if result.Project != nil {
+			result.Name, result.Source = result.Project.Context, result.Project.Path
+			namespace = result.Project.Namespace
+		} else {
+			if result.Name, err = t.GetActiveContextName(); err != nil {
+				return nil, err
+			}
+			result.Source = activeContextSource
+		}
+	}
+
+	cfg, err := t.GetContext(result.Name)
+	if err != nil {
+		if result.Project != nil {
+			return nil, fmt.Errorf("%w (selected by %s)", err, result.Project.Path)
+		}
+		return nil, err
+	}
+	resolved := *cfg
+	if namespace != "" {
+		resolved.Namespace = namespace
+	}
+	result.Config = &resolved
+
+	// Project files could come from anywhere, so environment variables are only
+	// applied once the user has reviewed and trusted the file.
+	if result.Project != nil && len(result.Project.Environment) > 0 {
+		trusted, err := getTrustStore(c).IsTrusted(result.Project)
+		if err != nil {
+			return nil, err
+		}
+		if trusted {
+			result.Environment = result.Project.Environment
+		} else {
+			result.Warnings = append(result.Warnings, fmt.Sprintf(
+				"ignoring environment variables from untrusted project file %s: review it and run `tctx trust` to apply them",
+				result.Project.Path,
+			))
+		}
+	}
+
+	return result, nil
+}
+
+func getTrustStore(c *cli.Context) *project.TrustStore {
+	return project.NewTrustStore(filepath.Join(filepath.Dir(c.String(configPathFlag)), "trusted_projects.json"))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/jlegrone/tctx/config"
+	"github.com/jlegrone/tctx/internal/share"
+)
+
+// getSigningKeyPath returns the path of the key used by `tctx share --sign`.
+func getSigningKeyPath(c *cli.Context) string {
+	return filepath.Join(filepath.Dir(c.String(configPathFlag)), "share_key.pem")
+}
+
+// configFromToken decodes the --from_token flag and applies any other flags
+// given on the command line. Unless --yes is set, the result is previewed and
+// the user is asked to confirm it; nil is returned if they decline.
+func configFromToken(c *cli.Context, name string) (*config.ClusterConfig, error) {
+	token, err := share.Decode(c.String(fromTokenFlag))
+	if err != nil {
+		return nil, err
+	}
+	if signer := c.String(signerFlag); signer != "" && token.Signer != signer {
+		if token.Signer == "" {
+			return nil, fmt.Errorf("token is not signed: expected a signature by %s", signer)
+		}
+		return nil, fmt.Errorf("token is signed by %s: expected a signature by %s", token.Signer, signer)
+	}
+
+	_, _, flags, err := configFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	cfg := token.Context
+	for flag, field := range map[string]*string{
+		addressFlag:               &cfg.Address,
+		webAddressFlag:            &cfg.WebAddress,
+		namespaceFlag:             &cfg.Namespace,
+		headersProviderPluginFlag: &cfg.HeadersProvider,
+		dataConverterPluginFlag:   &cfg.DataConverter,
+		descriptionFlag:           &cfg.Description,
+	} {
+		if c.IsSet(flag) {
+			*field = c.String(flag)
+		}
+	}
+	for _, flag := range []string{tlsCertFlag, tlsKeyFlag, tlsCAFlag, tlsServerNameFlag, tlsDisableHostVerificationFlag, tlsCertProviderFlag,
+		tlsCertDataFlag, tlsKeyDataFlag, tlsCADataFlag} {
+		if !c.IsSet(flag) {
+			continue
+		}
+		if cfg.TLS == nil {
+			cfg.TLS = &config.TLSConfig{}
+		}
+		switch flag {
+		case tlsCertFlag:
+			cfg.TLS.CertPath = flags.TLS.CertPath
+		case tlsKeyFlag:
+			cfg.TLS.KeyPath = flags.TLS.KeyPath
+		case tlsCAFlag:
+			cfg.TLS.CACertPath = flags.TLS.CACertPath
+		case tlsServerNameFlag:
+			cfg.TLS.ServerName = flags.TLS.ServerName
+		case tlsDisableHostVerificationFlag:
+			cfg.TLS.DisableHostVerification = flags.TLS.DisableHostVerification
+		case tlsCertProviderFlag:
+			cfg.TLS.CertProvider = flags.TLS.CertProvider
+		case tlsCertDataFlag:
+			cfg.TLS.CertData = flags.TLS.CertData
+		case tlsKeyDataFlag:
+			cfg.TLS.KeyData = flags.TLS.KeyData
+		case tlsCADataFlag:
+			cfg.TLS.CAData = flags.TLS.CAData
+		}
+	}
+	if flags.Auth != nil {
+		if cfg.Auth == nil {
+			cfg.Auth = &config.AuthConfig{}
+		}
+		for _, f := range []struct{ from, to *string }{
+			{&flags.Auth.Type, &cfg.Auth.Type},
+			{&flags.Auth.Header, &cfg.Auth.Header},
+			{&flags.Auth.SecretRef, &cfg.Auth.SecretRef},
+			{&flags.Auth.Command, &cfg.Auth.Command},
+		} {
+			if *f.from != "" {
+				*f.to = *f.from
+			}
+		}
+	}
+	for k, v := range flags.Labels {
+		if cfg.Labels == nil {
+			cfg.Labels = map[string]string{}
+		}
+		if v == "" {
+			delete(cfg.Labels, k)
+		} else {
+			cfg.Labels[k] = v
+		}
+	}
+	for k, v := range flags.Environment {
+		if cfg.Environment == nil {
+			cfg.Environment = map[string]string{}
+		}
+		cfg.Environment[k] = v
+	}
+
+	// Secrets are never shared, so they must be provided by the recipient
+	var missing, missingEnv []string
+	for _, field := range token.Omitted {
+		switch {
+		case field == "tls.keyPath" && cfg.GetTLS().KeyPath == "":
+			missing = append(missing, "--"+tlsKeyFlag)
+		case field == "tls.keyData" && cfg.GetTLS().KeyData == "" && cfg.GetTLS().KeyPath == "":
+			missing = append(missing, "--"+tlsKeyDataFlag)
+		case field == "auth.secretRef" && (cfg.Auth == nil || cfg.Auth.SecretRef == ""):
+			missing = append(missing, "--"+authSecretRefFlag)
+		case strings.HasPrefix(field, "env."):
+			if _, ok := cfg.Environment[strings.TrimPrefix(field, "env.")]; !ok {
+				missingEnv = append(missingEnv, strings.TrimPrefix(field, "env."))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("token does not include secrets: provide them with %s", strings.Join(missing, ", "))
+	}
+	sort.Strings(missingEnv)
+	for _, k := range missingEnv {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: environment variable %s was not shared: set it with --%s %s=<value>\n", k, envFlag, k)
+	}
+
+	// The token carries its own key, so a signature proves nothing until the
+	// signer is checked against --signer
+	verified := c.IsSet(signerFlag)
+	if c.Bool(yesFlag) {
+		if !verified {
+			_, _ = fmt.Fprintf(c.App.ErrWriter, "warning: the token is not authenticated: pass --%s to check who created it\n", signerFlag)
+		}
+		return cfg, nil
+	}
+
+	preview, err := share.Preview(cfg)
+	if err != nil {
+		return nil, err
+	}
+	signedBy := "unsigned"
+	switch {
+	case verified:
+		signedBy = "signed by " + token.Signer
+	case token.Signer != "":
+		signedBy = fmt.Sprintf("signed by %s, not verified", token.Signer)
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Context %q shared as %q (%s):\n\n", name, token.Name, signedBy)
+	for _, line := range strings.Split(strings.TrimSpace(string(preview)), "\n") {
+		_, _ = fmt.Fprintf(c.App.Writer, "    %s\n", line)
+	}
+	if !verified {
+		_, _ = fmt.Fprintf(c.App.Writer, "\nThis token is not authenticated: anyone could have created or modified it. Check these settings,\n"+
+			"or pass --%s with a fingerprint you trust.\n", signerFlag)
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "\nAdd this context? [y/N] ")
+
+	scanner := bufio.NewScanner(c.App.Reader)
+	scanner.Scan()
+	if answer := strings.ToLower(strings.TrimSpace(scanner.Text())); answer != "y" && answer != "yes" {
+		return nil, scanner.Err()
+	}
+	return cfg, nil
+}